@@ -3,6 +3,8 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -12,6 +14,29 @@ type Config struct {
 	JWTSecret string
 	RedisAddr     string
 	RedisPassword string
+	StoreBackend  string // "redis" (default) or "memory"
+	// How long a player may be disconnected from a running game before the
+	// opponent is told they are not coming back.
+	ReconnectGrace time.Duration
+	// Turn clock: seconds per shot, a per-player bank in seconds used once
+	// a shot's time runs out, and what to do on timeout ("random", "skip"
+	// or "forfeit"). Both durations 0, the default, disables timers.
+	TurnSeconds   int
+	BankSeconds   int
+	TimeoutAction string
+	// How long a player may wait in matchmaking before being dropped from
+	// the queue. 0 lets players wait for as long as they stay connected.
+	QueueMaxWait time.Duration
+	// How far behind the game spectators are kept, so they cannot pass on
+	// what they see to a player.
+	SpectatorDelay time.Duration
+	// WebSocket keepalive and limits: a connection that does not answer
+	// pings within WSPongWait is dropped, a write that takes longer than
+	// WSWriteWait fails, and a client message over WSMaxMessageBytes closes
+	// the connection. 0 disables each.
+	WSPongWait        time.Duration
+	WSWriteWait       time.Duration
+	WSMaxMessageBytes int64
 }
 
 func LoadConfig() Config {
@@ -26,5 +51,36 @@ func LoadConfig() Config {
 		JWTSecret: os.Getenv("JWT_SECRET"),
 		RedisAddr: os.Getenv("REDIS_ADDR"),
 		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		StoreBackend:  os.Getenv("STORE_BACKEND"),
+		ReconnectGrace: durationSeconds("RECONNECT_GRACE_SECONDS", 60),
+		TurnSeconds:    intValue("TURN_SECONDS", 0),
+		BankSeconds:    intValue("TURN_BANK_SECONDS", 0),
+		TimeoutAction:  os.Getenv("TURN_TIMEOUT_ACTION"),
+		QueueMaxWait:   durationSeconds("QUEUE_MAX_WAIT_SECONDS", 600),
+		SpectatorDelay: durationSeconds("SPECTATOR_DELAY_SECONDS", 0),
+		WSPongWait:        durationSeconds("WS_PONG_WAIT_SECONDS", 60),
+		WSWriteWait:       durationSeconds("WS_WRITE_WAIT_SECONDS", 10),
+		WSMaxMessageBytes: int64(intValue("WS_MAX_MESSAGE_BYTES", 8192)),
 	}
 }
+
+// durationSeconds reads a whole number of seconds from the environment,
+// falling back to def when unset or invalid.
+func durationSeconds(key string, def int) time.Duration {
+	return time.Duration(intValue(key, def)) * time.Second
+}
+
+// intValue reads a non-negative integer from the environment, falling back
+// to def when unset or invalid.
+func intValue(key string, def int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		log.Printf("Invalid %s=%q, using %d", key, value, def)
+		return def
+	}
+	return n
+}
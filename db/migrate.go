@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock is the Postgres advisory lock held while migrating, so that
+// instances starting together apply each migration once.
+const migrationLock = 7_346_118_201
+
+// Migrate applies the scripts in db/migrations that have not run yet, in file
+// name order, each in its own transaction. Applied scripts are recorded by
+// name in schema_migrations.
+func Migrate(conn *sql.DB) error {
+	ctx := context.Background()
+	c, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if _, err := c.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer c.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	_, err = c.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		name       TEXT        PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	files, err := fs.Glob(migrations, "migrations/*.sql") // sorted by name
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := path.Base(file)
+		var applied bool
+		err := c.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE name = $1)", name).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("failed to check migration %s: %w", name, err)
+		}
+		if applied {
+			continue
+		}
+		script, err := migrations.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		if err := apply(ctx, c, name, string(script)); err != nil {
+			return err
+		}
+		log.Printf("Applied migration %s", name)
+	}
+	return nil
+}
+
+func apply(ctx context.Context, c *sql.Conn, name, script string) error {
+	tx, err := c.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %s failed: %w", name, err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (name) VALUES ($1)", name); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", name, err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,5 @@
+-- Guest accounts have a generated username and no password until upgraded.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_guest BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ALTER COLUMN password DROP NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_guest_created_at_idx ON users (created_at) WHERE is_guest;
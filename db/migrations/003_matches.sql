@@ -0,0 +1,21 @@
+-- One row per finished game. Boards and the ordered shot log are stored as
+-- JSON so a game can be shown or replayed after its Redis state is gone.
+CREATE TABLE IF NOT EXISTS matches (
+    id                BIGSERIAL   PRIMARY KEY,
+    room_id           TEXT        NOT NULL,
+    player1_id        TEXT        NOT NULL,
+    player2_id        TEXT        NOT NULL,
+    winner_id         TEXT,
+    loser_id          TEXT,
+    mode              TEXT        NOT NULL DEFAULT 'classic',
+    started_at        TIMESTAMPTZ NOT NULL,
+    ended_at          TIMESTAMPTZ NOT NULL,
+    player1_board     JSONB       NOT NULL,
+    player2_board     JSONB       NOT NULL,
+    shots             JSONB       NOT NULL,
+    player1_elo_delta INT         NOT NULL DEFAULT 0,
+    player2_elo_delta INT         NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS matches_player1_idx ON matches (player1_id, ended_at DESC);
+CREATE INDEX IF NOT EXISTS matches_player2_idx ON matches (player2_id, ended_at DESC);
@@ -0,0 +1,2 @@
+-- Full ordered event log of the game, used to build replays.
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS events JSONB NOT NULL DEFAULT '[]';
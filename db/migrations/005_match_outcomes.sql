@@ -0,0 +1,5 @@
+-- Why a game ended when no fleet was sunk: resign, timeout, abandoned or
+-- draw. Drawn matches have no winner or loser.
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS end_reason TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE stats ADD COLUMN IF NOT EXISTS draws INT NOT NULL DEFAULT 0;
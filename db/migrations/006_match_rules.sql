@@ -0,0 +1,3 @@
+-- Ruleset of the game (mode, board size and fleet). NULL for matches played
+-- before custom rulesets, which used the classic board.
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS rules JSONB;
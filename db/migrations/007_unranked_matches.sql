@@ -0,0 +1,3 @@
+-- Games against a bot are unranked: they are kept in the history but do
+-- not change stats or Elo.
+ALTER TABLE matches ADD COLUMN IF NOT EXISTS ranked BOOLEAN NOT NULL DEFAULT TRUE;
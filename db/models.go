@@ -4,5 +4,17 @@ type User struct {
 	ID 	 int64    `json:"id" db:"id"`
 	Username string `json:"username" db:"username"`
 	Password string `json:"password" db:"password"` // Hashed password
+	IsGuest bool `json:"is_guest" db:"is_guest"`
 	CreatedAt string `json:"created_at" db:"created_at"`
-}
\ No newline at end of file
+}
+
+type RefreshToken struct {
+	ID         int64   `json:"id" db:"id"`
+	UserID     int64   `json:"user_id" db:"user_id"`
+	TokenHash  string  `json:"-" db:"token_hash"`
+	FamilyID   string  `json:"family_id" db:"family_id"`
+	ExpiresAt  string  `json:"expires_at" db:"expires_at"`
+	RevokedAt  *string `json:"revoked_at" db:"revoked_at"`
+	ReplacedBy *int64  `json:"replaced_by" db:"replaced_by"`
+	CreatedAt  string  `json:"created_at" db:"created_at"`
+}
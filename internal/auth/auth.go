@@ -3,7 +3,7 @@ package auth
 import (
 	"database/sql"
 	"fmt"
-	"time"
+	"strconv"
 
 	"errors"
 
@@ -52,25 +52,41 @@ func (s *Service) Register(username, password string) error {
 	return nil
 }
 
-func (s *Service) Login(username, password string) (string, error) {
+func (s *Service) Login(username, password string) (*TokenPair, error) {
 	var user db.User
-	err := s.db.QueryRow("SELECT id, username, password FROM users WHERE username = $1", username).Scan(&user.ID, &user.Username, &user.Password)
+	err := s.db.QueryRow("SELECT id, username, password FROM users WHERE username = $1 AND NOT is_guest", username).Scan(&user.ID, &user.Username, &user.Password)
 
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
-	tokenString, err := token.SignedString([]byte(s.cfg.JWTSecret))
+	return s.newSession(user.ID, false)
+}
+
+// ParseToken validates a signed access token and returns the player it was
+// issued to.
+func (s *Service) ParseToken(tokenString string) (Identity, error) {
+	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		return []byte(s.cfg.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
 	if err != nil {
-		return "", err
+		return Identity{}, err
 	}
-
-	return tokenString, nil
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return Identity{}, errors.New("invalid token claims")
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return Identity{}, errors.New("missing user_id claim")
+	}
+	guest, _ := claims["guest"].(bool)
+	return Identity{
+		UserID:   int64(userID),
+		PlayerID: strconv.FormatInt(int64(userID), 10),
+		Guest:    guest,
+	}, nil
 }
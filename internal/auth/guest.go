@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Guests that never upgraded and have no live session are purged after this.
+const GuestTTL = 30 * 24 * time.Hour
+
+var ErrNotGuest = errors.New("account is not a guest")
+
+// GuestLogin creates a temporary guest user and starts a session for it.
+func (s *Service) GuestLogin() (*TokenPair, error) {
+	var userID int64
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		var suffix string
+		suffix, err = randomToken(6)
+		if err != nil {
+			return nil, err
+		}
+		err = s.db.QueryRow(
+			"INSERT INTO users (username, password, is_guest) VALUES ($1, NULL, TRUE) RETURNING id",
+			"guest_"+suffix,
+		).Scan(&userID)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			continue
+		}
+		break
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create guest: %v", err)
+	}
+	log.Printf("Created guest user %d", userID)
+	return s.newSession(userID, true)
+}
+
+// UpgradeGuest turns a guest into a registered user. The user ID is kept, so
+// the stats row and match history keyed by it carry over. Guest sessions are
+// revoked and a fresh session is returned.
+func (s *Service) UpgradeGuest(userID int64, username, password string) (*TokenPair, error) {
+	if username == "" || password == "" {
+		return nil, fmt.Errorf("username and password cannot be empty")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.db.Exec(
+		"UPDATE users SET username = $2, password = $3, is_guest = FALSE WHERE id = $1 AND is_guest",
+		userID, username, string(hashedPassword),
+	)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, fmt.Errorf("username already exists")
+		}
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotGuest
+	}
+
+	if err := s.LogoutAll(userID); err != nil {
+		log.Printf("Failed to revoke guest sessions for %d: %v", userID, err)
+	}
+	log.Printf("Upgraded guest %d to user %s", userID, username)
+	return s.newSession(userID, false)
+}
+
+// PurgeGuests deletes guests older than GuestTTL that have no active refresh
+// token, together with their stats and the matches they played, so no row
+// is left naming a player that no longer exists.
+func (s *Service) PurgeGuests() (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`DELETE FROM users u WHERE u.is_guest AND u.created_at < $1
+		 AND NOT EXISTS (
+		     SELECT 1 FROM refresh_tokens t
+		     WHERE t.user_id = u.id AND t.revoked_at IS NULL AND t.expires_at > NOW()
+		 )
+		 RETURNING u.id::TEXT`,
+		time.Now().Add(-GuestTTL),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge guests: %v", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) > 0 {
+		if _, err := tx.Exec("DELETE FROM stats WHERE player_id = ANY($1)", pq.Array(ids)); err != nil {
+			return 0, fmt.Errorf("failed to purge guest stats: %v", err)
+		}
+		if _, err := tx.Exec("DELETE FROM matches WHERE player1_id = ANY($1) OR player2_id = ANY($1)", pq.Array(ids)); err != nil {
+			return 0, fmt.Errorf("failed to purge guest matches: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
+
+// RunGuestReaper purges expired guests on every tick of interval.
+func (s *Service) RunGuestReaper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		n, err := s.PurgeGuests()
+		if err != nil {
+			log.Printf("Guest purge failed: %v", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("Purged %d expired guest accounts", n)
+		}
+	}
+}
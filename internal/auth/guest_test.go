@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestPurgeGuests(t *testing.T) {
+	s, mock := newTestService(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM users u WHERE u\.is_guest`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("3").AddRow("5"))
+	// Everything keyed by the purged guests goes in the same transaction
+	mock.ExpectExec(`DELETE FROM stats WHERE player_id = ANY\(\$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`DELETE FROM matches WHERE player1_id = ANY\(\$1\) OR player2_id = ANY\(\$1\)`).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectCommit()
+
+	n, err := s.PurgeGuests()
+	if err != nil || n != 2 {
+		t.Errorf("PurgeGuests = %d, %v, want 2 guests", n, err)
+	}
+}
+
+func TestPurgeGuestsNone(t *testing.T) {
+	s, mock := newTestService(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM users u WHERE u\.is_guest`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectCommit()
+
+	if n, err := s.PurgeGuests(); err != nil || n != 0 {
+		t.Errorf("PurgeGuests = %d, %v, want none", n, err)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const identityKey contextKey = "identity"
+
+// WebSocket clients that cannot set headers offer "bearer" followed by the
+// token as subprotocols; the upgrader answers with "bearer" only.
+const BearerSubprotocol = "bearer"
+
+// Identity is the authenticated player attached to a request.
+type Identity struct {
+	UserID   int64
+	PlayerID string // UserID formatted as used by matchmaking and games
+	Guest    bool
+}
+
+// Middleware verifies the access token and stores the player identity in the
+// request context. Requests without a valid token are rejected with 401.
+func (s *Service) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := tokenFromRequest(r)
+		if tokenString == "" {
+			unauthorized(w, "missing token")
+			return
+		}
+		identity, err := s.ParseToken(tokenString)
+		if err != nil {
+			unauthorized(w, "invalid token")
+			return
+		}
+		ctx := context.WithValue(r.Context(), identityKey, identity)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// IdentityFromContext returns the identity stored by Middleware.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey).(Identity)
+	return identity, ok
+}
+
+// PlayerIDFromContext returns the authenticated player ID.
+func PlayerIDFromContext(ctx context.Context) (string, bool) {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok || identity.PlayerID == "" {
+		return "", false
+	}
+	return identity.PlayerID, true
+}
+
+// tokenFromRequest looks for the token in the Authorization header, then in
+// the WebSocket subprotocol list, then in the access_token query parameter.
+func tokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	var protocols []string
+	for _, value := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(value, ",") {
+			protocols = append(protocols, strings.TrimSpace(p))
+		}
+	}
+	for i, p := range protocols {
+		if p == BearerSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+
+	return r.URL.Query().Get("access_token")
+}
+
+func unauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
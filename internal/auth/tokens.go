@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReuse   = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is returned by login and refresh. Token is the short-lived access
+// token, RefreshToken is the opaque value used to obtain the next pair.
+type TokenPair struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"` // access token lifetime in seconds
+}
+
+// issueTokens mints an access token and a new refresh token in the given
+// family. An empty familyID starts a new family (a fresh login).
+func (s *Service) issueTokens(tx *sql.Tx, userID int64, guest bool, familyID string) (*TokenPair, int64, error) {
+	accessToken, err := s.signAccessToken(userID, guest)
+	if err != nil {
+		return nil, 0, err
+	}
+	if familyID == "" {
+		familyID, err = randomToken(16)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	refreshToken, err := randomToken(32)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var id int64
+	err = tx.QueryRow(
+		"INSERT INTO refresh_tokens (user_id, token_hash, family_id, expires_at) VALUES ($1, $2, $3, $4) RETURNING id",
+		userID, hashToken(refreshToken), familyID, time.Now().Add(RefreshTokenTTL),
+	).Scan(&id)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to store refresh token: %v", err)
+	}
+
+	return &TokenPair{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(AccessTokenTTL / time.Second),
+	}, id, nil
+}
+
+func (s *Service) signAccessToken(userID int64, guest bool) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": userID,
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
+	}
+	if guest {
+		claims["guest"] = true
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.cfg.JWTSecret))
+}
+
+// newSession starts a new refresh token family for userID.
+func (s *Service) newSession(userID int64, guest bool) (*TokenPair, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	pair, _, err := s.issueTokens(tx, userID, guest, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Refresh rotates a refresh token: the presented token is revoked and a new
+// pair in the same family is returned. Presenting an already rotated token is
+// treated as theft and revokes the whole family.
+func (s *Service) Refresh(refreshToken string) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		id        int64
+		userID    int64
+		familyID  string
+		expiresAt time.Time
+		revokedAt sql.NullTime
+		guest     bool
+	)
+	err = tx.QueryRow(
+		`SELECT t.id, t.user_id, t.family_id, t.expires_at, t.revoked_at, u.is_guest
+		 FROM refresh_tokens t JOIN users u ON u.id = t.user_id
+		 WHERE t.token_hash = $1 FOR UPDATE OF t`,
+		hashToken(refreshToken),
+	).Scan(&id, &userID, &familyID, &expiresAt, &revokedAt, &guest)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to look up refresh token: %v", err)
+	}
+
+	if revokedAt.Valid {
+		tx.Rollback()
+		if err := s.revokeFamily(familyID); err != nil {
+			log.Printf("Failed to revoke token family %s: %v", familyID, err)
+		}
+		log.Printf("Refresh token reuse for user %d, revoked family %s", userID, familyID)
+		return nil, ErrRefreshTokenReuse
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	pair, newID, err := s.issueTokens(tx, userID, guest, familyID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(
+		"UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $2 WHERE id = $1",
+		id, newID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pair, nil
+}
+
+// Logout revokes the family of the given refresh token, ending that session.
+func (s *Service) Logout(refreshToken string) error {
+	var familyID string
+	err := s.db.QueryRow("SELECT family_id FROM refresh_tokens WHERE token_hash = $1", hashToken(refreshToken)).Scan(&familyID)
+	if err == sql.ErrNoRows {
+		return ErrInvalidRefreshToken
+	} else if err != nil {
+		return fmt.Errorf("failed to look up refresh token: %v", err)
+	}
+	return s.revokeFamily(familyID)
+}
+
+// LogoutAll revokes every refresh token of the player, on all devices.
+func (s *Service) LogoutAll(userID int64) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL", userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %v", err)
+	}
+	return nil
+}
+
+func (s *Service) revokeFamily(familyID string) error {
+	_, err := s.db.Exec("UPDATE refresh_tokens SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
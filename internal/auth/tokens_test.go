@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/krishanu7/battleship-backend/config"
+)
+
+const (
+	selectToken  = `SELECT t\.id, t\.user_id, t\.family_id, t\.expires_at, t\.revoked_at, u\.is_guest FROM refresh_tokens t`
+	insertToken  = `INSERT INTO refresh_tokens \(user_id, token_hash, family_id, expires_at\)`
+	rotateToken  = `UPDATE refresh_tokens SET revoked_at = NOW\(\), replaced_by = \$2 WHERE id = \$1`
+	revokeFamily = `UPDATE refresh_tokens SET revoked_at = NOW\(\) WHERE family_id = \$1 AND revoked_at IS NULL`
+)
+
+func newTestService(t *testing.T) (*Service, sqlmock.Sqlmock) {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		conn.Close()
+	})
+	return NewService(conn, config.Config{JWTSecret: "test-secret"}), mock
+}
+
+// tokenRow is the stored refresh token of user 7 in family "fam".
+func tokenRow(expiresAt time.Time, revokedAt sql.NullTime) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "user_id", "family_id", "expires_at", "revoked_at", "is_guest"}).
+		AddRow(1, 7, "fam", expiresAt, revokedAt, false)
+}
+
+func TestRefreshRotates(t *testing.T) {
+	s, mock := newTestService(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).WithArgs(hashToken("old")).
+		WillReturnRows(tokenRow(time.Now().Add(time.Hour), sql.NullTime{}))
+	// The new token joins the family of the old one, which it replaces
+	mock.ExpectQuery(insertToken).WithArgs(7, sqlmock.AnyArg(), "fam", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec(rotateToken).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pair, err := s.Refresh("old")
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if pair.RefreshToken == "" || pair.RefreshToken == "old" {
+		t.Errorf("refresh token = %q, want a new one", pair.RefreshToken)
+	}
+	identity, err := s.ParseToken(pair.Token)
+	if err != nil || identity.UserID != 7 || identity.PlayerID != "7" || identity.Guest {
+		t.Errorf("access token is for %+v, %v, want user 7", identity, err)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	s, mock := newTestService(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectToken).WithArgs(hashToken("rotated")).
+		WillReturnRows(tokenRow(time.Now().Add(time.Hour), sql.NullTime{Time: time.Now(), Valid: true}))
+	mock.ExpectRollback()
+	mock.ExpectExec(revokeFamily).WithArgs("fam").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := s.Refresh("rotated"); !errors.Is(err, ErrRefreshTokenReuse) {
+		t.Errorf("err = %v, want ErrRefreshTokenReuse", err)
+	}
+}
+
+func TestRefreshRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:   "empty",
+			expect: func(sqlmock.Sqlmock) {},
+		},
+		{
+			name:  "unknown",
+			token: "unknown",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectToken).WithArgs(hashToken("unknown")).WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:  "expired",
+			token: "expired",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(selectToken).WithArgs(hashToken("expired")).
+					WillReturnRows(tokenRow(time.Now().Add(-time.Minute), sql.NullTime{}))
+				mock.ExpectRollback()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestService(t)
+			tt.expect(mock)
+			if _, err := s.Refresh(tt.token); !errors.Is(err, ErrInvalidRefreshToken) {
+				t.Errorf("err = %v, want ErrInvalidRefreshToken", err)
+			}
+		})
+	}
+}
+
+func TestIssuedTokensExpire(t *testing.T) {
+	s, mock := newTestService(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(insertToken).WithArgs(7, sqlmock.AnyArg(), sqlmock.AnyArg(), expiresIn(RefreshTokenTTL)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	pair, err := s.newSession(7, false)
+	if err != nil {
+		t.Fatalf("newSession: %v", err)
+	}
+	if pair.ExpiresIn != int64(AccessTokenTTL/time.Second) {
+		t.Errorf("ExpiresIn = %d, want %d", pair.ExpiresIn, int64(AccessTokenTTL/time.Second))
+	}
+
+	// An access token is refused once past its expiry
+	expired, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": 7,
+		"exp":     time.Now().Add(-time.Second).Unix(),
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ParseToken(expired); err == nil {
+		t.Error("ParseToken accepted an expired access token")
+	}
+}
+
+// expiresIn matches a time about d from now.
+type expiresIn time.Duration
+
+func (d expiresIn) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	off := time.Until(at) - time.Duration(d)
+	return off > -time.Minute && off < time.Minute
+}
@@ -0,0 +1,49 @@
+// Package bot implements computer opponents. A bot chooses its shots from
+// what a human player can see: its own shots, their results and which shot
+// sank which ship.
+package bot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// Difficulties
+const (
+	Easy   = "easy"   // fires at random
+	Medium = "medium" // hunts on a checkerboard, then targets around hits
+	Hard   = "hard"   // fires where a ship is most likely to be
+)
+
+// Queue and Channel hand new bot games to the server that runs the bot:
+// the room ID is pushed to Queue and announced on Channel.
+const (
+	Queue   = "bot_queue"
+	Channel = "bot_games"
+)
+
+const idPrefix = "bot:"
+
+// ValidDifficulty reports whether d names a difficulty.
+func ValidDifficulty(d string) bool {
+	return d == Easy || d == Medium || d == Hard
+}
+
+// NewID returns a fresh player ID for a bot, such as "bot:hard:1f2e3d4c".
+// Account IDs are numeric, so a bot ID never belongs to a human.
+func NewID(difficulty string) string {
+	nonce := make([]byte, 4)
+	rand.Read(nonce)
+	return idPrefix + difficulty + ":" + hex.EncodeToString(nonce)
+}
+
+// Difficulty returns the difficulty of a bot player ID, and false if the
+// player is not a bot.
+func Difficulty(playerID string) (string, bool) {
+	if !strings.HasPrefix(playerID, idPrefix) {
+		return "", false
+	}
+	difficulty, _, _ := strings.Cut(strings.TrimPrefix(playerID, idPrefix), ":")
+	return difficulty, ValidDifficulty(difficulty)
+}
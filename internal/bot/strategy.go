@@ -0,0 +1,244 @@
+package bot
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+)
+
+// View is what a bot knows when it chooses its shots.
+type View struct {
+	Rules engine.Rules
+	Shots []engine.Shot // fired by the bot, in order
+	Sinks []engine.Sink // which of Shots sank a ship
+}
+
+// Choose returns n distinct cells to fire at, best first. Classic games ask
+// for one shot, salvo games for one per surviving ship.
+func Choose(difficulty string, v View, n int, rng *rand.Rand) []string {
+	k := analyse(v)
+	var score func(cell [2]int) float64
+	switch difficulty {
+	case Hard:
+		score = k.density()
+	case Medium:
+		score = k.huntTarget()
+	default:
+		score = func([2]int) float64 { return 1 }
+	}
+
+	var cells [][2]int
+	for row := 0; row < k.rules.Height; row++ {
+		for col := 0; col < k.rules.Width; col++ {
+			if _, shot := k.shots[[2]int{row, col}]; !shot {
+				cells = append(cells, [2]int{row, col})
+			}
+		}
+	}
+	// Shuffle first so that equal scores are broken at random
+	rng.Shuffle(len(cells), func(i, j int) { cells[i], cells[j] = cells[j], cells[i] })
+	scores := make(map[[2]int]float64, len(cells))
+	for _, cell := range cells {
+		scores[cell] = score(cell)
+	}
+	sort.SliceStable(cells, func(i, j int) bool { return scores[cells[i]] > scores[cells[j]] })
+
+	if n > len(cells) {
+		n = len(cells)
+	}
+	chosen := make([]string, n)
+	for i := range chosen {
+		chosen[i] = engine.FormatCoordinate(cells[i][0], cells[i][1])
+	}
+	return chosen
+}
+
+// knowledge is a View worked out cell by cell.
+type knowledge struct {
+	rules  engine.Rules
+	shots  map[[2]int]engine.Result
+	sunk   map[[2]int]bool // cells of sunk ships, as far as they can be told
+	open   [][2]int        // hits on ships still afloat
+	afloat []engine.FleetEntry
+}
+
+func analyse(v View) *knowledge {
+	k := &knowledge{
+		rules: v.Rules.WithDefaults(),
+		shots: make(map[[2]int]engine.Result),
+		sunk:  make(map[[2]int]bool),
+	}
+	for _, shot := range v.Shots {
+		if cell, ok := k.parse(shot.Coordinate); ok {
+			k.shots[cell] = shot.Result
+		}
+	}
+
+	sunkCount := make(map[engine.ShipType]int)
+	for _, sink := range v.Sinks {
+		sunkCount[sink.Ship]++
+		if sink.Shot < 0 || sink.Shot >= len(v.Shots) {
+			continue
+		}
+		last, ok := k.parse(v.Shots[sink.Shot].Coordinate)
+		if !ok {
+			continue
+		}
+		size := 1
+		for _, entry := range k.rules.Fleet {
+			if entry.Type == sink.Ship {
+				size = entry.Size
+			}
+		}
+		k.markSunk(last, size)
+	}
+	for _, entry := range k.rules.Fleet {
+		if left := entry.Count - sunkCount[entry.Type]; left > 0 {
+			entry.Count = left
+			k.afloat = append(k.afloat, entry)
+		}
+	}
+
+	for row := 0; row < k.rules.Height; row++ {
+		for col := 0; col < k.rules.Width; col++ {
+			cell := [2]int{row, col}
+			if k.shots[cell] == engine.ResultHit && !k.sunk[cell] {
+				k.open = append(k.open, cell)
+			}
+		}
+	}
+	return k
+}
+
+func (k *knowledge) parse(coord string) ([2]int, bool) {
+	row, col, err := k.rules.ParseCoordinate(coord)
+	return [2]int{row, col}, err == nil
+}
+
+// markSunk marks the cells of a ship of the given size that went down with
+// the shot at last: a straight run of unsunk hits through it. When several
+// runs fit, the first is taken.
+func (k *knowledge) markSunk(last [2]int, size int) {
+	for _, dir := range [][2]int{{0, 1}, {1, 0}} {
+		for offset := 0; offset < size; offset++ {
+			start := [2]int{last[0] - offset*dir[0], last[1] - offset*dir[1]}
+			run := make([][2]int, size)
+			fits := true
+			for j := range run {
+				run[j] = [2]int{start[0] + j*dir[0], start[1] + j*dir[1]}
+				if k.shots[run[j]] != engine.ResultHit || k.sunk[run[j]] {
+					fits = false
+					break
+				}
+			}
+			if fits {
+				for _, cell := range run {
+					k.sunk[cell] = true
+				}
+				return
+			}
+		}
+	}
+	k.sunk[last] = true
+}
+
+// empty reports whether no ship can be on cell: it is off the board, or the
+// rules keep ships apart and a sunk ship touches it.
+func (k *knowledge) empty(cell [2]int) bool {
+	if cell[0] < 0 || cell[0] >= k.rules.Height || cell[1] < 0 || cell[1] >= k.rules.Width {
+		return true
+	}
+	if !k.rules.NoTouching {
+		return false
+	}
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			if k.sunk[[2]int{cell[0] + dr, cell[1] + dc}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// huntTarget fires around unresolved hits, preferring the ends of a line of
+// hits, and otherwise on a checkerboard spaced by the smallest ship afloat.
+func (k *knowledge) huntTarget() func([2]int) float64 {
+	target := make(map[[2]int]float64)
+	for _, hit := range k.open {
+		for _, dir := range [][2]int{{0, 1}, {1, 0}, {0, -1}, {-1, 0}} {
+			next := [2]int{hit[0] + dir[0], hit[1] + dir[1]}
+			if k.shots[next] == engine.ResultHit && !k.sunk[next] {
+				// Part of a line: the first unshot cell beyond its end
+				for k.shots[next] == engine.ResultHit && !k.sunk[next] {
+					next = [2]int{next[0] + dir[0], next[1] + dir[1]}
+				}
+				target[next] = math.Max(target[next], 3)
+				continue
+			}
+			target[next] = math.Max(target[next], 2)
+		}
+	}
+
+	spacing := math.MaxInt32
+	for _, entry := range k.afloat {
+		if entry.Size < spacing {
+			spacing = entry.Size
+		}
+	}
+	return func(cell [2]int) float64 {
+		if k.empty(cell) {
+			return 0
+		}
+		if score, ok := target[cell]; ok {
+			return score
+		}
+		if len(k.open) == 0 && spacing < math.MaxInt32 && (cell[0]+cell[1])%spacing == 0 {
+			return 1
+		}
+		return 0.5
+	}
+}
+
+// density counts, for every cell, the placements of the ships still afloat
+// that cover it and agree with what is known. Placements through
+// unresolved hits count for much more, so hits are followed up first.
+func (k *knowledge) density() func([2]int) float64 {
+	counts := make(map[[2]int]float64)
+	openHit := make(map[[2]int]bool, len(k.open))
+	for _, cell := range k.open {
+		openHit[cell] = true
+	}
+	for _, entry := range k.afloat {
+		for _, ship := range engine.Positions(k.rules, entry.Size) {
+			cells := make([][2]int, 0, len(ship.Cells))
+			fits := true
+			covered := 0
+			for _, coord := range ship.Cells {
+				cell, _ := k.parse(coord)
+				if k.shots[cell] == engine.ResultMiss || k.sunk[cell] || k.empty(cell) {
+					fits = false
+					break
+				}
+				if openHit[cell] {
+					covered++
+				}
+				cells = append(cells, cell)
+			}
+			if !fits {
+				continue
+			}
+			weight := float64(entry.Count) * math.Pow(50, float64(covered))
+			for _, cell := range cells {
+				if _, shot := k.shots[cell]; !shot {
+					counts[cell] += weight
+				}
+			}
+		}
+	}
+	return func(cell [2]int) float64 {
+		return counts[cell]
+	}
+}
@@ -0,0 +1,58 @@
+package engine
+
+type ShipType string
+
+const (
+	Carrier    ShipType = "Carrier"
+	Battleship ShipType = "Battleship"
+	Cruiser    ShipType = "Cruiser"
+	Submarine  ShipType = "Submarine"
+	Destroyer  ShipType = "Destroyer"
+)
+
+// ShipConfig is the classic fleet: one ship of each type with its size.
+// Other fleets are described by Rules.
+var ShipConfig = map[ShipType]int{
+	Carrier:    5,
+	Battleship: 4,
+	Cruiser:    3,
+	Submarine:  3,
+	Destroyer:  2,
+}
+
+const (
+	Horizontal = "horizontal"
+	Vertical   = "vertical"
+)
+
+type Ship struct {
+	Type        ShipType `json:"type"`
+	Size        int      `json:"size"`
+	Start       string   `json:"start"`       // "A1"
+	Orientation string   `json:"orientation"` // "horizontal" or "vertical"
+	Cells       []string `json:"cells"`       // Computed occupied cells (["A1", "A2", ...])
+}
+
+// Board is a validated fleet placement. Grid maps every occupied cell to the
+// type of the ship on it. A Board is never modified after NewBoard returns.
+type Board struct {
+	Ships []Ship            `json:"ships"`
+	Grid  map[string]string `json:"grid"`
+}
+
+// NewBoard validates a fleet against the rules and computes the cells of
+// every ship. The input slice is not modified. A rejected fleet's error is
+// a PlacementErrors listing every problem.
+func NewBoard(rules Rules, ships []Ship) (Board, error) {
+	board, errs := placeFleet(rules, ships)
+	if errs != nil {
+		return Board{}, errs
+	}
+	return board, nil
+}
+
+// Occupied reports whether a ship covers the cell.
+func (b Board) Occupied(cell string) bool {
+	_, ok := b.Grid[cell]
+	return ok
+}
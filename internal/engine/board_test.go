@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"errors"
+	"testing"
+)
+
+// withShip returns classicShips with ship i replaced.
+func withShip(i int, ship Ship) []Ship {
+	ships := classicShips()
+	ships[i] = ship
+	return ships
+}
+
+func TestNewBoard(t *testing.T) {
+	if _, err := NewBoard(ClassicRules(), classicShips()); err != nil {
+		t.Fatalf("NewBoard: %v", err)
+	}
+	_, err := NewBoard(ClassicRules(), classicShips()[:4])
+	var errs PlacementErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || errs[0].Index != -1 {
+		t.Errorf("missing ship: err = %v, want one fleet-wide PlacementError", err)
+	}
+}
+
+func TestBoardCells(t *testing.T) {
+	board, err := NewBoard(ClassicRules(), classicShips())
+	if err != nil {
+		t.Fatalf("NewBoard: %v", err)
+	}
+	if len(board.Grid) != 17 {
+		t.Errorf("grid has %d cells, want 17", len(board.Grid))
+	}
+	for _, cell := range []string{"A1", "A5", "C4", "E3", "G3", "I2"} {
+		if !board.Occupied(cell) {
+			t.Errorf("%s not occupied", cell)
+		}
+	}
+	for _, cell := range []string{"A6", "B1", "J10"} {
+		if board.Occupied(cell) {
+			t.Errorf("%s occupied", cell)
+		}
+	}
+	if got := board.Ships[0].Cells; !sameStrings(got, []string{"A1", "A2", "A3", "A4", "A5"}) {
+		t.Errorf("carrier cells = %v", got)
+	}
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
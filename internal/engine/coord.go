@@ -0,0 +1,19 @@
+package engine
+
+import (
+	"fmt"
+)
+
+// BoardSize is the width and height of the classic board.
+const BoardSize = 10
+
+// ParseCoordinate converts "A1" to row (0-9) and col (0-9) on the classic
+// board. Use Rules.ParseCoordinate for other board sizes.
+func ParseCoordinate(coord string) (row, col int, err error) {
+	return ClassicRules().ParseCoordinate(coord)
+}
+
+// FormatCoordinate converts a zero-based row and col to "A1".
+func FormatCoordinate(row, col int) string {
+	return fmt.Sprintf("%c%d", 'A'+row, col+1)
+}
@@ -0,0 +1,43 @@
+package engine
+
+import "testing"
+
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		coord    string
+		row, col int
+		ok       bool
+	}{
+		{"A1", 0, 0, true},
+		{"j10", 9, 9, true},
+		{"A01", 0, 0, true},
+		{"C7", 2, 6, true},
+		{"K1", 0, 0, false},
+		{"A11", 0, 0, false},
+		{"A0", 0, 0, false},
+		{"A", 0, 0, false},
+		{"AA", 0, 0, false},
+	}
+	for _, tt := range tests {
+		row, col, err := ParseCoordinate(tt.coord)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseCoordinate(%q) err = %v, want ok = %v", tt.coord, err, tt.ok)
+			continue
+		}
+		if tt.ok && (row != tt.row || col != tt.col) {
+			t.Errorf("ParseCoordinate(%q) = %d, %d, want %d, %d", tt.coord, row, col, tt.row, tt.col)
+		}
+	}
+}
+
+func TestFormatCoordinate(t *testing.T) {
+	for row := 0; row < BoardSize; row++ {
+		for col := 0; col < BoardSize; col++ {
+			coord := FormatCoordinate(row, col)
+			r, c, err := ParseCoordinate(coord)
+			if err != nil || r != row || c != col {
+				t.Fatalf("%q parsed back as %d, %d, %v, want %d, %d", coord, r, c, err, row, col)
+			}
+		}
+	}
+}
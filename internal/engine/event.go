@@ -0,0 +1,81 @@
+package engine
+
+// Event is something that happened as the result of applying a move.
+// Concrete events are Placed, Hit, Miss, Salvo, Sunk, Skipped, Forfeited,
+// Won, Drawn and TurnChanged.
+type Event interface {
+	event()
+}
+
+// Placed is emitted when a player's fleet is accepted.
+type Placed struct {
+	Player string
+}
+
+// Hit is emitted when Player's shot at Coordinate struck a ship of Target.
+type Hit struct {
+	Player     string
+	Target     string
+	Coordinate string
+	Ship       ShipType
+}
+
+// Miss is emitted when Player's shot at Coordinate hit water.
+type Miss struct {
+	Player     string
+	Target     string
+	Coordinate string
+}
+
+// Salvo is emitted when Player fired a whole salvo at Target. It replaces
+// the Hit and Miss events of the individual shots.
+type Salvo struct {
+	Player string
+	Target string
+	Shots  []Shot
+}
+
+// Sunk is emitted when Player's shot sank the last cell of a ship of Target.
+type Sunk struct {
+	Player string
+	Target string
+	Ship   ShipType
+}
+
+// Skipped is emitted when Player gives up a turn without firing.
+type Skipped struct {
+	Player string
+}
+
+// Forfeited is emitted when Player loses without their fleet being sunk.
+// Reason says why, for example "timeout".
+type Forfeited struct {
+	Player string
+	Reason string
+}
+
+// Won is emitted when Winner has sunk the whole fleet of Loser or Loser has
+// forfeited.
+type Won struct {
+	Winner string
+	Loser  string
+}
+
+// Drawn is emitted when both players agree to end the game without a winner.
+type Drawn struct{}
+
+// TurnChanged is emitted whenever the player to move changes.
+type TurnChanged struct {
+	Player string
+}
+
+func (Placed) event()      {}
+func (Hit) event()         {}
+func (Miss) event()        {}
+func (Salvo) event()       {}
+func (Sunk) event()        {}
+func (Skipped) event()     {}
+func (Forfeited) event()   {}
+func (Won) event()         {}
+func (Drawn) event()       {}
+func (TurnChanged) event() {}
@@ -0,0 +1,527 @@
+// Package engine implements the rules of Battleship as pure functions over a
+// Game value. It performs no I/O; callers persist the Game however they like
+// and broadcast the returned events.
+package engine
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownPlayer     = errors.New("player is not in this game")
+	ErrAlreadyPlaced     = errors.New("ships already placed")
+	ErrNotReady          = errors.New("both players must place ships first")
+	ErrNotStarted        = errors.New("game has not started")
+	ErrGameOver          = errors.New("game is over")
+	ErrNotYourTurn       = errors.New("not your turn")
+	ErrInvalidCoordinate = errors.New("invalid coordinate")
+	ErrAlreadyAttacked   = errors.New("coordinate already attacked")
+	ErrWrongMode         = errors.New("move not allowed in this game mode")
+	ErrSalvoSize         = errors.New("wrong number of shots in salvo")
+)
+
+// Game modes. In classic games a player fires one shot at a time and keeps
+// the turn after a hit. In salvo games a player fires one shot per surviving
+// ship, all at once, and the turn always passes.
+const (
+	ModeClassic = "classic"
+	ModeSalvo   = "salvo"
+)
+
+type Result string
+
+const (
+	ResultHit  Result = "hit"
+	ResultMiss Result = "miss"
+)
+
+// Shot is one attack fired by a player, in the order it was fired.
+type Shot struct {
+	Coordinate string `json:"coordinate"`
+	Result     Result `json:"result"`
+}
+
+// Game is the complete state of one match. It is a value: every method that
+// changes the game returns a new Game and leaves the receiver untouched, so a
+// Game can be shared freely between goroutines.
+type Game struct {
+	Rules   Rules // classic rules when zero
+	Players [2]string
+	Boards  [2]*Board // nil until the player has placed ships
+	Shots   [2][]Shot // shots fired by Players[i]
+	Turn    string    // player to move, empty before Start
+	Winner  string
+	Drawn   bool
+}
+
+// New creates a classic game between two players with no ships placed.
+func New(player1, player2 string) Game {
+	return Game{Players: [2]string{player1, player2}}
+}
+
+// NewWithRules creates a game played under rules.
+func NewWithRules(rules Rules, player1, player2 string) Game {
+	return Game{Rules: rules, Players: [2]string{player1, player2}}
+}
+
+// rules returns the ruleset of the game with defaults filled in.
+func (g Game) rules() Rules {
+	return g.Rules.WithDefaults()
+}
+
+// Saved is what callers persist of a game to Restore it later.
+type Saved struct {
+	Rules   Rules // classic rules when zero
+	Players [2]string
+	Boards  [2]*Board
+	Shots   [2][]string // coordinates fired by Players[i], in order
+	Turn    string
+	// Winner and Drawn record how the game ended when it was not by sinking
+	// a fleet, which the shots alone do not show.
+	Winner string
+	Drawn  bool
+}
+
+// Restore rebuilds a game from persisted state. Shots are re-scored against
+// the opponent's board, so only the coordinates need to be stored; each must
+// be a cell of the board of the game's rules.
+func Restore(saved Saved) (Game, error) {
+	players, boards, shots := saved.Players, saved.Boards, saved.Shots
+	g := Game{Rules: saved.Rules, Players: players, Boards: boards, Turn: saved.Turn, Drawn: saved.Drawn}
+	rules := g.rules()
+	if saved.Winner != "" {
+		if g.index(saved.Winner) < 0 {
+			return Game{}, fmt.Errorf("winner %s is not in the game", saved.Winner)
+		}
+		g.Winner = saved.Winner
+	}
+	for i := range players {
+		if len(shots[i]) == 0 {
+			continue
+		}
+		target := boards[1-i]
+		if target == nil {
+			return Game{}, fmt.Errorf("shots recorded for %s before opponent placed ships", players[i])
+		}
+		g.Shots[i] = make([]Shot, 0, len(shots[i]))
+		for _, coord := range shots[i] {
+			coord, err := rules.normalize(coord)
+			if err != nil {
+				return Game{}, fmt.Errorf("invalid shot recorded for %s: %v", players[i], err)
+			}
+			result := ResultMiss
+			if target.Occupied(coord) {
+				result = ResultHit
+			}
+			g.Shots[i] = append(g.Shots[i], Shot{Coordinate: coord, Result: result})
+		}
+		if g.allSunk(i) && !g.Over() {
+			g.Winner = players[i]
+		}
+	}
+	return g, nil
+}
+
+func (g Game) index(player string) int {
+	for i, p := range g.Players {
+		if p == player && p != "" {
+			return i
+		}
+	}
+	return -1
+}
+
+// Opponent returns the other player, or "" if player is not in the game.
+func (g Game) Opponent(player string) string {
+	i := g.index(player)
+	if i < 0 {
+		return ""
+	}
+	return g.Players[1-i]
+}
+
+// Ready reports whether both fleets are placed.
+func (g Game) Ready() bool {
+	return g.Boards[0] != nil && g.Boards[1] != nil
+}
+
+// Started reports whether a first turn has been assigned.
+func (g Game) Started() bool {
+	return g.Turn != ""
+}
+
+// Over reports whether the game has a winner or was drawn.
+func (g Game) Over() bool {
+	return g.Winner != "" || g.Drawn
+}
+
+// Board returns the placed fleet of player, or nil.
+func (g Game) Board(player string) *Board {
+	i := g.index(player)
+	if i < 0 {
+		return nil
+	}
+	return g.Boards[i]
+}
+
+// ShotsBy returns the shots fired by player in order.
+func (g Game) ShotsBy(player string) []Shot {
+	i := g.index(player)
+	if i < 0 {
+		return nil
+	}
+	return g.Shots[i]
+}
+
+// Place validates and stores the fleet of player.
+func (g Game) Place(player string, ships []Ship) (Game, []Event, error) {
+	i := g.index(player)
+	if i < 0 {
+		return g, nil, ErrUnknownPlayer
+	}
+	if g.Over() {
+		return g, nil, ErrGameOver
+	}
+	if g.Boards[i] != nil {
+		return g, nil, ErrAlreadyPlaced
+	}
+	board, err := NewBoard(g.rules(), ships)
+	if err != nil {
+		return g, nil, err
+	}
+	g.Boards[i] = &board
+	return g, []Event{Placed{Player: player}}, nil
+}
+
+// Start gives the first turn to first once both fleets are placed.
+func (g Game) Start(first string) (Game, []Event, error) {
+	if g.index(first) < 0 {
+		return g, nil, ErrUnknownPlayer
+	}
+	if g.Over() {
+		return g, nil, ErrGameOver
+	}
+	if !g.Ready() {
+		return g, nil, ErrNotReady
+	}
+	if g.Started() {
+		return g, nil, fmt.Errorf("game already started")
+	}
+	g.Turn = first
+	return g, []Event{TurnChanged{Player: first}}, nil
+}
+
+// checkMove returns the index of player if they may move now.
+func (g Game) checkMove(player string) (int, error) {
+	i := g.index(player)
+	if i < 0 {
+		return -1, ErrUnknownPlayer
+	}
+	if g.Over() {
+		return -1, ErrGameOver
+	}
+	if !g.Started() {
+		return -1, ErrNotStarted
+	}
+	if g.Turn != player {
+		return -1, ErrNotYourTurn
+	}
+	return i, nil
+}
+
+// target normalizes coordinate and checks that Players[i] has not fired at
+// it yet.
+func (g Game) target(i int, coordinate string) (string, error) {
+	coord, err := g.rules().normalize(coordinate)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCoordinate, err)
+	}
+	for _, shot := range g.Shots[i] {
+		if shot.Coordinate == coord {
+			return "", fmt.Errorf("%w: %s", ErrAlreadyAttacked, coord)
+		}
+	}
+	return coord, nil
+}
+
+// Fire applies a shot by player. A hit keeps the turn with the shooter, a
+// miss passes it to the opponent. Sinking the last ship ends the game.
+func (g Game) Fire(player, coordinate string) (Game, []Event, error) {
+	i, err := g.checkMove(player)
+	if err != nil {
+		return g, nil, err
+	}
+	if g.rules().Mode == ModeSalvo {
+		return g, nil, ErrWrongMode
+	}
+	coord, err := g.target(i, coordinate)
+	if err != nil {
+		return g, nil, err
+	}
+
+	opponent := g.Players[1-i]
+	target := g.Boards[1-i]
+	result := ResultMiss
+	if target.Occupied(coord) {
+		result = ResultHit
+	}
+	// Copy before appending so the receiver's slice is never shared
+	shots := make([]Shot, len(g.Shots[i]), len(g.Shots[i])+1)
+	copy(shots, g.Shots[i])
+	g.Shots[i] = append(shots, Shot{Coordinate: coord, Result: result})
+
+	var events []Event
+	if result == ResultMiss {
+		events = append(events, Miss{Player: player, Target: opponent, Coordinate: coord})
+		g.Turn = opponent
+		events = append(events, TurnChanged{Player: opponent})
+		return g, events, nil
+	}
+
+	shipType := ShipType(target.Grid[coord])
+	events = append(events, Hit{Player: player, Target: opponent, Coordinate: coord, Ship: shipType})
+	hits := g.hitSet(i)
+	for _, ship := range target.Ships {
+		if ship.Type == shipType && containsCell(ship.Cells, coord) && cellsHit(ship.Cells, hits) {
+			events = append(events, Sunk{Player: player, Target: opponent, Ship: ship.Type})
+		}
+	}
+	if g.allSunk(i) {
+		g.Winner = player
+		events = append(events, Won{Winner: player, Loser: opponent})
+	}
+	return g, events, nil
+}
+
+// SalvoSize returns how many shots player must fire in their next salvo: one
+// per ship they have left, but never more than the cells left to attack.
+func (g Game) SalvoSize(player string) int {
+	i := g.index(player)
+	if i < 0 || g.Boards[i] == nil {
+		return 0
+	}
+	n := len(g.Boards[i].Ships) - len(g.SunkShips(player))
+	if left := g.rules().Cells() - len(g.Shots[i]); n > left {
+		n = left
+	}
+	return n
+}
+
+// FireSalvo applies a salvo by player. All shots are resolved together: the
+// ships they sink are reported after every result, and the turn passes to
+// the opponent unless the salvo ends the game.
+func (g Game) FireSalvo(player string, coordinates []string) (Game, []Event, error) {
+	i, err := g.checkMove(player)
+	if err != nil {
+		return g, nil, err
+	}
+	if g.rules().Mode != ModeSalvo {
+		return g, nil, ErrWrongMode
+	}
+	if n := g.SalvoSize(player); len(coordinates) != n {
+		return g, nil, fmt.Errorf("%w: expected %d, got %d", ErrSalvoSize, n, len(coordinates))
+	}
+
+	opponent := g.Players[1-i]
+	target := g.Boards[1-i]
+	sunkBefore := make(map[ShipType]int)
+	for _, ship := range g.SunkShips(opponent) {
+		sunkBefore[ship]++
+	}
+
+	fired := make([]Shot, 0, len(coordinates))
+	seen := make(map[string]bool, len(coordinates))
+	for _, coordinate := range coordinates {
+		coord, err := g.target(i, coordinate)
+		if err != nil {
+			return g, nil, err
+		}
+		if seen[coord] {
+			return g, nil, fmt.Errorf("%w: %s twice in one salvo", ErrAlreadyAttacked, coord)
+		}
+		seen[coord] = true
+		result := ResultMiss
+		if target.Occupied(coord) {
+			result = ResultHit
+		}
+		fired = append(fired, Shot{Coordinate: coord, Result: result})
+	}
+	shots := make([]Shot, len(g.Shots[i]), len(g.Shots[i])+len(fired))
+	copy(shots, g.Shots[i])
+	g.Shots[i] = append(shots, fired...)
+
+	events := []Event{Salvo{Player: player, Target: opponent, Shots: fired}}
+	for _, ship := range g.SunkShips(opponent) {
+		if sunkBefore[ship] > 0 {
+			sunkBefore[ship]--
+			continue
+		}
+		events = append(events, Sunk{Player: player, Target: opponent, Ship: ship})
+	}
+	if g.allSunk(i) {
+		g.Winner = player
+		return g, append(events, Won{Winner: player, Loser: opponent}), nil
+	}
+	g.Turn = opponent
+	return g, append(events, TurnChanged{Player: opponent}), nil
+}
+
+// Skip passes the turn of player to the opponent without a shot.
+func (g Game) Skip(player string) (Game, []Event, error) {
+	i, err := g.checkMove(player)
+	if err != nil {
+		return g, nil, err
+	}
+	opponent := g.Players[1-i]
+	g.Turn = opponent
+	return g, []Event{Skipped{Player: player}, TurnChanged{Player: opponent}}, nil
+}
+
+// Forfeit ends the game with player as the loser, even before it started.
+func (g Game) Forfeit(player, reason string) (Game, []Event, error) {
+	i := g.index(player)
+	if i < 0 {
+		return g, nil, ErrUnknownPlayer
+	}
+	if g.Over() {
+		return g, nil, ErrGameOver
+	}
+	opponent := g.Players[1-i]
+	g.Winner = opponent
+	return g, []Event{
+		Forfeited{Player: player, Reason: reason},
+		Won{Winner: opponent, Loser: player},
+	}, nil
+}
+
+// Draw ends a started game without a winner. Agreeing on the draw is up to
+// the caller.
+func (g Game) Draw() (Game, []Event, error) {
+	if g.Over() {
+		return g, nil, ErrGameOver
+	}
+	if !g.Started() {
+		return g, nil, ErrNotStarted
+	}
+	g.Drawn = true
+	return g, []Event{Drawn{}}, nil
+}
+
+// Unshot returns the cells player has not fired at yet, in board order.
+func (g Game) Unshot(player string) []string {
+	i := g.index(player)
+	if i < 0 {
+		return nil
+	}
+	fired := make(map[string]bool, len(g.Shots[i]))
+	for _, shot := range g.Shots[i] {
+		fired[shot.Coordinate] = true
+	}
+	rules := g.rules()
+	var cells []string
+	for row := 0; row < rules.Height; row++ {
+		for col := 0; col < rules.Width; col++ {
+			if cell := FormatCoordinate(row, col); !fired[cell] {
+				cells = append(cells, cell)
+			}
+		}
+	}
+	return cells
+}
+
+// SunkShips returns the ships of target that have been sunk, in fleet order.
+func (g Game) SunkShips(target string) []ShipType {
+	t := g.index(target)
+	if t < 0 || g.Boards[t] == nil {
+		return nil
+	}
+	hits := g.hitSet(1 - t)
+	var sunk []ShipType
+	for _, ship := range g.Boards[t].Ships {
+		if cellsHit(ship.Cells, hits) {
+			sunk = append(sunk, ship.Type)
+		}
+	}
+	return sunk
+}
+
+// Sink records which shot sank a ship.
+type Sink struct {
+	Shot int      `json:"shot"` // index into the shots of the player who sank it
+	Ship ShipType `json:"ship"`
+}
+
+// Sinks returns the ships player has sunk in the order they went down, with
+// the shot that sank each.
+func (g Game) Sinks(player string) []Sink {
+	i := g.index(player)
+	if i < 0 || g.Boards[1-i] == nil {
+		return nil
+	}
+	ships := g.Boards[1-i].Ships
+	left := make([]int, len(ships)) // cells not yet hit, per ship
+	for k, ship := range ships {
+		left[k] = len(ship.Cells)
+	}
+	var sinks []Sink
+	for n, shot := range g.Shots[i] {
+		if shot.Result != ResultHit {
+			continue
+		}
+		for k, ship := range ships {
+			if containsCell(ship.Cells, shot.Coordinate) {
+				left[k]--
+				if left[k] == 0 {
+					sinks = append(sinks, Sink{Shot: n, Ship: ship.Type})
+				}
+				break
+			}
+		}
+	}
+	return sinks
+}
+
+// hitSet returns the cells hit by Players[i].
+func (g Game) hitSet(i int) map[string]bool {
+	hits := make(map[string]bool)
+	for _, shot := range g.Shots[i] {
+		if shot.Result == ResultHit {
+			hits[shot.Coordinate] = true
+		}
+	}
+	return hits
+}
+
+// allSunk reports whether Players[i] has hit every cell of the opposing fleet.
+func (g Game) allSunk(i int) bool {
+	target := g.Boards[1-i]
+	if target == nil {
+		return false
+	}
+	hits := g.hitSet(i)
+	for cell := range target.Grid {
+		if !hits[cell] {
+			return false
+		}
+	}
+	return true
+}
+
+func containsCell(cells []string, cell string) bool {
+	for _, c := range cells {
+		if c == cell {
+			return true
+		}
+	}
+	return false
+}
+
+func cellsHit(cells []string, hits map[string]bool) bool {
+	for _, c := range cells {
+		if !hits[c] {
+			return false
+		}
+	}
+	return true
+}
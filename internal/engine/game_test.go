@@ -0,0 +1,664 @@
+package engine
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// classicShips is a valid classic fleet, one ship every other row.
+func classicShips() []Ship {
+	return []Ship{
+		{Type: Carrier, Size: 5, Start: "A1", Orientation: Horizontal},
+		{Type: Battleship, Size: 4, Start: "C1", Orientation: Horizontal},
+		{Type: Cruiser, Size: 3, Start: "E1", Orientation: Horizontal},
+		{Type: Submarine, Size: 3, Start: "G1", Orientation: Horizontal},
+		{Type: Destroyer, Size: 2, Start: "I1", Orientation: Horizontal},
+	}
+}
+
+// fleetCells are the cells of classicShips, in fleet order.
+var fleetCells = []string{
+	"A1", "A2", "A3", "A4", "A5",
+	"C1", "C2", "C3", "C4",
+	"E1", "E2", "E3",
+	"G1", "G2", "G3",
+	"I1", "I2",
+}
+
+// started returns a game between "p1" and "p2" with both classic fleets
+// placed and p1 to move.
+func started(t *testing.T, rules Rules) Game {
+	t.Helper()
+	g := NewWithRules(rules, "p1", "p2")
+	var err error
+	for _, p := range []string{"p1", "p2"} {
+		if g, _, err = g.Place(p, classicShips()); err != nil {
+			t.Fatalf("Place(%s): %v", p, err)
+		}
+	}
+	if g, _, err = g.Start("p1"); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	return g
+}
+
+func salvoRules() Rules {
+	rules := ClassicRules()
+	rules.Mode = ModeSalvo
+	return rules
+}
+
+func TestPlaceAndStart(t *testing.T) {
+	g := New("p1", "p2")
+	if _, _, err := g.Start("p1"); !errors.Is(err, ErrNotReady) {
+		t.Errorf("Start before placing: err = %v, want ErrNotReady", err)
+	}
+	if _, _, err := g.Place("p3", classicShips()); !errors.Is(err, ErrUnknownPlayer) {
+		t.Errorf("Place by stranger: err = %v, want ErrUnknownPlayer", err)
+	}
+	if _, _, err := g.Place("p1", classicShips()[:3]); err == nil {
+		t.Error("Place accepted an incomplete fleet")
+	}
+
+	placed, events, err := g.Place("p1", classicShips())
+	if err != nil {
+		t.Fatalf("Place: %v", err)
+	}
+	if !reflect.DeepEqual(events, []Event{Placed{Player: "p1"}}) {
+		t.Errorf("events = %#v", events)
+	}
+	if g.Board("p1") != nil {
+		t.Error("Place changed the receiver")
+	}
+	if _, _, err := placed.Place("p1", classicShips()); !errors.Is(err, ErrAlreadyPlaced) {
+		t.Errorf("second Place: err = %v, want ErrAlreadyPlaced", err)
+	}
+	if _, _, err := placed.Fire("p1", "A1"); !errors.Is(err, ErrNotStarted) {
+		t.Errorf("Fire before start: err = %v, want ErrNotStarted", err)
+	}
+
+	placed, _, _ = placed.Place("p2", classicShips())
+	g, events, err = placed.Start("p2")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if g.Turn != "p2" || !reflect.DeepEqual(events, []Event{TurnChanged{Player: "p2"}}) {
+		t.Errorf("turn = %s, events = %#v", g.Turn, events)
+	}
+	if _, _, err := g.Start("p1"); err == nil {
+		t.Error("Start accepted twice")
+	}
+}
+
+func TestFire(t *testing.T) {
+	tests := []struct {
+		name   string
+		before []string // shots fired by p1 first, all hits
+		player string
+		coord  string
+		events []Event
+		turn   string
+		err    error
+	}{
+		{
+			name:   "miss passes the turn",
+			player: "p1",
+			coord:  "B1",
+			events: []Event{
+				Miss{Player: "p1", Target: "p2", Coordinate: "B1"},
+				TurnChanged{Player: "p2"},
+			},
+			turn: "p2",
+		},
+		{
+			name:   "hit keeps the turn",
+			player: "p1",
+			coord:  "a1",
+			events: []Event{Hit{Player: "p1", Target: "p2", Coordinate: "A1", Ship: Carrier}},
+			turn:   "p1",
+		},
+		{
+			name:   "last cell of a ship sinks it",
+			before: []string{"I1"},
+			player: "p1",
+			coord:  "I2",
+			events: []Event{
+				Hit{Player: "p1", Target: "p2", Coordinate: "I2", Ship: Destroyer},
+				Sunk{Player: "p1", Target: "p2", Ship: Destroyer},
+			},
+			turn: "p1",
+		},
+		{
+			name:   "last ship wins",
+			before: fleetCells[:len(fleetCells)-1],
+			player: "p1",
+			coord:  "I2",
+			events: []Event{
+				Hit{Player: "p1", Target: "p2", Coordinate: "I2", Ship: Destroyer},
+				Sunk{Player: "p1", Target: "p2", Ship: Destroyer},
+				Won{Winner: "p1", Loser: "p2"},
+			},
+			turn: "p1",
+		},
+		{
+			name:   "out of turn",
+			player: "p2",
+			coord:  "A1",
+			err:    ErrNotYourTurn,
+		},
+		{
+			name:   "stranger",
+			player: "p3",
+			coord:  "A1",
+			err:    ErrUnknownPlayer,
+		},
+		{
+			name:   "same cell twice",
+			before: []string{"A1"},
+			player: "p1",
+			coord:  "A1",
+			err:    ErrAlreadyAttacked,
+		},
+		{
+			name:   "same cell spelled differently",
+			before: []string{"A1"},
+			player: "p1",
+			coord:  "a01",
+			err:    ErrAlreadyAttacked,
+		},
+		{
+			name:   "off the board",
+			player: "p1",
+			coord:  "K1",
+			err:    errAny,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := started(t, ClassicRules())
+			var err error
+			for _, coord := range tt.before {
+				if g, _, err = g.Fire("p1", coord); err != nil {
+					t.Fatalf("Fire(%s): %v", coord, err)
+				}
+			}
+			next, events, err := g.Fire(tt.player, tt.coord)
+			if tt.err != nil {
+				if err == nil || tt.err != errAny && !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				if !reflect.DeepEqual(next, g) {
+					t.Error("rejected shot changed the game")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fire: %v", err)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("events = %#v, want %#v", events, tt.events)
+			}
+			if next.Turn != tt.turn {
+				t.Errorf("turn = %s, want %s", next.Turn, tt.turn)
+			}
+			if len(g.ShotsBy("p1")) != len(tt.before) {
+				t.Error("Fire changed the receiver's shots")
+			}
+		})
+	}
+}
+
+// errAny stands for any error in the tables.
+var errAny = errors.New("any error")
+
+// won returns a game p1 has won by sinking the whole fleet of p2.
+func won(t *testing.T) Game {
+	t.Helper()
+	g := started(t, ClassicRules())
+	var err error
+	for _, coord := range fleetCells {
+		if g, _, err = g.Fire("p1", coord); err != nil {
+			t.Fatalf("Fire(%s): %v", coord, err)
+		}
+	}
+	if g.Winner != "p1" || !g.Over() {
+		t.Fatalf("winner = %q after sinking every ship", g.Winner)
+	}
+	return g
+}
+
+func TestGameOverRejectsMoves(t *testing.T) {
+	forfeited, _, err := started(t, ClassicRules()).Forfeit("p2", "resign")
+	if err != nil {
+		t.Fatalf("Forfeit: %v", err)
+	}
+	games := map[string]Game{"won": won(t), "forfeited": forfeited}
+	for over, g := range games {
+		moves := map[string]func() error{
+			"fire":    func() error { _, _, err := g.Fire("p1", "J10"); return err },
+			"skip":    func() error { _, _, err := g.Skip("p1"); return err },
+			"forfeit": func() error { _, _, err := g.Forfeit("p2", "timeout"); return err },
+			"draw":    func() error { _, _, err := g.Draw(); return err },
+			"place":   func() error { _, _, err := g.Place("p1", classicShips()); return err },
+			"start":   func() error { _, _, err := g.Start("p1"); return err },
+		}
+		for name, move := range moves {
+			if err := move(); !errors.Is(err, ErrGameOver) {
+				t.Errorf("%s after the game was %s: err = %v, want ErrGameOver", name, over, err)
+			}
+		}
+	}
+}
+
+func TestSkip(t *testing.T) {
+	g := started(t, ClassicRules())
+	g, events, err := g.Skip("p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{Skipped{Player: "p1"}, TurnChanged{Player: "p2"}}
+	if !reflect.DeepEqual(events, want) || g.Turn != "p2" {
+		t.Errorf("turn = %s, events = %#v", g.Turn, events)
+	}
+	if _, _, err := g.Skip("p1"); !errors.Is(err, ErrNotYourTurn) {
+		t.Errorf("Skip out of turn: err = %v, want ErrNotYourTurn", err)
+	}
+}
+
+func TestForfeitAndDraw(t *testing.T) {
+	tests := []struct {
+		name   string
+		game   func(t *testing.T) Game
+		apply  func(g Game) (Game, []Event, error)
+		events []Event
+		winner string
+		drawn  bool
+		err    error
+	}{
+		{
+			name: "resign",
+			game: func(t *testing.T) Game { return started(t, ClassicRules()) },
+			apply: func(g Game) (Game, []Event, error) {
+				return g.Forfeit("p1", "resign")
+			},
+			events: []Event{Forfeited{Player: "p1", Reason: "resign"}, Won{Winner: "p2", Loser: "p1"}},
+			winner: "p2",
+		},
+		{
+			name: "forfeit out of turn",
+			game: func(t *testing.T) Game { return started(t, ClassicRules()) },
+			apply: func(g Game) (Game, []Event, error) {
+				return g.Forfeit("p2", "timeout")
+			},
+			events: []Event{Forfeited{Player: "p2", Reason: "timeout"}, Won{Winner: "p1", Loser: "p2"}},
+			winner: "p1",
+		},
+		{
+			name: "forfeit during placement",
+			game: func(t *testing.T) Game { return New("p1", "p2") },
+			apply: func(g Game) (Game, []Event, error) {
+				return g.Forfeit("p2", "abandoned")
+			},
+			events: []Event{Forfeited{Player: "p2", Reason: "abandoned"}, Won{Winner: "p1", Loser: "p2"}},
+			winner: "p1",
+		},
+		{
+			name: "forfeit by stranger",
+			game: func(t *testing.T) Game { return started(t, ClassicRules()) },
+			apply: func(g Game) (Game, []Event, error) {
+				return g.Forfeit("p3", "resign")
+			},
+			err: ErrUnknownPlayer,
+		},
+		{
+			name:   "draw",
+			game:   func(t *testing.T) Game { return started(t, ClassicRules()) },
+			apply:  func(g Game) (Game, []Event, error) { return g.Draw() },
+			events: []Event{Drawn{}},
+			drawn:  true,
+		},
+		{
+			name:  "draw before start",
+			game:  func(t *testing.T) Game { return New("p1", "p2") },
+			apply: func(g Game) (Game, []Event, error) { return g.Draw() },
+			err:   ErrNotStarted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, events, err := tt.apply(tt.game(t))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("events = %#v, want %#v", events, tt.events)
+			}
+			if g.Winner != tt.winner || g.Drawn != tt.drawn || !g.Over() {
+				t.Errorf("winner = %q, drawn = %v, over = %v", g.Winner, g.Drawn, g.Over())
+			}
+		})
+	}
+}
+
+func TestSalvo(t *testing.T) {
+	tests := []struct {
+		name   string
+		before [][]string // salvos fired in turn, p1 first
+		player string
+		shots  []string
+		turn   string
+		sunk   []ShipType
+		won    bool
+		err    error
+	}{
+		{
+			name:   "one shot per ship",
+			player: "p1",
+			shots:  []string{"A1", "B1", "B2", "B3", "B4"},
+			turn:   "p2",
+		},
+		{
+			name:   "too few shots",
+			player: "p1",
+			shots:  []string{"A1", "B1"},
+			err:    ErrSalvoSize,
+		},
+		{
+			name:   "same cell twice in a salvo",
+			player: "p1",
+			shots:  []string{"A1", "A1", "B2", "B3", "B4"},
+			err:    ErrAlreadyAttacked,
+		},
+		{
+			name: "cell fired at before",
+			before: [][]string{
+				{"A1", "B1", "B2", "B3", "B4"},
+				{"J1", "J2", "J3", "J4", "J5"},
+			},
+			player: "p1",
+			shots:  []string{"A1", "B5", "B6", "B7", "B8"},
+			err:    ErrAlreadyAttacked,
+		},
+		{
+			name: "sinking shrinks the opponent's next salvo",
+			before: [][]string{
+				{"I1", "I2", "B1", "B2", "B3"},
+			},
+			player: "p2",
+			shots:  []string{"J1", "J2", "J3", "J4"},
+			turn:   "p1",
+		},
+		{
+			name:   "sunk ships reported",
+			player: "p1",
+			shots:  []string{"I1", "I2", "G1", "G2", "G3"},
+			turn:   "p2",
+			sunk:   []ShipType{Submarine, Destroyer},
+		},
+		{
+			name:   "classic moves rejected",
+			player: "p1",
+			shots:  nil,
+			err:    ErrWrongMode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := started(t, salvoRules())
+			var err error
+			for k, salvo := range tt.before {
+				player := []string{"p1", "p2"}[k%2]
+				if g, _, err = g.FireSalvo(player, salvo); err != nil {
+					t.Fatalf("FireSalvo(%s, %v): %v", player, salvo, err)
+				}
+			}
+			if tt.shots == nil {
+				if _, _, err := g.Fire(tt.player, "A1"); !errors.Is(err, tt.err) {
+					t.Fatalf("Fire: err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			next, events, err := g.FireSalvo(tt.player, tt.shots)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FireSalvo: %v", err)
+			}
+			salvo, ok := events[0].(Salvo)
+			if !ok || len(salvo.Shots) != len(tt.shots) {
+				t.Fatalf("first event = %#v, want a Salvo of %d shots", events[0], len(tt.shots))
+			}
+			var sunk []ShipType
+			for _, e := range events {
+				if s, ok := e.(Sunk); ok {
+					sunk = append(sunk, s.Ship)
+				}
+			}
+			if !reflect.DeepEqual(sunk, tt.sunk) {
+				t.Errorf("sunk = %v, want %v", sunk, tt.sunk)
+			}
+			if next.Turn != tt.turn {
+				t.Errorf("turn = %s, want %s", next.Turn, tt.turn)
+			}
+		})
+	}
+}
+
+func TestSalvoSize(t *testing.T) {
+	g := started(t, salvoRules())
+	if n := g.SalvoSize("p1"); n != 5 {
+		t.Errorf("SalvoSize with a full fleet = %d, want 5", n)
+	}
+	// p1 sinks the destroyer and submarine of p2
+	g, _, err := g.FireSalvo("p1", []string{"I1", "I2", "G1", "G2", "G3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := g.SalvoSize("p2"); n != 3 {
+		t.Errorf("SalvoSize after losing two ships = %d, want 3", n)
+	}
+	if n := g.SalvoSize("p1"); n != 5 {
+		t.Errorf("SalvoSize of the shooter = %d, want 5", n)
+	}
+	if n := g.SalvoSize("p3"); n != 0 {
+		t.Errorf("SalvoSize of a stranger = %d, want 0", n)
+	}
+}
+
+func TestSalvoWin(t *testing.T) {
+	// Each salvo of p2 is one shot per ship p1 has left it
+	salvos := []struct {
+		player string
+		shots  []string
+	}{
+		{"p1", []string{"A1", "A2", "A3", "A4", "A5"}},
+		{"p2", []string{"J1", "J2", "J3", "J4"}},
+		{"p1", []string{"C1", "C2", "C3", "C4", "E1"}},
+		{"p2", []string{"J5", "J6", "J7"}},
+		{"p1", []string{"E2", "E3", "G1", "G2", "G3"}},
+		{"p2", []string{"H1"}},
+	}
+	g := started(t, salvoRules())
+	var err error
+	for _, salvo := range salvos {
+		if g, _, err = g.FireSalvo(salvo.player, salvo.shots); err != nil {
+			t.Fatalf("FireSalvo(%s, %v): %v", salvo.player, salvo.shots, err)
+		}
+	}
+	if g.Turn != "p1" || g.Over() {
+		t.Fatalf("turn = %s, over = %v", g.Turn, g.Over())
+	}
+	g, events, err := g.FireSalvo("p1", []string{"I1", "I2", "B1", "B2", "B3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := events[len(events)-1]; last != (Won{Winner: "p1", Loser: "p2"}) {
+		t.Errorf("last event = %#v, want Won", last)
+	}
+	if g.Winner != "p1" || g.Turn != "p1" {
+		t.Errorf("winner = %s, turn = %s", g.Winner, g.Turn)
+	}
+}
+
+func TestUnshot(t *testing.T) {
+	g := started(t, ClassicRules())
+	g, _, _ = g.Fire("p1", "B1")
+	cells := g.Unshot("p1")
+	if len(cells) != 99 || cells[0] != "A1" || cells[10] != "B2" {
+		t.Errorf("Unshot = %d cells starting %v", len(cells), cells[:11])
+	}
+}
+
+func TestSunkShips(t *testing.T) {
+	g := started(t, ClassicRules())
+	var err error
+	for _, coord := range []string{"I1", "I2", "G1", "G2", "G3", "A1"} {
+		if g, _, err = g.Fire("p1", coord); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := g.SunkShips("p2"); !reflect.DeepEqual(got, []ShipType{Submarine, Destroyer}) {
+		t.Errorf("SunkShips(p2) = %v", got)
+	}
+	if got := g.SunkShips("p1"); got != nil {
+		t.Errorf("SunkShips(p1) = %v, want none", got)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	g := started(t, ClassicRules())
+	var err error
+	for _, coord := range []string{"I1", "B1"} { // hit, then miss passes the turn
+		if g, _, err = g.Fire("p1", coord); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if g, _, err = g.Fire("p2", "J1"); err != nil {
+		t.Fatal(err)
+	}
+	if g, _, err = g.Fire("p1", "I2"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Sink{{Shot: 2, Ship: Destroyer}}
+	if got := g.Sinks("p1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Sinks = %v, want %v", got, want)
+	}
+
+	restored, err := Restore(Saved{
+		Players: g.Players,
+		Boards:  g.Boards,
+		Shots:   [2][]string{{"I1", "B1", "I2"}, {"J1"}},
+		Turn:    g.Turn,
+	})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Shots, g.Shots) || restored.Turn != g.Turn {
+		t.Errorf("restored shots = %v turn %s, want %v turn %s", restored.Shots, restored.Turn, g.Shots, g.Turn)
+	}
+	if !reflect.DeepEqual(restored.Sinks("p1"), want) {
+		t.Errorf("restored Sinks = %v, want %v", restored.Sinks("p1"), want)
+	}
+
+	unplaced := Saved{Players: g.Players, Boards: [2]*Board{g.Boards[0], nil}, Shots: [2][]string{{"A1"}, nil}, Turn: "p1"}
+	if _, err := Restore(unplaced); err == nil {
+		t.Error("Restore accepted shots at a fleet that was never placed")
+	}
+}
+
+func TestRestoreResult(t *testing.T) {
+	g := started(t, ClassicRules())
+	tests := []struct {
+		name   string
+		saved  Saved
+		winner string
+		drawn  bool
+		err    bool
+	}{
+		{
+			name:  "running",
+			saved: Saved{Players: g.Players, Boards: g.Boards, Turn: "p1"},
+		},
+		{
+			name:   "forfeited",
+			saved:  Saved{Players: g.Players, Boards: g.Boards, Turn: "p1", Winner: "p2"},
+			winner: "p2",
+		},
+		{
+			name:  "drawn",
+			saved: Saved{Players: g.Players, Boards: g.Boards, Turn: "p1", Drawn: true},
+			drawn: true,
+		},
+		{
+			name:   "forfeited during placement",
+			saved:  Saved{Players: g.Players, Winner: "p1"},
+			winner: "p1",
+		},
+		{
+			name:   "fleet sunk",
+			saved:  Saved{Players: g.Players, Boards: g.Boards, Turn: "p2", Shots: [2][]string{nil, fleetCells}},
+			winner: "p2",
+		},
+		{
+			name:  "unknown winner",
+			saved: Saved{Players: g.Players, Winner: "p3"},
+			err:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restored, err := Restore(tt.saved)
+			if (err != nil) != tt.err {
+				t.Fatalf("err = %v, want error = %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if restored.Winner != tt.winner || restored.Drawn != tt.drawn {
+				t.Errorf("winner = %q, drawn = %v, want %q, %v", restored.Winner, restored.Drawn, tt.winner, tt.drawn)
+			}
+			if !restored.Over() {
+				return
+			}
+			if _, _, err := restored.Fire("p1", "J10"); !errors.Is(err, ErrGameOver) {
+				t.Errorf("Fire after restoring a finished game: err = %v, want ErrGameOver", err)
+			}
+			if _, _, err := restored.Forfeit("p1", "resign"); !errors.Is(err, ErrGameOver) {
+				t.Errorf("Forfeit after restoring a finished game: err = %v, want ErrGameOver", err)
+			}
+		})
+	}
+}
+
+func TestRestoreRules(t *testing.T) {
+	wide := ClassicRules()
+	wide.Width, wide.Height = 12, 12
+	g := started(t, wide)
+	shots := [2][]string{{"L12"}, nil}
+
+	restored, err := Restore(Saved{Rules: wide, Players: g.Players, Boards: g.Boards, Shots: shots, Turn: "p2"})
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Rules, wide) {
+		t.Errorf("rules = %+v, want %+v", restored.Rules, wide)
+	}
+	if got := restored.ShotsBy("p1"); len(got) != 1 || got[0] != (Shot{Coordinate: "L12", Result: ResultMiss}) {
+		t.Errorf("shots = %v", got)
+	}
+	if _, err := Restore(Saved{Players: g.Players, Boards: g.Boards, Shots: shots, Turn: "p2"}); err == nil {
+		t.Error("Restore accepted a shot off the classic board")
+	}
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Placement rules a fleet can break, reported in PlacementError.Rule.
+const (
+	RuleShipType    = "ship_type"   // the fleet has no ship of this type
+	RuleShipSize    = "ship_size"   // the size differs from the fleet's
+	RuleFleetCount  = "fleet_count" // too many or too few ships of a type
+	RuleCoordinate  = "coordinate"  // the start is not a cell of the board
+	RuleOrientation = "orientation"
+	RuleBounds      = "bounds"      // the ship runs off the board
+	RuleOverlap     = "overlap"     // two ships share a cell
+	RuleNoTouching  = "no_touching" // two ships touch while the rules forbid it
+)
+
+// PlacementError is one problem with a fleet placement. Index is the
+// position of the ship in the submitted fleet, or -1 when the problem is
+// with the fleet as a whole.
+type PlacementError struct {
+	Ship    ShipType `json:"ship,omitempty"`
+	Index   int      `json:"index"`
+	Cell    string   `json:"cell,omitempty"`
+	Rule    string   `json:"rule"`
+	Message string   `json:"message"`
+}
+
+func (e PlacementError) Error() string {
+	return e.Message
+}
+
+// PlacementErrors is every problem found in a placement. NewBoard returns
+// it as its error, so callers can recover the list with errors.As.
+type PlacementErrors []PlacementError
+
+func (e PlacementErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidatePlacement checks a fleet against the rules and returns every
+// problem found, or nil when the fleet can be placed.
+func ValidatePlacement(rules Rules, ships []Ship) PlacementErrors {
+	_, errs := placeFleet(rules, ships)
+	return errs
+}
+
+// placeFleet computes the cells of every ship, collecting the problems
+// instead of stopping at the first.
+func placeFleet(rules Rules, ships []Ship) (Board, PlacementErrors) {
+	var errs PlacementErrors
+	fail := func(i int, ship ShipType, cell, rule, format string, args ...interface{}) {
+		errs = append(errs, PlacementError{Ship: ship, Index: i, Cell: cell, Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	board := Board{
+		Ships: make([]Ship, len(ships)),
+		Grid:  make(map[string]string),
+	}
+	positions := make([][][2]int, len(ships))
+	owner := make(map[[2]int]int) // cell -> index of the first ship on it
+	clashed := make(map[[2]int]bool)
+	shipCounts := make(map[ShipType]int)
+	for i, ship := range ships {
+		board.Ships[i] = ship
+		entry, exists := rules.fleetEntry(ship.Type)
+		if !exists {
+			fail(i, ship.Type, "", RuleShipType, "invalid ship type: %s", ship.Type)
+			continue
+		}
+		shipCounts[ship.Type]++
+		if entry.Size != ship.Size {
+			fail(i, ship.Type, "", RuleShipSize, "invalid size for %s: expected %d, got %d", ship.Type, entry.Size, ship.Size)
+			continue
+		}
+		cells, err := shipCells(rules, ship)
+		if err != nil {
+			err.Index = i
+			errs = append(errs, *err)
+			continue
+		}
+		positions[i] = cells
+		board.Ships[i].Cells = make([]string, len(cells))
+		for k, cell := range cells {
+			name := FormatCoordinate(cell[0], cell[1])
+			board.Ships[i].Cells[k] = name
+			if j, taken := owner[cell]; taken {
+				clashed[[2]int{j, i}] = true
+				fail(i, ship.Type, name, RuleOverlap, "overlap at %s for %s", name, ship.Type)
+				continue
+			}
+			owner[cell] = i
+			board.Grid[name] = string(ship.Type)
+		}
+	}
+	for _, entry := range rules.Fleet {
+		if shipCounts[entry.Type] != entry.Count {
+			fail(-1, entry.Type, "", RuleFleetCount, "exactly %d %s required, got %d", entry.Count, entry.Type, shipCounts[entry.Type])
+		}
+	}
+
+	if rules.NoTouching {
+		// Report each touching pair once, against the later ship
+		for i, cells := range positions {
+			for _, cell := range cells {
+				for _, n := range neighbours(cell) {
+					j, taken := owner[n]
+					if !taken || j >= i || clashed[[2]int{j, i}] {
+						continue
+					}
+					clashed[[2]int{j, i}] = true
+					name := FormatCoordinate(cell[0], cell[1])
+					fail(i, ships[i].Type, name, RuleNoTouching, "%s at %s touches %s", ships[i].Type, name, ships[j].Type)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return Board{}, errs
+	}
+	return board, nil
+}
+
+// neighbours returns the eight cells around cell, on the board or not.
+func neighbours(cell [2]int) [][2]int {
+	var cells [][2]int
+	for dr := -1; dr <= 1; dr++ {
+		for dc := -1; dc <= 1; dc++ {
+			if dr != 0 || dc != 0 {
+				cells = append(cells, [2]int{cell[0] + dr, cell[1] + dc})
+			}
+		}
+	}
+	return cells
+}
+
+// shipCells computes the row and column of every cell covered by a ship
+// from its start and orientation, checking that it fits on the board.
+func shipCells(rules Rules, ship Ship) ([][2]int, *PlacementError) {
+	row, col, err := rules.ParseCoordinate(ship.Start)
+	if err != nil {
+		return nil, &PlacementError{Ship: ship.Type, Cell: ship.Start, Rule: RuleCoordinate,
+			Message: fmt.Sprintf("invalid start for %s: %v", ship.Type, err)}
+	}
+	dr, dc := 0, 0
+	switch ship.Orientation {
+	case Horizontal:
+		dc = 1
+		if col+ship.Size > rules.Width {
+			return nil, &PlacementError{Ship: ship.Type, Cell: ship.Start, Rule: RuleBounds,
+				Message: fmt.Sprintf("%s out of bounds horizontally at %s", ship.Type, ship.Start)}
+		}
+	case Vertical:
+		dr = 1
+		if row+ship.Size > rules.Height {
+			return nil, &PlacementError{Ship: ship.Type, Cell: ship.Start, Rule: RuleBounds,
+				Message: fmt.Sprintf("%s out of bounds vertically at %s", ship.Type, ship.Start)}
+		}
+	default:
+		return nil, &PlacementError{Ship: ship.Type, Rule: RuleOrientation,
+			Message: fmt.Sprintf("invalid orientation for %s: %s", ship.Type, ship.Orientation)}
+	}
+	cells := make([][2]int, ship.Size)
+	for j := range cells {
+		cells[j] = [2]int{row + j*dr, col + j*dc}
+	}
+	return cells, nil
+}
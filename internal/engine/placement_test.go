@@ -0,0 +1,132 @@
+package engine
+
+import "testing"
+
+func TestValidatePlacement(t *testing.T) {
+	noTouching := ClassicRules()
+	noTouching.NoTouching = true
+	adjacent := classicShips()
+	adjacent[1].Start = "B1"
+
+	tests := []struct {
+		name   string
+		rules  Rules
+		ships  []Ship
+		broken []string
+	}{
+		{
+			name:  "valid classic fleet",
+			rules: ClassicRules(),
+			ships: classicShips(),
+		},
+		{
+			name:  "vertical ships",
+			rules: ClassicRules(),
+			ships: withShip(0, Ship{Type: Carrier, Size: 5, Start: "A10", Orientation: Vertical}),
+		},
+		{
+			name:  "lower case start",
+			rules: ClassicRules(),
+			ships: withShip(4, Ship{Type: Destroyer, Size: 2, Start: "j9", Orientation: Horizontal}),
+		},
+		{
+			name:   "overlap",
+			rules:  ClassicRules(),
+			ships:  withShip(4, Ship{Type: Destroyer, Size: 2, Start: "A5", Orientation: Vertical}),
+			broken: []string{RuleOverlap},
+		},
+		{
+			name:   "off the right edge",
+			rules:  ClassicRules(),
+			ships:  withShip(0, Ship{Type: Carrier, Size: 5, Start: "B7", Orientation: Horizontal}),
+			broken: []string{RuleBounds},
+		},
+		{
+			name:   "off the bottom edge",
+			rules:  ClassicRules(),
+			ships:  withShip(0, Ship{Type: Carrier, Size: 5, Start: "H1", Orientation: Vertical}),
+			broken: []string{RuleBounds},
+		},
+		{
+			name:   "start not on the board",
+			rules:  ClassicRules(),
+			ships:  withShip(4, Ship{Type: Destroyer, Size: 2, Start: "K1", Orientation: Horizontal}),
+			broken: []string{RuleCoordinate},
+		},
+		{
+			name:   "unknown orientation",
+			rules:  ClassicRules(),
+			ships:  withShip(4, Ship{Type: Destroyer, Size: 2, Start: "J1", Orientation: "diagonal"}),
+			broken: []string{RuleOrientation},
+		},
+		{
+			name:   "unknown ship type",
+			rules:  ClassicRules(),
+			ships:  withShip(4, Ship{Type: "Dinghy", Size: 2, Start: "I1", Orientation: Horizontal}),
+			broken: []string{RuleShipType, RuleFleetCount},
+		},
+		{
+			name:   "wrong size",
+			rules:  ClassicRules(),
+			ships:  withShip(4, Ship{Type: Destroyer, Size: 3, Start: "I1", Orientation: Horizontal}),
+			broken: []string{RuleShipSize},
+		},
+		{
+			name:   "ship missing",
+			rules:  ClassicRules(),
+			ships:  classicShips()[:4],
+			broken: []string{RuleFleetCount},
+		},
+		{
+			name:   "ship twice",
+			rules:  ClassicRules(),
+			ships:  append(classicShips(), Ship{Type: Destroyer, Size: 2, Start: "J5", Orientation: Horizontal}),
+			broken: []string{RuleFleetCount},
+		},
+		{
+			name:  "touching allowed by default",
+			rules: ClassicRules(),
+			ships: adjacent,
+		},
+		{
+			name:   "touching forbidden",
+			rules:  noTouching,
+			ships:  adjacent,
+			broken: []string{RuleNoTouching},
+		},
+		{
+			name:   "diagonal touch forbidden",
+			rules:  noTouching,
+			ships:  withShip(4, Ship{Type: Destroyer, Size: 2, Start: "B6", Orientation: Horizontal}),
+			broken: []string{RuleNoTouching},
+		},
+		{
+			name:  "apart with no touching",
+			rules: noTouching,
+			ships: classicShips(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := ValidatePlacement(tt.rules, tt.ships)
+			var got []string
+			for _, err := range errs {
+				got = append(got, err.Rule)
+			}
+			if !sameStrings(got, tt.broken) {
+				t.Fatalf("rules broken = %v, want %v (%v)", got, tt.broken, errs)
+			}
+		})
+	}
+}
+
+func TestPlacementErrorIndex(t *testing.T) {
+	ships := withShip(2, Ship{Type: Cruiser, Size: 3, Start: "E9", Orientation: Horizontal})
+	errs := ValidatePlacement(ClassicRules(), ships)
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want one", errs)
+	}
+	if errs[0].Index != 2 || errs[0].Ship != Cruiser {
+		t.Errorf("error = %+v, want the cruiser at index 2", errs[0])
+	}
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// ErrNoPlacement means no valid placement of the fleet was found.
+var ErrNoPlacement = errors.New("could not find a placement for the fleet")
+
+// Bounds on the work RandomFleet does before giving up.
+const (
+	sampleAttempts = 10000   // whole-fleet samples before falling back to search
+	searchLimit    = 1000000 // positions tried by the search
+)
+
+// RandomFleet returns a random valid placement of the fleet of rules, drawn
+// from rng. Fleets are sampled by placing every ship uniformly at random and
+// starting over on any clash, which makes each valid placement equally
+// likely. Fleets too dense for that to succeed are placed by a randomised
+// backtracking search instead, which does not weigh placements equally and
+// gives up with ErrNoPlacement after searchLimit tries.
+func RandomFleet(rules Rules, rng *rand.Rand) ([]Ship, error) {
+	if err := rules.Validate(); err != nil {
+		return nil, err
+	}
+	var sizes []FleetEntry // one entry per ship
+	for _, entry := range rules.Fleet {
+		for n := 0; n < entry.Count; n++ {
+			sizes = append(sizes, entry)
+		}
+	}
+	candidates := make(map[int][]Ship)
+	for _, entry := range sizes {
+		if _, ok := candidates[entry.Size]; !ok {
+			candidates[entry.Size] = Positions(rules, entry.Size)
+		}
+	}
+
+	for attempt := 0; attempt < sampleAttempts; attempt++ {
+		grid := newOccupancy(rules)
+		ships := make([]Ship, 0, len(sizes))
+		for _, entry := range sizes {
+			options := candidates[entry.Size]
+			ship := options[rng.Intn(len(options))]
+			if !grid.fits(ship) {
+				break
+			}
+			grid.mark(ship)
+			ship.Type = entry.Type
+			ships = append(ships, ship)
+		}
+		if len(ships) == len(sizes) {
+			return ships, nil
+		}
+	}
+
+	// Largest ships first prunes the search soonest
+	sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].Size > sizes[j].Size })
+	s := &search{grid: newOccupancy(rules), sizes: sizes, candidates: candidates, rng: rng}
+	if !s.place(0) {
+		return nil, ErrNoPlacement
+	}
+	return s.ships, nil
+}
+
+// Positions lists every in-bounds position of a ship of the given size.
+// A single cell is listed once, not once per orientation.
+func Positions(rules Rules, size int) []Ship {
+	var ships []Ship
+	for row := 0; row < rules.Height; row++ {
+		for col := 0; col < rules.Width; col++ {
+			if col+size <= rules.Width {
+				ships = append(ships, shipAt(row, col, size, Horizontal))
+			}
+			if size > 1 && row+size <= rules.Height {
+				ships = append(ships, shipAt(row, col, size, Vertical))
+			}
+		}
+	}
+	return ships
+}
+
+func shipAt(row, col, size int, orientation string) Ship {
+	ship := Ship{Size: size, Start: FormatCoordinate(row, col), Orientation: orientation}
+	for j := 0; j < size; j++ {
+		if orientation == Horizontal {
+			ship.Cells = append(ship.Cells, FormatCoordinate(row, col+j))
+		} else {
+			ship.Cells = append(ship.Cells, FormatCoordinate(row+j, col))
+		}
+	}
+	return ship
+}
+
+// occupancy tracks the cells a ship may not use: the ships placed so far
+// and, when ships may not touch, the cells around them.
+type occupancy struct {
+	rules   Rules
+	blocked map[string]int // cell -> number of ships blocking it
+}
+
+func newOccupancy(rules Rules) *occupancy {
+	return &occupancy{rules: rules, blocked: make(map[string]int)}
+}
+
+func (o *occupancy) fits(ship Ship) bool {
+	for _, cell := range ship.Cells {
+		if o.blocked[cell] > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (o *occupancy) mark(ship Ship) {
+	o.update(ship, 1)
+}
+
+func (o *occupancy) unmark(ship Ship) {
+	o.update(ship, -1)
+}
+
+func (o *occupancy) update(ship Ship, delta int) {
+	cells := make(map[string]bool)
+	for _, cell := range ship.Cells {
+		cells[cell] = true
+		if !o.rules.NoTouching {
+			continue
+		}
+		row, col, _ := o.rules.ParseCoordinate(cell)
+		for _, n := range neighbours([2]int{row, col}) {
+			if n[0] >= 0 && n[0] < o.rules.Height && n[1] >= 0 && n[1] < o.rules.Width {
+				cells[FormatCoordinate(n[0], n[1])] = true
+			}
+		}
+	}
+	for cell := range cells {
+		o.blocked[cell] += delta
+	}
+}
+
+// search places the ships one at a time, trying positions in random order
+// and backtracking on a dead end.
+type search struct {
+	grid       *occupancy
+	sizes      []FleetEntry
+	candidates map[int][]Ship
+	rng        *rand.Rand
+	ships      []Ship
+	tried      int
+}
+
+func (s *search) place(i int) bool {
+	if i == len(s.sizes) {
+		return true
+	}
+	options := s.candidates[s.sizes[i].Size]
+	for _, k := range s.rng.Perm(len(options)) {
+		s.tried++
+		if s.tried > searchLimit {
+			return false
+		}
+		ship := options[k]
+		if !s.grid.fits(ship) {
+			continue
+		}
+		ship.Type = s.sizes[i].Type
+		s.grid.mark(ship)
+		s.ships = append(s.ships, ship)
+		if s.place(i + 1) {
+			return true
+		}
+		s.ships = s.ships[:len(s.ships)-1]
+		s.grid.unmark(ship)
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// presets are the rulesets players can pick, and a few that push the
+// placement search.
+func presets() map[string]Rules {
+	noTouching := ClassicRules()
+	noTouching.NoTouching = true
+	return map[string]Rules{
+		"classic":     ClassicRules(),
+		"salvo":       salvoRules(),
+		"no touching": noTouching,
+		"small": {
+			Mode: ModeClassic, Width: 5, Height: 5,
+			Fleet: []FleetEntry{{Type: "Sloop", Size: 2, Count: 2}, {Type: "Brig", Size: 3, Count: 1}},
+		},
+		"dense": {
+			Mode: ModeClassic, Width: 6, Height: 6,
+			Fleet: []FleetEntry{{Type: "Long", Size: 6, Count: 2}, {Type: "Mid", Size: 3, Count: 2}},
+		},
+		"largest": {
+			Mode: ModeSalvo, Width: MaxBoardSize, Height: MaxBoardSize, NoTouching: true,
+			Fleet: []FleetEntry{{Type: "Carrier", Size: 5, Count: 4}, {Type: "Boat", Size: 1, Count: 10}},
+		},
+	}
+}
+
+func TestRandomFleet(t *testing.T) {
+	for name, rules := range presets() {
+		t.Run(name, func(t *testing.T) {
+			if err := rules.Validate(); err != nil {
+				t.Fatalf("preset is invalid: %v", err)
+			}
+			for seed := int64(0); seed < 20; seed++ {
+				ships, err := RandomFleet(rules, rand.New(rand.NewSource(seed)))
+				if err != nil {
+					t.Fatalf("seed %d: %v", seed, err)
+				}
+				if errs := ValidatePlacement(rules, ships); errs != nil {
+					t.Fatalf("seed %d: invalid fleet: %v", seed, errs)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomFleetDeterministic(t *testing.T) {
+	a, _ := RandomFleet(ClassicRules(), rand.New(rand.NewSource(42)))
+	b, _ := RandomFleet(ClassicRules(), rand.New(rand.NewSource(42)))
+	for i := range a {
+		if a[i].Start != b[i].Start || a[i].Orientation != b[i].Orientation {
+			t.Fatalf("same seed gave %v and %v", a, b)
+		}
+	}
+}
+
+func TestRandomFleetInvalidRules(t *testing.T) {
+	if _, err := RandomFleet(Rules{Mode: "blitz"}, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("RandomFleet accepted invalid rules")
+	}
+}
@@ -0,0 +1,238 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits on custom boards. Rows are lettered, so a board has at most 26.
+const (
+	MinBoardSize = 5
+	MaxBoardSize = 26
+)
+
+// FleetEntry is one kind of ship in a fleet: Count ships of Size cells.
+type FleetEntry struct {
+	Type  ShipType `json:"type"`
+	Size  int      `json:"size"`
+	Count int      `json:"count"`
+}
+
+// Rules is the ruleset of one game: the mode, the board dimensions, the
+// fleet each player places and how it may be placed. The zero value means
+// classic rules.
+type Rules struct {
+	Mode   string       `json:"mode"`
+	Width  int          `json:"width"`  // columns, numbered from 1
+	Height int          `json:"height"` // rows, lettered from A
+	Fleet  []FleetEntry `json:"fleet"`
+	// NoTouching forbids ships from touching, even diagonally
+	NoTouching bool `json:"noTouching,omitempty"`
+}
+
+// classicFleet is ShipConfig in the order fleets are listed.
+var classicFleet = []FleetEntry{
+	{Type: Carrier, Size: 5, Count: 1},
+	{Type: Battleship, Size: 4, Count: 1},
+	{Type: Cruiser, Size: 3, Count: 1},
+	{Type: Submarine, Size: 3, Count: 1},
+	{Type: Destroyer, Size: 2, Count: 1},
+}
+
+// ClassicRules returns the classic 10x10 ruleset with one ship of each type.
+func ClassicRules() Rules {
+	return Rules{
+		Mode:   ModeClassic,
+		Width:  BoardSize,
+		Height: BoardSize,
+		Fleet:  append([]FleetEntry{}, classicFleet...),
+	}
+}
+
+// WithDefaults fills the unset parts of r with the classic rules.
+func (r Rules) WithDefaults() Rules {
+	classic := ClassicRules()
+	if r.Mode == "" {
+		r.Mode = classic.Mode
+	}
+	if r.Width == 0 && r.Height == 0 {
+		r.Width, r.Height = classic.Width, classic.Height
+	}
+	if len(r.Fleet) == 0 {
+		r.Fleet = classic.Fleet
+	}
+	return r
+}
+
+var shipTypePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]{0,19}$`)
+
+// Validate checks that the ruleset is playable.
+func (r Rules) Validate() error {
+	if r.Mode != ModeClassic && r.Mode != ModeSalvo {
+		return fmt.Errorf("unknown game mode %q", r.Mode)
+	}
+	if r.Width < MinBoardSize || r.Width > MaxBoardSize || r.Height < MinBoardSize || r.Height > MaxBoardSize {
+		return fmt.Errorf("board must be between %dx%d and %dx%d, got %dx%d",
+			MinBoardSize, MinBoardSize, MaxBoardSize, MaxBoardSize, r.Width, r.Height)
+	}
+	if len(r.Fleet) == 0 {
+		return errors.New("fleet is empty")
+	}
+	longest := r.Width
+	if r.Height > longest {
+		longest = r.Height
+	}
+	seen := make(map[ShipType]bool)
+	cells := 0
+	for _, entry := range r.Fleet {
+		if !shipTypePattern.MatchString(string(entry.Type)) {
+			return fmt.Errorf("invalid ship type %q", entry.Type)
+		}
+		if seen[entry.Type] {
+			return fmt.Errorf("ship type %s listed twice", entry.Type)
+		}
+		seen[entry.Type] = true
+		if entry.Size < 1 || entry.Size > longest {
+			return fmt.Errorf("invalid size for %s: %d", entry.Type, entry.Size)
+		}
+		if entry.Count < 1 {
+			return fmt.Errorf("invalid count for %s: %d", entry.Type, entry.Count)
+		}
+		cells += entry.Size * entry.Count
+	}
+	// Leave room to place the fleet and for the shots to mean something
+	if cells > r.Cells()/2 {
+		return fmt.Errorf("fleet covers %d cells, at most %d allowed on a %dx%d board", cells, r.Cells()/2, r.Width, r.Height)
+	}
+	if r.NoTouching {
+		// Grow the board by one row and column and every ship by the cells
+		// to its right and below: apart ships then never share a cell.
+		// This rules out fleets that cannot fit, not every unplaceable one.
+		padded := 0
+		for _, entry := range r.Fleet {
+			padded += 2 * (entry.Size + 1) * entry.Count
+		}
+		if padded > (r.Width+1)*(r.Height+1) {
+			return fmt.Errorf("fleet does not fit on a %dx%d board without ships touching", r.Width, r.Height)
+		}
+	}
+	return nil
+}
+
+// Cells returns the number of cells on the board.
+func (r Rules) Cells() int {
+	return r.Width * r.Height
+}
+
+// Ships returns the number of ships in the fleet.
+func (r Rules) Ships() int {
+	n := 0
+	for _, entry := range r.Fleet {
+		n += entry.Count
+	}
+	return n
+}
+
+func (r Rules) fleetEntry(t ShipType) (FleetEntry, bool) {
+	for _, entry := range r.Fleet {
+		if entry.Type == t {
+			return entry, true
+		}
+	}
+	return FleetEntry{}, false
+}
+
+// Key returns a canonical string for the ruleset; two rulesets with the same
+// key are the same game. Classic boards are keyed by the mode alone.
+func (r Rules) Key() string {
+	r = r.WithDefaults()
+	classic := ClassicRules()
+	classic.Mode = r.Mode
+	if key := r.fullKey(); key != classic.fullKey() {
+		return key
+	}
+	return r.Mode
+}
+
+// fullKey lists the mode, the board and the fleet sorted by ship type,
+// followed by the placement options.
+func (r Rules) fullKey() string {
+	fleet := append([]FleetEntry{}, r.Fleet...)
+	sort.Slice(fleet, func(i, j int) bool { return fleet[i].Type < fleet[j].Type })
+	parts := make([]string, len(fleet))
+	for i, entry := range fleet {
+		parts[i] = fmt.Sprintf("%s:%dx%d", entry.Type, entry.Size, entry.Count)
+	}
+	key := fmt.Sprintf("%s/%dx%d/%s", r.Mode, r.Width, r.Height, strings.Join(parts, ","))
+	if r.NoTouching {
+		key += "/" + noTouchingKey
+	}
+	return key
+}
+
+const noTouchingKey = "no-touching"
+
+// ParseRulesKey is the inverse of Key.
+func ParseRulesKey(key string) (Rules, error) {
+	parts := strings.Split(key, "/")
+	if len(parts) == 1 {
+		r := ClassicRules()
+		r.Mode = parts[0]
+		return r, r.Validate()
+	}
+	if len(parts) != 3 && !(len(parts) == 4 && parts[3] == noTouchingKey) {
+		return Rules{}, fmt.Errorf("invalid rules key %q", key)
+	}
+	r := Rules{Mode: parts[0], NoTouching: len(parts) == 4}
+	if _, err := fmt.Sscanf(parts[1], "%dx%d", &r.Width, &r.Height); err != nil {
+		return Rules{}, fmt.Errorf("invalid board in rules key %q", key)
+	}
+	for _, part := range strings.Split(parts[2], ",") {
+		name, spec, ok := strings.Cut(part, ":")
+		size, count, ok2 := strings.Cut(spec, "x")
+		if !ok || !ok2 {
+			return Rules{}, fmt.Errorf("invalid fleet in rules key %q", key)
+		}
+		entry := FleetEntry{Type: ShipType(name)}
+		var err1, err2 error
+		entry.Size, err1 = strconv.Atoi(size)
+		entry.Count, err2 = strconv.Atoi(count)
+		if err1 != nil || err2 != nil {
+			return Rules{}, fmt.Errorf("invalid fleet in rules key %q", key)
+		}
+		r.Fleet = append(r.Fleet, entry)
+	}
+	return r, r.Validate()
+}
+
+// ParseCoordinate converts "A1" to a zero-based row and column on the board.
+func (r Rules) ParseCoordinate(coord string) (row, col int, err error) {
+	if len(coord) < 2 {
+		return 0, 0, fmt.Errorf("invalid coordinate: %s", coord)
+	}
+	rowChar := strings.ToUpper(coord[:1])[0]
+	if rowChar < 'A' || int(rowChar-'A') >= r.Height {
+		return 0, 0, fmt.Errorf("invalid row: %c", rowChar)
+	}
+	col, err = strconv.Atoi(coord[1:])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid column: %s", coord[1:])
+	}
+	if col < 1 || col > r.Width {
+		return 0, 0, fmt.Errorf("column out of bounds: %d", col)
+	}
+	return int(rowChar - 'A'), col - 1, nil
+}
+
+// normalize returns the canonical spelling of a coordinate ("a01" -> "A1").
+func (r Rules) normalize(coord string) (string, error) {
+	row, col, err := r.ParseCoordinate(coord)
+	if err != nil {
+		return "", err
+	}
+	return FormatCoordinate(row, col), nil
+}
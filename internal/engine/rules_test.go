@@ -0,0 +1,104 @@
+package engine
+
+import "testing"
+
+func TestRulesValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		ok    bool
+	}{
+		{"classic", ClassicRules(), true},
+		{"zero value defaults to classic", Rules{}.WithDefaults(), true},
+		{"salvo", salvoRules(), true},
+		{"unknown mode", Rules{Mode: "blitz"}.WithDefaults(), false},
+		{"board too small", Rules{Width: 4, Height: 4, Fleet: []FleetEntry{{Type: "Boat", Size: 2, Count: 1}}}.WithDefaults(), false},
+		{"board too large", Rules{Width: 27, Height: 10}.WithDefaults(), false},
+		{"largest board", Rules{Width: MaxBoardSize, Height: MaxBoardSize}.WithDefaults(), true},
+		{"ship longer than the board", Rules{Width: 5, Height: 5, Fleet: []FleetEntry{{Type: "Long", Size: 6, Count: 1}}}.WithDefaults(), false},
+		{"type listed twice", Rules{Fleet: []FleetEntry{{Type: "A", Size: 2, Count: 1}, {Type: "A", Size: 3, Count: 1}}}.WithDefaults(), false},
+		{"invalid type", Rules{Fleet: []FleetEntry{{Type: "a b", Size: 2, Count: 1}}}.WithDefaults(), false},
+		{"zero count", Rules{Fleet: []FleetEntry{{Type: "A", Size: 2, Count: 0}}}.WithDefaults(), false},
+		{"fleet over half the board", Rules{Width: 5, Height: 5, Fleet: []FleetEntry{{Type: "A", Size: 5, Count: 3}}}.WithDefaults(), false},
+		{"no touching fits", Rules{NoTouching: true}.WithDefaults(), true},
+		{"no touching does not fit", Rules{Width: 8, Height: 8, NoTouching: true, Fleet: []FleetEntry{{Type: "A", Size: 1, Count: 30}}}.WithDefaults(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.rules.Validate(); (err == nil) != tt.ok {
+				t.Errorf("Validate() = %v, want ok = %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRulesKey(t *testing.T) {
+	custom := Rules{
+		Mode:       ModeSalvo,
+		Width:      12,
+		Height:     8,
+		Fleet:      []FleetEntry{{Type: "Sloop", Size: 2, Count: 3}, {Type: "Brig", Size: 4, Count: 1}},
+		NoTouching: true,
+	}
+	tests := []struct {
+		name  string
+		rules Rules
+		key   string
+	}{
+		{"classic", ClassicRules(), "classic"},
+		{"salvo", salvoRules(), "salvo"},
+		{"zero value", Rules{}, "classic"},
+		{"custom", custom, "salvo/12x8/Brig:4x1,Sloop:2x3/no-touching"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := tt.rules.Key()
+			if key != tt.key {
+				t.Fatalf("Key() = %q, want %q", key, tt.key)
+			}
+			parsed, err := ParseRulesKey(key)
+			if err != nil {
+				t.Fatalf("ParseRulesKey(%q): %v", key, err)
+			}
+			if parsed.Key() != key {
+				t.Errorf("round trip gave %q", parsed.Key())
+			}
+		})
+	}
+
+	for _, key := range []string{"", "blitz", "classic/10x10", "classic/axb/A:2x1", "classic/10x10/A:2", "classic/10x10/A:2x1/extra"} {
+		if _, err := ParseRulesKey(key); err == nil {
+			t.Errorf("ParseRulesKey(%q) accepted", key)
+		}
+	}
+}
+
+func TestRulesParseCoordinate(t *testing.T) {
+	wide := Rules{Width: 26, Height: 26}
+	tests := []struct {
+		rules    Rules
+		coord    string
+		row, col int
+		ok       bool
+	}{
+		{ClassicRules(), "A1", 0, 0, true},
+		{ClassicRules(), "j10", 9, 9, true},
+		{ClassicRules(), "A01", 0, 0, true},
+		{ClassicRules(), "K1", 0, 0, false},
+		{ClassicRules(), "A11", 0, 0, false},
+		{ClassicRules(), "A0", 0, 0, false},
+		{ClassicRules(), "A", 0, 0, false},
+		{ClassicRules(), "AA", 0, 0, false},
+		{wide, "Z26", 25, 25, true},
+	}
+	for _, tt := range tests {
+		row, col, err := tt.rules.ParseCoordinate(tt.coord)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseCoordinate(%q) err = %v, want ok = %v", tt.coord, err, tt.ok)
+			continue
+		}
+		if tt.ok && (row != tt.row || col != tt.col) {
+			t.Errorf("ParseCoordinate(%q) = %d, %d, want %d, %d", tt.coord, row, col, tt.row, tt.col)
+		}
+	}
+}
@@ -2,8 +2,13 @@ package game
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"log"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/auth"
+	"github.com/krishanu7/battleship-backend/internal/engine"
 )
 
 type Handler struct {
@@ -17,7 +22,6 @@ func NewHandler(service *Service) *Handler {
 }
 
 type PlaceShipsRequest struct {
-	PlayerID string `json:"player_id"`
 	RoomID   string `json:"room_id"`
 	Ships    []Ship `json:"ships"`
 }
@@ -26,23 +30,51 @@ type PlaceShipsResponse struct {
 	Message string `json:"message"`
 }
 
+// RandomFleetRequest asks for a random placement in a room. Seed is
+// optional; the response carries the seed used so it can be repeated.
+type RandomFleetRequest struct {
+	RoomID string `json:"room_id"`
+	Seed   *int64 `json:"seed"`
+}
+
+type RandomFleetResponse struct {
+	Ships []Ship `json:"ships"`
+	Seed  int64  `json:"seed"`
+}
+
+// PlacementErrorResponse is the body of a 400 for a fleet that breaks the
+// placement rules; every problem is listed so the client can mark them all.
+type PlacementErrorResponse struct {
+	Error      string                  `json:"error"`
+	Violations []engine.PlacementError `json:"violations"`
+}
+
 func (h *Handler) PlaceShips(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
 	var req PlaceShipsRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	if req.PlayerID == "" || req.RoomID == "" || len(req.Ships) == 0 {
-		http.Error(w, "Missing player_id, room_id or ships", http.StatusBadRequest)
+	if req.RoomID == "" || len(req.Ships) == 0 {
+		http.Error(w, "Missing room_id or ships", http.StatusBadRequest)
 		return
 	}
 
-	_, err := h.service.PlaceShips(req.RoomID, req.PlayerID, req.Ships)
+	_, err := h.service.PlaceShips(req.RoomID, playerID, req.Ships)
 	
+	if writePlacementErrors(w, err) {
+		log.Printf("Rejected placement of %s: %v", playerID, err)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Printf("Failed to place ships for %s: %v", req.PlayerID, err)
+		log.Printf("Failed to place ships for %s: %v", playerID, err)
 		return
 	}
 
@@ -53,4 +85,95 @@ func (h *Handler) PlaceShips(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		log.Printf("Failed to encode response: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+// ValidatePlacement checks a fleet against the rules of the room without
+// placing it, so the client can mark problems before submitting.
+func (h *Handler) ValidatePlacement(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req PlaceShipsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RoomID == "" {
+		http.Error(w, "Missing room_id", http.StatusBadRequest)
+		return
+	}
+
+	err := h.service.CheckPlacement(req.RoomID, playerID, req.Ships)
+	if writePlacementErrors(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlaceShipsResponse{Message: "Placement is valid"})
+}
+
+// RandomFleet generates a valid placement for the rules of the room, for
+// clients offering an auto-place button. Nothing is stored; the ships are
+// submitted to PlaceShips like any other placement.
+func (h *Handler) RandomFleet(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req RandomFleetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.RoomID == "" {
+		http.Error(w, "Missing room_id", http.StatusBadRequest)
+		return
+	}
+	seed := time.Now().UnixNano()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+
+	ships, err := h.service.RandomFleet(req.RoomID, playerID, seed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		log.Printf("Failed to generate fleet for %s: %v", playerID, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(RandomFleetResponse{Ships: ships, Seed: seed}); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// writePlacementErrors answers with the violations when err rejects a
+// placement, and reports whether it did.
+func writePlacementErrors(w http.ResponseWriter, err error) bool {
+	var violations engine.PlacementErrors
+	if !errors.As(err, &violations) {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	if err := json.NewEncoder(w).Encode(PlacementErrorResponse{Error: "invalid placement", Violations: violations}); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+	return true
+}
+
+// LiveGames lists the games in progress that can be watched.
+func (h *Handler) LiveGames(w http.ResponseWriter, r *http.Request) {
+	games, err := h.service.LiveGames()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(games)
+}
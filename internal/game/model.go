@@ -1,27 +1,20 @@
 package game
 
 import (
-	"fmt"
-	"strings"
+	"github.com/krishanu7/battleship-backend/internal/engine"
 )
 
-type ShipType string
+// Ship types and placement rules live in the engine package; these aliases
+// keep the request and storage formats of this package unchanged.
+type ShipType = engine.ShipType
 
-const (
-	Carrier    ShipType = "Carrier"
-	Battleship ShipType = "Battleship"
-	Cruiser    ShipType = "Cruiser"
-	Submarine  ShipType = "Submarine"
-	Destroyer  ShipType = "Destroyer"
-)
+type Ship = engine.Ship
+
+// Rules is the ruleset of a game: mode, board size and fleet.
+type Rules = engine.Rules
+
+type FleetEntry = engine.FleetEntry
 
-type Ship struct {
-	Type        ShipType `json:"type"`
-	Size        int      `json:"size"`
-	Start       string   `json:"start"`       // "A1"
-	Orientation string   `json:"orientation"` // "horizontal" or "vertical"
-	Cells       []string `json:"cells"`       // Computed occupied cells (["A1", "A2", ...])
-}
 type Board struct {
 	PlayerID string	`json:"playerId"`
 	RoomID   string	`json:"roomId"`
@@ -29,10 +22,42 @@ type Board struct {
 	Grid     map[string]string `json:"grid"` // {"A1": "Carrier", "A2": "Carrier", ...}
 }
 
+// Game modes, part of the ruleset chosen when joining matchmaking
+const (
+	ModeClassic = engine.ModeClassic
+	ModeSalvo   = engine.ModeSalvo
+)
+
+// Reasons a game can end other than a sunk fleet
+const (
+	ReasonResign    = "resign"
+	ReasonTimeout   = "timeout"
+	ReasonAbandoned = "abandoned"
+	ReasonDraw      = "draw"
+)
+
 type GameState struct {
 	RoomID string `json:"roomId"`
 	Turn string `json:"turn"` // curr playerId
 	StartedAt int64 `json:"startedAt"`
+	Rules Rules `json:"rules"` // set when the room is created, defaults to classic
+	// Turn clock, absent when the game is untimed
+	TimeControl   *TimeControl     `json:"timeControl,omitempty"`
+	TurnStartedAt int64            `json:"turnStartedAt,omitempty"` // unix milliseconds
+	Deadline      int64            `json:"deadline,omitempty"`      // unix milliseconds
+	Banks         map[string]int64 `json:"banks,omitempty"`         // remaining bank per player, milliseconds
+	DrawOfferedBy string           `json:"drawOfferedBy,omitempty"` // pending draw offer, cleared by the next move
+	SalvoShots    int              `json:"salvoShots,omitempty"`    // shots the player to move must fire in salvo mode
+	Unranked      bool             `json:"unranked,omitempty"`      // set for bot games, which leave stats and Elo alone
+	FirstTurn     string           `json:"firstTurn,omitempty"`     // set ahead for rematches, else picked at random on start
+	Series        *Series          `json:"series,omitempty"`        // score of the rematches so far, including this game once over
+	Private       bool             `json:"private,omitempty"`       // lobby games, which cannot be spectated
+	// Result of a game that is over, kept until the room is cleared so no
+	// move is accepted after the game ended
+	Winner string `json:"winner,omitempty"`
+	Drawn  bool   `json:"drawn,omitempty"`
+	// Fleets of both players, kept once the game is over to reveal them
+	fleets map[string][]Ship
 }
 
 type Attack struct {
@@ -40,43 +65,74 @@ type Attack struct {
 	Result string `json:"result"` // miss or hit
 }
 
+// ShotRecord is one entry of the ordered shot log kept for match history.
+type ShotRecord struct {
+	Player     string `json:"player"`
+	Coordinate string `json:"coordinate"`
+	Result     string `json:"result"`
+	At         int64  `json:"at"` // unix milliseconds
+}
+
+// Snapshot is everything a player needs to resume a game after
+// reconnecting. Only the player's own board is included.
+type Snapshot struct {
+	RoomID        string           `json:"roomId"`
+	Rules         Rules            `json:"rules"`
+	PlayerID      string           `json:"playerId"`
+	OpponentID    string           `json:"opponentId"`
+	Started       bool             `json:"started"`
+	Turn          string           `json:"turn"`
+	SalvoShots    int              `json:"salvoShots,omitempty"`
+	Board         *Board           `json:"board"`
+	OpponentReady bool             `json:"opponentReady"` // opponent has placed ships
+	Shots         []engine.Shot    `json:"shots"`         // fired by the player
+	OpponentShots []engine.Shot    `json:"opponentShots"` // fired at the player
+	SunkShips     []ShipType       `json:"sunkShips"`     // opponent ships the player sank
+	Sinks         []engine.Sink    `json:"sinks"`         // which of Shots sank each of SunkShips
+	LostShips     []ShipType       `json:"lostShips"`     // player ships the opponent sank
+	TimeControl   *TimeControl     `json:"timeControl,omitempty"`
+	Deadline      int64            `json:"deadline,omitempty"` // unix milliseconds
+	Banks         map[string]int64 `json:"banks,omitempty"`
+	Unranked      bool             `json:"unranked,omitempty"`
+}
+
+
+// SpectatorView is a live game as spectators see it: every shot and sunk
+// ship, but no ship positions.
+type SpectatorView struct {
+	RoomID     string                   `json:"roomId"`
+	Rules      Rules                    `json:"rules"`
+	Players    [2]string                `json:"players"`
+	Turn       string                   `json:"turn"`
+	SalvoShots int                      `json:"salvoShots,omitempty"`
+	Shots      map[string][]engine.Shot `json:"shots"`              // by shooter
+	SunkShips  map[string][]ShipType    `json:"sunkShips"`          // by the player who sank them
+	Deadline   int64                    `json:"deadline,omitempty"` // unix milliseconds
+	Banks      map[string]int64         `json:"banks,omitempty"`
+}
+// LiveGame is an entry of the public list of games that can be watched.
+type LiveGame struct {
+	RoomID    string    `json:"roomId"`
+	Players   [2]string `json:"players"`
+	Rules     Rules     `json:"rules"`
+	Turn      string    `json:"turn"`
+	StartedAt int64     `json:"startedAt"`
+	Unranked  bool      `json:"unranked,omitempty"`
+}
+
 type PlayerStats struct {
 	PlayerID string `json:"playerId"`
 	Wins int `json:"wins"`
 	Losses int `json:"losses"`
+	Draws int `json:"draws"`
 	Elo int `json:"elo"`
 }
 
-var ShipConfig = map[ShipType]int{
-	Carrier:    5,
-	Battleship: 4,
-	Cruiser:    3,
-	Submarine:  3,
-	Destroyer:  2,
+func (b *Board) engineBoard() *engine.Board {
+	return &engine.Board{Ships: b.Ships, Grid: b.Grid}
 }
 
-// converts "A1" to row (0-9) and col (0-9).
-func ParseCoordinate(coord string) (row, col int, err error) {
-	if len(coord) < 2 {
-		return 0, 0, fmt.Errorf("invalid coordinate: %s", coord)
-	}
-	rowChar := strings.ToUpper(string(coord[0]))
-	colStr := coord[1:]
-
-	if rowChar < "A" || rowChar > "J" {
-		return 0, 0, fmt.Errorf("invalid row: %s", rowChar)
-	}
-	colNum, err := fmt.Sscanf(colStr, "%d", &col)
-	if err != nil || colNum != 1 {
-		return 0, 0, fmt.Errorf("invalid column: %s", colStr)
-	}
-	if col < 1 || col > 10 {
-		return 0, 0, fmt.Errorf("column out of bounds: %d", col)
-	}	
-	return int(rowChar[0] - 'A'), col - 1, nil
+// Over reports whether the game ended, by a sunk fleet or otherwise.
+func (gs *GameState) Over() bool {
+	return gs.Winner != "" || gs.Drawn
 }
-
-//converts row (0-9) and col (0-9) to "A1"
-func FormatCoordinate(row, col int) string {
-	return fmt.Sprintf("%c%d", 'A'+row, col+1)
-}
\ No newline at end of file
@@ -0,0 +1,36 @@
+package game
+
+// Notifications the game service sends to general connections. They are
+// part of the client protocol, which lists them with the other messages.
+
+// MatchFound tells a player which room to join for their next game, found
+// by matchmaking, a lobby or a rematch.
+type MatchFound struct {
+	Type      string  `json:"type"` // "match_found"
+	RoomID    string  `json:"roomId"`
+	Player    string  `json:"player"`
+	Opponent  string  `json:"opponent"`
+	Mode      string  `json:"mode"`
+	Rules     Rules   `json:"rules"`
+	Unranked  bool    `json:"unranked,omitempty"`
+	Series    *Series `json:"series,omitempty"`    // rematches only
+	FirstTurn string  `json:"firstTurn,omitempty"` // rematches only
+}
+
+// ShipsPlaced tells a player their fleet was placed.
+type ShipsPlaced struct {
+	Type   string `json:"type"` // "ships_placed"
+	RoomID string `json:"roomId"`
+	Player string `json:"player"`
+}
+
+// RematchNotice tells a player that From offered or declined a rematch of
+// the game in RoomID.
+type RematchNotice struct {
+	Type   string  `json:"type"` // "rematch_offered" or "rematch_declined"
+	Player string  `json:"player"`
+	From   string  `json:"from"`
+	RoomID string  `json:"roomId"`
+	Series *Series `json:"series,omitempty"`
+	BestOf int     `json:"bestOf,omitempty"`
+}
@@ -0,0 +1,283 @@
+package game
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/bot"
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+var ErrNoRematch = errors.New("no rematch for this game")
+
+// Players can ask for a rematch for rematchWindow after a game ends. A
+// series is played to maxBestOf games at most.
+const (
+	rematchWindow = 5 * time.Minute
+	maxBestOf     = 15
+)
+
+// Series keeps the score of a game and its consecutive rematches.
+type Series struct {
+	ID     string         `json:"id"`               // room of the first game
+	BestOf int            `json:"bestOf,omitempty"` // 0 for an open-ended series
+	Games  int            `json:"games"`            // games finished
+	Wins   map[string]int `json:"wins"`
+	Draws  int            `json:"draws,omitempty"`
+}
+
+// Over reports whether a player has won a best-of-N series.
+func (s *Series) Over() bool {
+	if s == nil || s.BestOf == 0 {
+		return false
+	}
+	for _, wins := range s.Wins {
+		if wins > s.BestOf/2 {
+			return true
+		}
+	}
+	return false
+}
+
+// record returns the series with the result of a finished game added. The
+// first game of a room that was not a rematch starts a series.
+func (s *Series) record(roomID string, g engine.Game) *Series {
+	next := &Series{ID: roomID, Wins: make(map[string]int)}
+	if s != nil {
+		next.ID, next.BestOf, next.Games, next.Draws = s.ID, s.BestOf, s.Games, s.Draws
+		for player, wins := range s.Wins {
+			next.Wins[player] = wins
+		}
+	}
+	next.Games++
+	if g.Drawn {
+		next.Draws++
+	} else {
+		next.Wins[g.Winner]++
+	}
+	return next
+}
+
+// rematch is what is kept of a finished game so that it can be played again.
+type rematch struct {
+	Players   [2]string `json:"players"`
+	Rules     Rules     `json:"rules"`
+	Unranked  bool      `json:"unranked,omitempty"`
+	Private   bool      `json:"private,omitempty"`
+	Series    *Series   `json:"series"`
+	FirstTurn string    `json:"firstTurn"` // of the next game
+	OfferedBy string    `json:"offeredBy,omitempty"`
+	BestOf    int       `json:"bestOf,omitempty"` // proposed with the offer
+}
+
+func (r *rematch) opponent(playerID string) string {
+	switch playerID {
+	case r.Players[0]:
+		return r.Players[1]
+	case r.Players[1]:
+		return r.Players[0]
+	}
+	return ""
+}
+
+// RematchResult is the outcome of asking for a rematch: either an offer the
+// opponent has yet to accept, or, once both asked, the room of the new game.
+type RematchResult struct {
+	Opponent string
+	RoomID   string // set when the rematch started
+	Series   *Series
+	BestOf   int // length of the series offered
+}
+
+// saveRematch keeps a finished game around for rematches. Bots do not play
+// rematches.
+func (s *Service) saveRematch(roomID string, players [2]string, gameState *GameState) {
+	for _, p := range players {
+		if _, isBot := bot.Difficulty(p); isBot {
+			return
+		}
+	}
+	// The other player starts the next game
+	first := players[0]
+	if gameState.FirstTurn == players[0] {
+		first = players[1]
+	}
+	r := rematch{
+		Players:   players,
+		Rules:     gameState.Rules,
+		Unranked:  gameState.Unranked,
+		Private:   gameState.Private,
+		Series:    gameState.Series,
+		FirstTurn: first,
+	}
+	rematchJSON, err := json.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to marshal rematch of room %s: %v", roomID, err)
+		return
+	}
+	if err := s.store.SaveRematch(s.ctx, roomID, rematchJSON, rematchWindow); err != nil {
+		log.Printf("Failed to store rematch of room %s: %v", roomID, err)
+	}
+}
+
+// Rematch asks for a rematch of the finished game in roomID. The first player
+// to ask makes an offer, optionally to play the series to bestOf games; when
+// the opponent asks too the new game's room is created and both players are
+// sent match_found.
+func (s *Service) Rematch(roomID, playerID string, bestOf int) (*RematchResult, error) {
+	if bestOf < 0 || bestOf > maxBestOf || bestOf%2 == 0 && bestOf != 0 {
+		return nil, fmt.Errorf("best of must be an odd number up to %d", maxBestOf)
+	}
+	var r rematch
+	started := false
+	err := s.store.UpdateRematch(s.ctx, roomID, rematchWindow, func(rematchJSON []byte) ([]byte, error) {
+		r = rematch{}
+		if err := json.Unmarshal(rematchJSON, &r); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rematch: %v", err)
+		}
+		opponent := r.opponent(playerID)
+		if opponent == "" {
+			return nil, engine.ErrUnknownPlayer
+		}
+		if r.OfferedBy == opponent {
+			started = true
+			return nil, nil
+		}
+		r.OfferedBy = playerID
+		if bestOf > 0 {
+			r.BestOf = bestOf
+		}
+		return json.Marshal(r)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNoRematch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	opponent := r.opponent(playerID)
+	series := r.Series
+	if series.Over() {
+		// Play again from scratch
+		series = nil
+	}
+	if series == nil {
+		series = &Series{Wins: make(map[string]int)}
+	}
+	if series.BestOf == 0 {
+		series.BestOf = r.BestOf
+	}
+	if !started {
+		log.Printf("Player %s offered a rematch of room %s", playerID, roomID)
+		s.notifyRematch("rematch_offered", opponent, playerID, roomID, series, r.BestOf)
+		return &RematchResult{Opponent: opponent, Series: series, BestOf: r.BestOf}, nil
+	}
+
+	newRoomID, err := NewRoomID()
+	if err != nil {
+		return nil, err
+	}
+	if series.ID == "" {
+		series.ID = newRoomID
+	}
+	players := r.Players[:]
+	if err := s.store.CreateRoom(s.ctx, newRoomID, players, 1*time.Hour); err != nil {
+		return nil, fmt.Errorf("failed to store room mapping: %v", err)
+	}
+	state := GameState{
+		RoomID:    newRoomID,
+		Rules:     r.Rules,
+		Unranked:  r.Unranked,
+		Private:   r.Private,
+		Series:    series,
+		FirstTurn: r.FirstTurn,
+	}
+	err = s.store.UpdateRoom(s.ctx, newRoomID, func(tx store.RoomTx) error {
+		return saveGameState(tx, &state)
+	})
+	if err != nil {
+		s.store.DeleteRoom(s.ctx, newRoomID)
+		return nil, err
+	}
+
+	log.Printf("Rematch of room %s started in room %s, game %d of series %s", roomID, newRoomID, series.Games+1, series.ID)
+	for _, p := range players {
+		s.notifyRematchStart(p, r.opponent(p), newRoomID, state)
+	}
+	return &RematchResult{Opponent: opponent, RoomID: newRoomID, Series: series, BestOf: series.BestOf}, nil
+}
+
+// DeclineRematch turns down a rematch of the game in roomID, offered or not.
+func (s *Service) DeclineRematch(roomID, playerID string) error {
+	var opponent string
+	err := s.store.UpdateRematch(s.ctx, roomID, rematchWindow, func(rematchJSON []byte) ([]byte, error) {
+		var r rematch
+		if err := json.Unmarshal(rematchJSON, &r); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rematch: %v", err)
+		}
+		opponent = r.opponent(playerID)
+		if opponent == "" {
+			return nil, engine.ErrUnknownPlayer
+		}
+		return nil, nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNoRematch
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Player %s declined a rematch of room %s", playerID, roomID)
+	s.notifyRematch("rematch_declined", opponent, playerID, roomID, nil, 0)
+	return nil
+}
+
+func (s *Service) notifyRematch(kind, playerID, from, roomID string, series *Series, bestOf int) {
+	s.notify(playerID, RematchNotice{
+		Type:   kind,
+		Player: playerID,
+		From:   from,
+		RoomID: roomID,
+		Series: series,
+		BestOf: bestOf,
+	})
+}
+
+// notifyRematchStart sends match_found for the new room, as matchmaking does.
+func (s *Service) notifyRematchStart(playerID, opponent, roomID string, state GameState) {
+	s.notify(playerID, MatchFound{
+		Type:      "match_found",
+		RoomID:    roomID,
+		Player:    playerID,
+		Opponent:  opponent,
+		Mode:      state.Rules.Mode,
+		Rules:     state.Rules,
+		Unranked:  state.Unranked,
+		Series:    state.Series,
+		FirstTurn: state.FirstTurn,
+	})
+}
+
+// notify sends a notification to a player's general connection.
+func (s *Service) notify(playerID string, notification interface{}) {
+	if err := s.notifier.Send(playerID, notification); err != nil {
+		log.Printf("Failed to send notification to %s: %v", playerID, err)
+	}
+}
+
+// NewRoomID returns a fresh random room ID. It is used for every room,
+// whether made by matchmaking, a lobby or a rematch.
+func NewRoomID() (string, error) {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate room ID: %v", err)
+	}
+	return hex.EncodeToString(id), nil
+}
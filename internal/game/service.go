@@ -1,388 +1,909 @@
 package game
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"math/rand"
+	"sort"
 	"time"
 
-	rdbPkg "github.com/krishanu7/battleship-backend/pkg/redis"
-	"github.com/redis/go-redis/v9"
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/history"
+	"github.com/krishanu7/battleship-backend/internal/notify"
+	"github.com/krishanu7/battleship-backend/internal/replay"
+	"github.com/krishanu7/battleship-backend/internal/store"
 )
 
+var ErrNoDrawOffer = errors.New("no draw offer from opponent")
+
 type Service struct {
-	Rdb *redis.Client
-	db *sql.DB
+	store       store.Store
+	db          *sql.DB
+	history     *history.Service
+	timeControl TimeControl
+	notifier    *notify.Service
+	ctx         context.Context
 }
 
 type GameOver struct {
-	Winner string `json:"winner"`
-	Loser  string `json:"loser"`
+	Winner string  `json:"winner"`
+	Loser  string  `json:"loser"`
+	Reason string  `json:"reason,omitempty"` // set unless a fleet was sunk
+	Draw   bool    `json:"draw,omitempty"`   // no winner or loser
+	Series *Series `json:"series,omitempty"` // score including this game
+	// Both fleets, revealed now that the game is over
+	Fleets map[string][]Ship `json:"fleets,omitempty"`
+}
+
+// TimeoutResult describes what the server did for a player whose turn
+// expired. Attack, or Salvo in salvo games, and SunkShips are set when it
+// fired random shots.
+type TimeoutResult struct {
+	Player    string
+	Action    string
+	Attack    *Attack
+	Salvo     []Attack
+	SunkShips []string
+	GameOver  *GameOver
+	State     *GameState // state after the timeout, nil once the game is over
 }
 
-func NewService(rdb *redis.Client, db *sql.DB) *Service {
+func NewService(st store.Store, db *sql.DB, hist *history.Service, tc TimeControl) *Service {
 	return &Service{
-		Rdb: rdb,
-		db: db,
+		store:       st,
+		db:          db,
+		history:     hist,
+		timeControl: tc,
+		notifier:    notify.NewService(st),
+		ctx:         context.Background(),
 	}
 }
 
-// Initialize game state after both players placed ships
-func (s *Service) InitializeGame(roomId string) error {
-	players, err := s.Rdb.SMembers(rdbPkg.Ctx, "room:"+roomId).Result()
-
+// loadGame rebuilds the engine state of a room inside a store transaction:
+// the two players, their boards, the shots each has fired and the current turn.
+func loadGame(tx store.RoomTx, roomID string) (engine.Game, *GameState, error) {
+	players, err := tx.Players()
 	if err != nil {
-		return fmt.Errorf("failed to retrive room members: %v", err)
+		return engine.Game{}, nil, fmt.Errorf("failed to get room members: %v", err)
 	}
 	if len(players) != 2 {
-		return fmt.Errorf("room %s has %d players, but expected 2", roomId, len(players))
+		return engine.Game{}, nil, fmt.Errorf("room %s has %d players, but expected 2", roomID, len(players))
 	}
-	// Randomly choose the first turn
-	source := rand.NewSource(time.Now().UnixNano())
-	rng := rand.New(source)
-	turn := players[rng.Intn(2)]
+	sort.Strings(players)
+	order := [2]string{players[0], players[1]}
 
-	gameState := GameState{
-		RoomID:    roomId,
-		Turn:      turn,
-		StartedAt: time.Now().Unix(),
+	var boards [2]*engine.Board
+	var shots [2][]string
+	for i, player := range order {
+		boardJSON, err := tx.Board(player)
+		if err == nil {
+			var board Board
+			if err := json.Unmarshal(boardJSON, &board); err != nil {
+				return engine.Game{}, nil, fmt.Errorf("failed to unmarshal board of %s: %v", player, err)
+			}
+			boards[i] = board.engineBoard()
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return engine.Game{}, nil, fmt.Errorf("failed to get board of %s: %v", player, err)
+		}
+
+		shots[i], err = tx.Attacks(player)
+		if err != nil {
+			return engine.Game{}, nil, fmt.Errorf("failed to get attacks: %v", err)
+		}
 	}
-	gameJSON, err := json.Marshal(gameState)
 
+	gameState := &GameState{RoomID: roomID}
+	gameJSON, err := tx.GameState()
+	if err == nil {
+		if err := json.Unmarshal(gameJSON, gameState); err != nil {
+			return engine.Game{}, nil, fmt.Errorf("failed to unmarshal game state: %v", err)
+		}
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return engine.Game{}, nil, fmt.Errorf("failed to get game state: %v", err)
+	}
+
+	g, err := engine.Restore(engine.Saved{
+		Rules:   gameState.Rules.WithDefaults(),
+		Players: order,
+		Boards:  boards,
+		Shots:   shots,
+		Turn:    gameState.Turn,
+		Winner:  gameState.Winner,
+		Drawn:   gameState.Drawn,
+	})
+	if err != nil {
+		return engine.Game{}, nil, err
+	}
+	return g, gameState, nil
+}
+
+// saveGameState writes the game state as part of tx. Every move rewrites it,
+// which is what makes concurrent moves on the same room conflict.
+func saveGameState(tx store.RoomTx, gameState *GameState) error {
+	gameJSON, err := json.Marshal(gameState)
 	if err != nil {
 		return fmt.Errorf("failed to marshal game state: %v", err)
 	}
-	if err := s.Rdb.Set(rdbPkg.Ctx, "room:"+roomId+":game", gameJSON, 24*time.Hour).Err(); err != nil {
-		return fmt.Errorf("failed to store game state: %v", err)
+	tx.SaveGameState(gameJSON, 24*time.Hour)
+	return nil
+}
+
+// appendEvents adds events to the room's ordered event log as part of tx.
+func appendEvents(tx store.RoomTx, events ...replay.Event) error {
+	for _, e := range events {
+		record, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s event: %v", e.Type, err)
+		}
+		tx.AppendEvent(record, 24*time.Hour)
+	}
+	return nil
+}
+
+// State returns the game state of a room, or nil if the room has none. A
+// room gets its state, holding only the mode, when it is created.
+func (s *Service) State(roomID string) (*GameState, error) {
+	gameJSON, err := s.store.GameState(s.ctx, roomID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get game state: %v", err)
+	}
+	var gameState GameState
+	if err := json.Unmarshal(gameJSON, &gameState); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal game state: %v", err)
+	}
+	return &gameState, nil
+}
+
+// IsPlayer reports whether playerID is one of the two players of the room.
+func (s *Service) IsPlayer(roomID, playerID string) (bool, error) {
+	return s.store.IsRoomMember(s.ctx, roomID, playerID)
+}
+
+// Snapshot returns the state of the room as seen by playerID. It is read in
+// a transaction so the board, shots and turn are consistent with each other.
+func (s *Service) Snapshot(roomID, playerID string) (*Snapshot, error) {
+	var snap *Snapshot
+	err := s.store.UpdateRoom(s.ctx, roomID, func(tx store.RoomTx) error {
+		g, gameState, err := loadGame(tx, roomID)
+		if err != nil {
+			return err
+		}
+		opponentID := g.Opponent(playerID)
+		if opponentID == "" {
+			return fmt.Errorf("player %s not in room %s", playerID, roomID)
+		}
+		snap = &Snapshot{
+			RoomID:        roomID,
+			Rules:         g.Rules,
+			PlayerID:      playerID,
+			OpponentID:    opponentID,
+			Started:       g.Started(),
+			Turn:          g.Turn,
+			SalvoShots:    gameState.SalvoShots,
+			OpponentReady: g.Board(opponentID) != nil,
+			Shots:         append([]engine.Shot{}, g.ShotsBy(playerID)...),
+			OpponentShots: append([]engine.Shot{}, g.ShotsBy(opponentID)...),
+			SunkShips:     append([]ShipType{}, g.SunkShips(opponentID)...),
+			Sinks:         append([]engine.Sink{}, g.Sinks(playerID)...),
+			LostShips:     append([]ShipType{}, g.SunkShips(playerID)...),
+			TimeControl:   gameState.TimeControl,
+			Deadline:      gameState.Deadline,
+			Banks:         gameState.Banks,
+			Unranked:      gameState.Unranked,
+		}
+		if boardJSON, err := tx.Board(playerID); err == nil {
+			snap.Board = &Board{}
+			if err := json.Unmarshal(boardJSON, snap.Board); err != nil {
+				return fmt.Errorf("failed to unmarshal board: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// Initialize game state after both players placed ships
+func (s *Service) InitializeGame(roomId string) error {
+	// Randomly choose the first turn unless a rematch already decided it
+	source := rand.NewSource(time.Now().UnixNano())
+	rng := rand.New(source)
+	pick := rng.Intn(2)
+
+	var turn string
+	err := s.store.UpdateRoom(s.ctx, roomId, func(tx store.RoomTx) error {
+		g, gameState, err := loadGame(tx, roomId)
+		if err != nil {
+			return err
+		}
+		first := g.Players[pick]
+		if g.Opponent(gameState.FirstTurn) != "" {
+			first = gameState.FirstTurn
+		}
+		g, _, err = g.Start(first)
+		if err != nil {
+			return fmt.Errorf("failed to start game in room %s: %v", roomId, err)
+		}
+		now := time.Now()
+		turn = g.Turn
+		gameState.Turn = g.Turn
+		gameState.FirstTurn = g.Turn
+		gameState.StartedAt = now.Unix()
+		gameState.Rules = g.Rules
+		gameState.SalvoShots = salvoShots(g)
+		gameState.startClocks(s.timeControl, g.Players, now)
+		err = appendEvents(tx, replay.Event{Type: replay.EventGameStart, At: time.Now().UnixMilli(), Player: turn})
+		if err != nil {
+			return err
+		}
+		return saveGameState(tx, gameState)
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.store.Push(s.ctx, liveGames, roomId); err != nil {
+		log.Printf("Failed to list live game %s: %v", roomId, err)
 	}
 	log.Printf("Initialized game for room %s with first turn: %s", roomId, turn)
 	return nil
 }
 
-// handles a player's attack and returns the result
-func (s *Service) ProcessAttack(roomID, playerID, coordinate string) (*Attack, []string, *GameOver, error) {
+// handles a player's attack and returns the result and the state after it,
+// nil once the game is over. The turn check, the duplicate-shot check and
+// the writes happen in one store transaction, so two concurrent attacks on
+// a room can never both be accepted.
+func (s *Service) ProcessAttack(roomID, playerID, coordinate string) (*Attack, []string, *GameOver, *GameState, error) {
 	// check if the room exists and have players
-	isMember, err := s.Rdb.SIsMember(rdbPkg.Ctx, "room:"+roomID, playerID).Result()
+	isMember, err := s.store.IsRoomMember(s.ctx, roomID, playerID)
 	if err != nil || !isMember {
-		return nil, nil, nil, fmt.Errorf("player %s not in room %s", playerID, roomID)
+		return nil, nil, nil, nil, fmt.Errorf("player %s not in room %s", playerID, roomID)
 	}
-	// check if valid coordinate
-	_, _, err = ParseCoordinate(coordinate)
+
+	var shot engine.Shot
+	next, events, gameState, err := s.applyMove(roomID, func(g engine.Game, _ *GameState) (engine.Game, []engine.Event, error) {
+		next, events, err := g.Fire(playerID, coordinate)
+		if err != nil {
+			return g, nil, err
+		}
+		shots := next.ShotsBy(playerID)
+		shot = shots[len(shots)-1]
+		return next, events, nil
+	})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("invalid coordinate: %v", err)
+		return nil, nil, nil, nil, err
 	}
-	// Check if it's the player's turn
-	gameJSON, err := s.Rdb.Get(rdbPkg.Ctx, "room:"+roomID+":game").Result()
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to get game state: %v", err)
+
+	log.Printf("Player %s attacked %s in room %s: %s, next turn: %s", playerID, shot.Coordinate, roomID, shot.Result, next.Turn)
+	sunkShips, gameOver := summarize(events, gameState)
+	if gameOver != nil {
+		gameState = nil
 	}
-	var gameState GameState
-	if err := json.Unmarshal([]byte(gameJSON), &gameState); err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to unmarshal game state: %v", err)
+	return &Attack{Coordinate: shot.Coordinate, Result: string(shot.Result)}, sunkShips, gameOver, gameState, nil
+}
+
+// ProcessSalvo handles a salvo game turn: every coordinate is fired at once
+// and the results are returned in the order given, with the state after the
+// turn as for ProcessAttack.
+func (s *Service) ProcessSalvo(roomID, playerID string, coordinates []string) ([]Attack, []string, *GameOver, *GameState, error) {
+	isMember, err := s.store.IsRoomMember(s.ctx, roomID, playerID)
+	if err != nil || !isMember {
+		return nil, nil, nil, nil, fmt.Errorf("player %s not in room %s", playerID, roomID)
 	}
-	if gameState.Turn != playerID {
-		return nil, nil, nil, fmt.Errorf("not your turn")
+
+	next, events, gameState, err := s.applyMove(roomID, func(g engine.Game, _ *GameState) (engine.Game, []engine.Event, error) {
+		return g.FireSalvo(playerID, coordinates)
+	})
+	if err != nil {
+		return nil, nil, nil, nil, err
 	}
 
-	//Check if coordinate was already attacked
-	attackKey := fmt.Sprintf("room:%s:attacks:%s", roomID, playerID)
-	alreadyAttacked, err := s.Rdb.SIsMember(rdbPkg.Ctx, attackKey, coordinate).Result()
+	attacks := salvoAttacks(events)
+	log.Printf("Player %s fired a salvo of %d shots in room %s, next turn: %s", playerID, len(attacks), roomID, next.Turn)
+	sunkShips, gameOver := summarize(events, gameState)
+	if gameOver != nil {
+		gameState = nil
+	}
+	return attacks, sunkShips, gameOver, gameState, nil
+}
 
+// HandleTimeout applies the time control of a room whose turn ran out. The
+// turn is identified by its player and deadline; if the player has moved
+// since, or the deadline was extended, nothing happens and nil is returned.
+func (s *Service) HandleTimeout(roomID, playerID string, deadline int64) (*TimeoutResult, error) {
+	result := &TimeoutResult{Player: playerID}
+	var shot *engine.Shot
+	stale := false
+	next, events, gameState, err := s.applyMove(roomID, func(g engine.Game, gameState *GameState) (engine.Game, []engine.Event, error) {
+		if g.Turn != playerID || g.Over() || gameState.TimeControl == nil ||
+			gameState.Deadline != deadline || time.Now().UnixMilli() < deadline {
+			stale = true
+			return g, nil, nil
+		}
+		result.Action = gameState.TimeControl.timeoutAction()
+		switch result.Action {
+		case TimeoutSkip:
+			return g.Skip(playerID)
+		case TimeoutForfeit:
+			return g.Forfeit(playerID, ReasonTimeout)
+		default:
+			cells := g.Unshot(playerID)
+			if len(cells) == 0 {
+				return g.Skip(playerID)
+			}
+			if g.Rules.Mode == engine.ModeSalvo {
+				rand.Shuffle(len(cells), func(i, j int) { cells[i], cells[j] = cells[j], cells[i] })
+				return g.FireSalvo(playerID, cells[:g.SalvoSize(playerID)])
+			}
+			next, events, err := g.Fire(playerID, cells[rand.Intn(len(cells))])
+			if err != nil {
+				return g, nil, err
+			}
+			shots := next.ShotsBy(playerID)
+			shot = &shots[len(shots)-1]
+			return next, events, nil
+		}
+	})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to check attacks: %v", err)
+		return nil, err
 	}
-	if alreadyAttacked {
-		return nil, nil, nil, fmt.Errorf("coordinate %s already attacked", coordinate)
+	if stale {
+		return nil, nil
 	}
-	// Get opponent's player ID
-	players, err := s.Rdb.SMembers(rdbPkg.Ctx, "room:"+roomID).Result()
+
+	log.Printf("Turn of player %s in room %s timed out (%s), next turn: %s", playerID, roomID, result.Action, next.Turn)
+	result.SunkShips, result.GameOver = summarize(events, gameState)
+	if shot != nil {
+		result.Attack = &Attack{Coordinate: shot.Coordinate, Result: string(shot.Result)}
+	}
+	result.Salvo = salvoAttacks(events)
+	if result.GameOver == nil {
+		result.State = gameState
+	}
+	return result, nil
+}
+
+// Resign ends the game with playerID as the loser.
+func (s *Service) Resign(roomID, playerID string) (*GameOver, error) {
+	return s.forfeit(roomID, playerID, ReasonResign)
+}
+
+// Abandon forfeits the game of a player who stayed disconnected past the
+// reconnect grace period.
+func (s *Service) Abandon(roomID, playerID string) (*GameOver, error) {
+	return s.forfeit(roomID, playerID, ReasonAbandoned)
+}
+
+// forfeit ends the game with playerID as the loser, during placement too.
+func (s *Service) forfeit(roomID, playerID, reason string) (*GameOver, error) {
+	_, events, gameState, err := s.applyMove(roomID, func(g engine.Game, _ *GameState) (engine.Game, []engine.Event, error) {
+		return g.Forfeit(playerID, reason)
+	})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to get room members: %v", err)
+		return nil, err
 	}
+	_, gameOver := summarize(events, gameState)
+	log.Printf("Player %s forfeited the game in room %s (%s)", playerID, roomID, reason)
+	return gameOver, nil
+}
 
-	var opponentID string
-	for _, p := range players {
-		if p != playerID {
-			opponentID = p
-			break
+// OfferDraw records a draw offer by playerID. If the opponent has already
+// offered a draw, the game ends drawn and the game over is returned instead.
+func (s *Service) OfferDraw(roomID, playerID string) (*GameOver, error) {
+	crossed := false
+	err := s.store.UpdateRoom(s.ctx, roomID, func(tx store.RoomTx) error {
+		crossed = false
+		g, gameState, err := loadGame(tx, roomID)
+		if err != nil {
+			return err
 		}
-	}
-	// Load opponet's board
-	boardKey := fmt.Sprintf("room:%s:board:%s", roomID, opponentID)
-	boardJSON, err := s.Rdb.Get(rdbPkg.Ctx, boardKey).Result()
+		opponent := g.Opponent(playerID)
+		switch {
+		case opponent == "":
+			return engine.ErrUnknownPlayer
+		case g.Over():
+			return engine.ErrGameOver
+		case !g.Started():
+			return engine.ErrNotStarted
+		case gameState.DrawOfferedBy == opponent:
+			// Both sides offered, handled as an accept below
+			crossed = true
+			return nil
+		}
+		gameState.DrawOfferedBy = playerID
+		return saveGameState(tx, gameState)
+	})
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to get opponent board: %v", err)
+		return nil, err
 	}
-	var opponentBoard Board
-	if err := json.Unmarshal([]byte(boardJSON), &opponentBoard); err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to unmarshal opponent board: %v", err)
+	if crossed {
+		return s.AcceptDraw(roomID, playerID)
 	}
+	log.Printf("Player %s offered a draw in room %s", playerID, roomID)
+	return nil, nil
+}
 
-	// Check for hit or miss
-	result := "miss"
-	var nextTurn string
-	if _, exists := opponentBoard.Grid[coordinate]; exists {
-		result = "hit"
-		nextTurn = playerID // Keep turn on hit
-	} else {
-		nextTurn = opponentID // Switch turn on miss
+// AcceptDraw ends the game drawn if the opponent of playerID has a pending
+// draw offer.
+func (s *Service) AcceptDraw(roomID, playerID string) (*GameOver, error) {
+	_, events, gameState, err := s.applyMove(roomID, func(g engine.Game, gameState *GameState) (engine.Game, []engine.Event, error) {
+		opponent := g.Opponent(playerID)
+		if opponent == "" {
+			return g, nil, engine.ErrUnknownPlayer
+		}
+		if g.Over() {
+			return g, nil, engine.ErrGameOver
+		}
+		if gameState.DrawOfferedBy != opponent {
+			return g, nil, ErrNoDrawOffer
+		}
+		return g.Draw()
+	})
+	if err != nil {
+		return nil, err
 	}
+	_, gameOver := summarize(events, gameState)
+	log.Printf("Game in room %s drawn by agreement", roomID)
+	return gameOver, nil
+}
 
-	// Record the attack
-	if err := s.Rdb.SAdd(rdbPkg.Ctx, attackKey, coordinate).Err(); err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to record attack: %v", err)
+// DeclineDraw withdraws the pending draw offer of the opponent of playerID
+// and returns the opponent.
+func (s *Service) DeclineDraw(roomID, playerID string) (string, error) {
+	var opponent string
+	err := s.store.UpdateRoom(s.ctx, roomID, func(tx store.RoomTx) error {
+		g, gameState, err := loadGame(tx, roomID)
+		if err != nil {
+			return err
+		}
+		opponent = g.Opponent(playerID)
+		if opponent == "" {
+			return engine.ErrUnknownPlayer
+		}
+		if g.Over() {
+			return engine.ErrGameOver
+		}
+		if gameState.DrawOfferedBy != opponent {
+			return ErrNoDrawOffer
+		}
+		gameState.DrawOfferedBy = ""
+		return saveGameState(tx, gameState)
+	})
+	if err != nil {
+		return "", err
 	}
+	return opponent, nil
+}
 
-	log.Printf("Player %s attacked %s in room %s: %s, next turn: %s", playerID, coordinate, roomID, result, nextTurn)
+// move changes a running game. It may also return the game unchanged with no
+// events, in which case nothing is written.
+type move func(g engine.Game, gameState *GameState) (engine.Game, []engine.Event, error)
 
-	// Check for sunk ships
-	sunkShips := []string{}
-	if result == "hit" {
-		attacks, err := s.Rdb.SMembers(rdbPkg.Ctx, attackKey).Result()
+// applyMove runs m in a store transaction and records its shots, events, the
+// next turn and the turn clock together. A move that ends the game is saved to
+// match history and the room is cleared.
+func (s *Service) applyMove(roomID string, m move) (engine.Game, []engine.Event, *GameState, error) {
+	var (
+		next      engine.Game
+		events    []engine.Event
+		gameState *GameState
+		finished  *history.Match
+	)
+	err := s.store.UpdateRoom(s.ctx, roomID, func(tx store.RoomTx) error {
+		g, state, err := loadGame(tx, roomID)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to get attacks: %v", err)
-		}
-		for _, ship := range opponentBoard.Ships {
-			isSunk := true
-			for _, cell := range ship.Cells {
-				found := false
-				for _, attack := range attacks {
-					if attack == cell {
-						found = true
-						break
-					}
-				}
-				if !found {
-					isSunk = false
-					break
+			return err
+		}
+		gameState = state
+		next, events, err = m(g, gameState)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+
+		// Record the shots, their events and the next turn together
+		now := time.Now()
+		logged := replay.FromEngine(events, now.UnixMilli())
+		for _, event := range events {
+			switch e := event.(type) {
+			case engine.Hit:
+				tx.AddAttack(e.Player, e.Coordinate, 24*time.Hour)
+			case engine.Miss:
+				tx.AddAttack(e.Player, e.Coordinate, 24*time.Hour)
+			case engine.Salvo:
+				for _, shot := range e.Shots {
+					tx.AddAttack(e.Player, shot.Coordinate, 24*time.Hour)
 				}
 			}
-			if isSunk {
-				sunkShips = append(sunkShips, string(ship.Type))
+		}
+		if err := appendEvents(tx, logged...); err != nil {
+			return err
+		}
+		gameState.DrawOfferedBy = ""
+		gameState.chargeBank(g.Turn, now)
+		gameState.Turn = next.Turn
+		gameState.startTurn(now)
+		gameState.SalvoShots = salvoShots(next)
+		if next.Over() {
+			gameState.Winner = next.Winner
+			gameState.Drawn = next.Drawn
+			gameState.Deadline = 0
+			gameState.Series = gameState.Series.record(roomID, next)
+			gameState.fleets = fleetsOf(next)
+			// Capture everything history needs before the room is cleared
+			finished, err = buildMatch(tx, roomID, next, gameState, logged)
+			if err != nil {
+				return err
 			}
 		}
+		return saveGameState(tx, gameState)
+	})
+	if err != nil {
+		return engine.Game{}, nil, nil, err
 	}
 
-	// Check for victory (17 hits)
-	var gameOver *GameOver
-	if result == "hit" {
-		attacks, err := s.Rdb.SMembers(rdbPkg.Ctx, attackKey).Result()
-		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to get attacks: %v", err)
-		}
-		hitCount := 0
-		for _, attack := range attacks {
-			if _, exists := opponentBoard.Grid[attack]; exists {
-				hitCount++
+	if next.Over() && len(events) > 0 {
+		s.finishGame(roomID, finished, gameState)
+	}
+	return next, events, gameState, nil
+}
+
+// salvoShots returns the size of the next salvo, or 0 outside salvo games.
+func salvoShots(g engine.Game) int {
+	if g.Rules.Mode != engine.ModeSalvo || g.Over() {
+		return 0
+	}
+	return g.SalvoSize(g.Turn)
+}
+
+// salvoAttacks returns the results of the salvo among events, if any.
+func salvoAttacks(events []engine.Event) []Attack {
+	for _, event := range events {
+		if salvo, ok := event.(engine.Salvo); ok {
+			attacks := make([]Attack, len(salvo.Shots))
+			for i, shot := range salvo.Shots {
+				attacks[i] = Attack{Coordinate: shot.Coordinate, Result: string(shot.Result)}
 			}
+			return attacks
 		}
-		if hitCount >= 17 {
+	}
+	return nil
+}
+
+// summarize extracts the sunk ships and the game over of a move's events.
+// The game over carries the series score from the state after the move.
+func summarize(events []engine.Event, gameState *GameState) ([]string, *GameOver) {
+	sunkShips := []string{}
+	var gameOver *GameOver
+	reason := ""
+	for _, event := range events {
+		switch e := event.(type) {
+		case engine.Sunk:
+			sunkShips = append(sunkShips, string(e.Ship))
+		case engine.Forfeited:
+			reason = e.Reason
+		case engine.Won:
 			gameOver = &GameOver{
-				Winner: playerID,
-				Loser:  opponentID,
-			}
-			// Update stats
-			if err := s.updatePlayerStats(playerID, opponentID); err != nil {
-				log.Printf("Failed to update player stats: %v", err)
-			}
-			// Clean up Redis
-			keys, err := s.Rdb.Keys(rdbPkg.Ctx, "room:"+roomID+":*").Result()
-			if err != nil {
-				log.Printf("Failed to get room keys: %v", err)
-			} else {
-				for _, key := range keys {
-					s.Rdb.Del(rdbPkg.Ctx, key)
-				}
-				log.Printf("Cleared Redis keys for room %s", roomID)
+				Winner: e.Winner,
+				Loser:  e.Loser,
+				Reason: reason,
 			}
+		case engine.Drawn:
+			gameOver = &GameOver{Reason: ReasonDraw, Draw: true}
 		}
 	}
+	if gameOver != nil && gameState != nil {
+		gameOver.Series = gameState.Series
+		gameOver.Fleets = gameState.fleets
+	}
+	return sunkShips, gameOver
+}
 
-	// Update turn if no game over
-	if gameOver == nil {
-		gameState.Turn = nextTurn
-		updatedGameJSON, err := json.Marshal(gameState)
+// finishGame updates the stats of both players, records the match and clears
+// the room of a game that just ended, keeping what a rematch needs.
+func (s *Service) finishGame(roomID string, finished *history.Match, gameState *GameState) {
+	// Update stats
+	first, second, draw := finished.Winner, finished.Loser, finished.Winner == ""
+	if draw {
+		first, second = finished.Player1, finished.Player2
+	}
+	var firstDelta, secondDelta int
+	if finished.Ranked {
+		var err error
+		firstDelta, secondDelta, err = s.updatePlayerStats(first, second, draw)
 		if err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to marshal updated game state: %v", err)
-		}
-		if err := s.Rdb.Set(rdbPkg.Ctx, "room:"+roomID+":game", updatedGameJSON, 24*time.Hour).Err(); err != nil {
-			return nil, nil, nil, fmt.Errorf("failed to update game state: %v", err)
+			log.Printf("Failed to update player stats: %v", err)
 		}
+	} else {
+		log.Printf("Room %s played unranked, stats left unchanged", roomID)
+	}
+	s.recordMatch(finished, map[string]int{first: firstDelta, second: secondDelta})
+	s.saveRematch(roomID, [2]string{finished.Player1, finished.Player2}, gameState)
+	s.store.Remove(s.ctx, liveGames, roomID)
+	// Clean up room state
+	if err := s.store.DeleteRoom(s.ctx, roomID); err != nil {
+		log.Printf("Failed to clear room %s: %v", roomID, err)
+	} else {
+		log.Printf("Cleared state for room %s", roomID)
+	}
+}
+
+// CheckPlacement validates a fleet against the rules of a room without
+// storing it. A rejected fleet's error is an engine.PlacementErrors.
+func (s *Service) CheckPlacement(roomID, playerID string, ships []Ship) error {
+	rules, err := s.roomRules(roomID, playerID)
+	if err != nil {
+		return err
+	}
+	if errs := engine.ValidatePlacement(rules, ships); errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// RandomFleet generates a random valid placement for the rules of a room.
+// The same seed always gives the same placement for the same rules.
+func (s *Service) RandomFleet(roomID, playerID string, seed int64) ([]Ship, error) {
+	rules, err := s.roomRules(roomID, playerID)
+	if err != nil {
+		return nil, err
 	}
+	return engine.RandomFleet(rules, rand.New(rand.NewSource(seed)))
+}
 
-	return &Attack{Coordinate: coordinate, Result: result}, sunkShips, gameOver, nil
+// roomRules returns the rules of a room the player is in.
+func (s *Service) roomRules(roomID, playerID string) (Rules, error) {
+	isMember, err := s.store.IsRoomMember(s.ctx, roomID, playerID)
+	if err != nil || !isMember {
+		return Rules{}, fmt.Errorf("player %s not in room %s", playerID, roomID)
+	}
+	var rules Rules
+	state, err := s.State(roomID)
+	if err != nil {
+		return Rules{}, err
+	}
+	if state != nil {
+		rules = state.Rules
+	}
+	return rules.WithDefaults(), nil
 }
 
 // validate and store a player's ship placements
 func (s *Service) PlaceShips(roomID, playerID string, ships []Ship) (*Board, error) {
 	// Verify player is in room
-	isMember, err := s.Rdb.SIsMember(rdbPkg.Ctx, "room:"+roomID, playerID).Result()
+	isMember, err := s.store.IsRoomMember(s.ctx, roomID, playerID)
 	if err != nil || !isMember {
 		return nil, fmt.Errorf("player %s not in room %s", playerID, roomID)
 	}
 
-	// Validate ship count and types
-	if len(ships) != len(ShipConfig) {
-		return nil, fmt.Errorf("expected %d ships, got %d", len(ShipConfig), len(ships))
-	}
-	shipCounts := make(map[ShipType]int)
-	for _, ship := range ships {
-		expectedSize, exists := ShipConfig[ship.Type]
-		if !exists {
-			return nil, fmt.Errorf("invalid ship type: %s", ship.Type)
-		}
-		if expectedSize != ship.Size {
-			return nil, fmt.Errorf("invalid size for %s: expected %d, got %d", ship.Type, expectedSize, ship.Size)
-		}
-		shipCounts[ship.Type]++
-	}
-	for shipType, count := range shipCounts {
-		if count != 1 {
-			return nil, fmt.Errorf("exactly one %s required, got %d", shipType, count)
+	var board *Board
+	var ready bool
+	err = s.store.UpdateRoom(s.ctx, roomID, func(tx store.RoomTx) error {
+		g, _, err := loadGame(tx, roomID)
+		if err != nil {
+			return err
 		}
-	}
-
-	// Validate and compute ship cells
-	board := &Board{
-		PlayerID: playerID,
-		RoomID:   roomID,
-		Ships:    ships,
-		Grid:     make(map[string]string),
-	}
-	for i, ship := range ships {
-		row, col, err := ParseCoordinate(ship.Start)
+		g, _, err = g.Place(playerID, ships)
 		if err != nil {
-			return nil, fmt.Errorf("invalid start for %s: %v", ship.Type, err)
+			return err
 		}
-		var cells []string
-		if ship.Orientation == "horizontal" {
-			if col+ship.Size > 10 {
-				return nil, fmt.Errorf("%s out of bounds horizontally at %s", ship.Type, ship.Start)
-			}
-			for j := 0; j < ship.Size; j++ {
-				cells = append(cells, FormatCoordinate(row, col+j))
-			}
-		} else if ship.Orientation == "vertical" {
-			if row+ship.Size > 10 {
-				return nil, fmt.Errorf("%s out of bounds vertically at %s", ship.Type, ship.Start)
-			}
-			for j := 0; j < ship.Size; j++ {
-				cells = append(cells, FormatCoordinate(row+j, col))
-			}
-		} else {
-			return nil, fmt.Errorf("invalid orientation for %s: %s", ship.Type, ship.Orientation)
+		placed := g.Board(playerID)
+		board = &Board{
+			PlayerID: playerID,
+			RoomID:   roomID,
+			Ships:    placed.Ships,
+			Grid:     placed.Grid,
 		}
-
-		// Check for overlaps
-		for _, cell := range cells {
-			if _, exists := board.Grid[cell]; exists {
-				return nil, fmt.Errorf("overlap at %s for %s", cell, ship.Type)
-			}
-			board.Grid[cell] = string(ship.Type)
+		boardJSON, err := json.Marshal(board)
+		if err != nil {
+			return fmt.Errorf("failed to marshal board: %v", err)
 		}
-		ships[i].Cells = cells
-	}
-
-	boardJSON, err := json.Marshal(board)
+		tx.SaveBoard(playerID, boardJSON, 24*time.Hour)
+		ready = g.Ready()
+		return appendEvents(tx, replay.Event{
+			Type:   replay.EventPlacement,
+			At:     time.Now().UnixMilli(),
+			Player: playerID,
+			Ships:  placed.Ships,
+		})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal board: %v", err)
-	}
-	key := fmt.Sprintf("room:%s:board:%s", roomID, playerID)
-	if err := s.Rdb.Set(rdbPkg.Ctx, key, boardJSON, 24*time.Hour).Err(); err != nil {
-		return nil, fmt.Errorf("failed to store board: %v", err)
+		return nil, err
 	}
 	log.Printf("Stored board for player %s in room %s", playerID, roomID)
 
 	// Publish ships_placed notification
-	notification := struct {
-		Type   string `json:"type"`
-		RoomID string `json:"roomId"`
-		Player string `json:"player"`
-	}{
+	notification := ShipsPlaced{
 		Type:   "ships_placed",
 		RoomID: roomID,
 		Player: playerID,
 	}
-	notificationBytes, err := json.Marshal(notification)
-	if err != nil {
-		log.Printf("Failed to marshal ships_placed notification for %s: %v", playerID, err)
+	if err := s.notifier.Send(playerID, notification); err != nil {
+		log.Printf("Failed to send ships_placed notification for %s: %v", playerID, err)
 	} else {
-		if err := s.Rdb.Publish(rdbPkg.Ctx, "notifications", notificationBytes).Err(); err != nil {
-			log.Printf("Failed to publish ships_placed notification for %s: %v", playerID, err)
-		} else {
-			log.Printf("Published ships_placed notification for player %s in room %s", playerID, roomID)
-		}
+		log.Printf("Published ships_placed notification for player %s in room %s", playerID, roomID)
 	}
-	// Check if opponent has placed ships
-	players, err := s.Rdb.SMembers(rdbPkg.Ctx, "room:"+roomID).Result()
+	if ready {
+		log.Printf("Both players in room %s have placed ships", roomID)
+	}
+
+	return board, nil
+}
+
+// buildMatch assembles the history record of a game that just ended.
+// pending holds the events appended in the same transaction, which tx cannot
+// read back yet.
+func buildMatch(tx store.RoomTx, roomID string, g engine.Game, gameState *GameState, pending []replay.Event) (*history.Match, error) {
+	records, err := tx.EventLog()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get room members: %v", err)
+		return nil, fmt.Errorf("failed to read event log: %v", err)
 	}
-	opponentID := ""
-	for _, p := range players {
-		if p != playerID {
-			opponentID = p
-			break
+	events := make([]replay.Event, 0, len(records)+len(pending))
+	for _, r := range records {
+		var e replay.Event
+		if err := json.Unmarshal(r, &e); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal event: %v", err)
 		}
+		events = append(events, e)
 	}
-	if opponentID != "" {
-		opponentKey := fmt.Sprintf("room:%s:board:%s", roomID, opponentID)
-		exists, err := s.Rdb.Exists(rdbPkg.Ctx, opponentKey).Result()
-		if err != nil {
-			return nil, fmt.Errorf("failed to check opponent board: %v", err)
+	events = append(events, pending...)
+	replay.Number(events)
+
+	shots := []ShotRecord{}
+	for _, e := range events {
+		switch e.Type {
+		case replay.EventAttackResult:
+			shots = append(shots, ShotRecord{Player: e.Player, Coordinate: e.Coordinate, Result: e.Result, At: e.At})
+		case replay.EventSalvo:
+			for _, shot := range e.Shots {
+				shots = append(shots, ShotRecord{Player: e.Player, Coordinate: shot.Coordinate, Result: string(shot.Result), At: e.At})
+			}
+		}
+	}
+	shotsJSON, err := json.Marshal(shots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal shot log: %v", err)
+	}
+	eventsJSON, err := json.Marshal(events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event log: %v", err)
+	}
+
+	// A game forfeited during placement may lack a board or both
+	var boards [2][]byte
+	for i, player := range g.Players {
+		boards[i], err = tx.Board(player)
+		if errors.Is(err, store.ErrNotFound) {
+			boards[i] = []byte("null")
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read board of %s: %v", player, err)
 		}
-		if exists == 1 {
-			log.Printf("Both players in room %s have placed ships", roomID)
-			//[TODO] Game start notification handled by NotificationWorker
+	}
+	startedAt := time.Unix(gameState.StartedAt, 0)
+	if gameState.StartedAt == 0 {
+		startedAt = time.Now()
+	}
+
+	endReason := ""
+	for _, e := range events {
+		switch e.Type {
+		case replay.EventForfeit:
+			endReason = e.Reason
+		case replay.EventDraw:
+			endReason = ReasonDraw
 		}
 	}
 
-	return board, nil
+	rulesJSON, err := json.Marshal(g.Rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rules: %v", err)
+	}
+	return &history.Match{
+		RoomID:       roomID,
+		Player1:      g.Players[0],
+		Player2:      g.Players[1],
+		Winner:       g.Winner,
+		Loser:        g.Opponent(g.Winner),
+		EndReason:    endReason,
+		Mode:         g.Rules.Mode,
+		Rules:        rulesJSON,
+		Ranked:       !gameState.Unranked,
+		StartedAt:    startedAt,
+		EndedAt:      time.Now(),
+		Player1Board: boards[0],
+		Player2Board: boards[1],
+		Shots:        shotsJSON,
+		Events:       eventsJSON,
+	}, nil
 }
 
-func (s *Service) updatePlayerStats(winnerID, loserID string) error {
-	var winnerStats, loserStats PlayerStats
-	err := s.db.QueryRow("SELECT player_id, wins, losses, elo FROM stats WHERE player_id = $1", winnerID).
-		Scan(&winnerStats.PlayerID, &winnerStats.Wins, &winnerStats.Losses, &winnerStats.Elo)
-	if err == sql.ErrNoRows {
-		winnerStats = PlayerStats{PlayerID: winnerID, Wins: 0, Losses: 0, Elo: 1500}
-	} else if err != nil {
-		return fmt.Errorf("failed to get winner stats: %v", err)
+// recordMatch stores a finished game with the Elo change of each player.
+func (s *Service) recordMatch(m *history.Match, eloDeltas map[string]int) {
+	if m == nil || s.history == nil {
+		return
+	}
+	m.Player1EloDelta = eloDeltas[m.Player1]
+	m.Player2EloDelta = eloDeltas[m.Player2]
+	id, err := s.history.Record(m)
+	if err != nil {
+		log.Printf("Failed to record match for room %s: %v", m.RoomID, err)
+		return
 	}
-	err = s.db.QueryRow("SELECT player_id, wins, losses, elo FROM stats WHERE player_id = $1", loserID).
-		Scan(&loserStats.PlayerID, &loserStats.Wins, &loserStats.Losses, &loserStats.Elo)
-	if err == sql.ErrNoRows {
-		loserStats = PlayerStats{PlayerID: loserID, Wins: 0, Losses: 0, Elo: 1500}
-	} else if err != nil {
-		return fmt.Errorf("failed to get loser stats: %v", err)
+	log.Printf("Recorded match %d for room %s", id, m.RoomID)
+}
+
+// updatePlayerStats applies the result of a game and returns the Elo change
+// of winnerID and of loserID. With draw set the two players drew and neither
+// gets a win or a loss. Without a database no stats are kept.
+func (s *Service) updatePlayerStats(winnerID, loserID string, draw bool) (int, int, error) {
+	if s.db == nil {
+		return 0, 0, nil
+	}
+	winnerStats, err := s.playerStats(winnerID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get winner stats: %v", err)
+	}
+	loserStats, err := s.playerStats(loserID)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get loser stats: %v", err)
 	}
 
 	//  ELO update
 	const K = 32
+	score := 1.0
+	if draw {
+		score = 0.5
+	}
 	expectedWinner := 1 / (1 + math.Pow(10, float64(loserStats.Elo-winnerStats.Elo)/400))
 	expectedLoser := 1 / (1 + math.Pow(10, float64(winnerStats.Elo-loserStats.Elo)/400))
-	newWinnerElo := winnerStats.Elo + int(float64(K)*(1-expectedWinner))
-	newLoserElo := loserStats.Elo + int(float64(K)*(0-expectedLoser))
+	newWinnerElo := winnerStats.Elo + int(float64(K)*(score-expectedWinner))
+	newLoserElo := loserStats.Elo + int(float64(K)*(1-score-expectedLoser))
+
+	newWinner, newLoser := winnerStats, loserStats
+	if draw {
+		newWinner.Draws++
+		newLoser.Draws++
+	} else {
+		newWinner.Wins++
+		newLoser.Losses++
+	}
+	newWinner.Elo, newLoser.Elo = newWinnerElo, newLoserElo
 
 	// Update stats
-	_, err = s.db.Exec(
-		"INSERT INTO stats (player_id, wins, losses, elo) VALUES ($1, $2, $3, $4) ON CONFLICT (player_id) DO UPDATE SET wins = $2, losses = $3, elo = $4",
-		winnerID, winnerStats.Wins+1, winnerStats.Losses, newWinnerElo,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update winner stats: %v", err)
+	if err := s.savePlayerStats(newWinner); err != nil {
+		return 0, 0, fmt.Errorf("failed to update winner stats: %v", err)
 	}
-	_, err = s.db.Exec(
-		"INSERT INTO stats (player_id, wins, losses, elo) VALUES ($1, $2, $3, $4) ON CONFLICT (player_id) DO UPDATE SET wins = $2, losses = $3, elo = $4",
-		loserID, loserStats.Wins, loserStats.Losses+1, newLoserElo,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to update loser stats: %v", err)
+	if err := s.savePlayerStats(newLoser); err != nil {
+		return 0, 0, fmt.Errorf("failed to update loser stats: %v", err)
 	}
-	log.Printf("Updated stats: %s (wins=%d, elo=%d), %s (losses=%d, elo=%d)",
-		winnerID, winnerStats.Wins+1, newWinnerElo, loserID, loserStats.Losses+1, newLoserElo)
-	return nil
-}
\ No newline at end of file
+	log.Printf("Updated stats: %s (wins=%d, draws=%d, elo=%d), %s (losses=%d, draws=%d, elo=%d)",
+		winnerID, newWinner.Wins, newWinner.Draws, newWinnerElo, loserID, newLoser.Losses, newLoser.Draws, newLoserElo)
+	return newWinnerElo - winnerStats.Elo, newLoserElo - loserStats.Elo, nil
+}
+
+// playerStats returns the stats of a player, or fresh stats at 1500 Elo for
+// a player who has not finished a game yet.
+func (s *Service) playerStats(playerID string) (PlayerStats, error) {
+	var stats PlayerStats
+	err := s.db.QueryRow("SELECT player_id, wins, losses, draws, elo FROM stats WHERE player_id = $1", playerID).
+		Scan(&stats.PlayerID, &stats.Wins, &stats.Losses, &stats.Draws, &stats.Elo)
+	if err == sql.ErrNoRows {
+		return PlayerStats{PlayerID: playerID, Elo: 1500}, nil
+	}
+	return stats, err
+}
+
+func (s *Service) savePlayerStats(stats PlayerStats) error {
+	_, err := s.db.Exec(
+		"INSERT INTO stats (player_id, wins, losses, draws, elo) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (player_id) DO UPDATE SET wins = $2, losses = $3, draws = $4, elo = $5",
+		stats.PlayerID, stats.Wins, stats.Losses, stats.Draws, stats.Elo,
+	)
+	return err
+}
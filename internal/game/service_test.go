@@ -0,0 +1,323 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/replay"
+	"github.com/krishanu7/battleship-backend/internal/store"
+	"github.com/krishanu7/battleship-backend/internal/store/storetest"
+)
+
+// testFleet is a classic fleet, one ship every other row starting at A.
+func testFleet() []Ship {
+	return []Ship{
+		{Type: engine.Carrier, Size: 5, Start: "A1", Orientation: engine.Horizontal},
+		{Type: engine.Battleship, Size: 4, Start: "C1", Orientation: engine.Horizontal},
+		{Type: engine.Cruiser, Size: 3, Start: "E1", Orientation: engine.Horizontal},
+		{Type: engine.Submarine, Size: 3, Start: "G1", Orientation: engine.Horizontal},
+		{Type: engine.Destroyer, Size: 2, Start: "I1", Orientation: engine.Horizontal},
+	}
+}
+
+// startTestGame creates a room between p1 and p2 with both fleets placed
+// and the game started.
+func startTestGame(t *testing.T, s *Service, st store.Store, roomID string) {
+	t.Helper()
+	ctx := context.Background()
+	if err := st.CreateRoom(ctx, roomID, []string{"p1", "p2"}, time.Hour); err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	t.Cleanup(func() { st.DeleteRoom(ctx, roomID) })
+	stateJSON, _ := json.Marshal(GameState{RoomID: roomID})
+	if err := st.SaveGameState(ctx, roomID, stateJSON, time.Hour); err != nil {
+		t.Fatalf("SaveGameState: %v", err)
+	}
+	for _, p := range []string{"p1", "p2"} {
+		if _, err := s.PlaceShips(roomID, p, testFleet()); err != nil {
+			t.Fatalf("PlaceShips(%s): %v", p, err)
+		}
+	}
+	if err := s.InitializeGame(roomID); err != nil {
+		t.Fatalf("InitializeGame: %v", err)
+	}
+}
+
+// TestConcurrentAttacks hammers one room with attacks from both players at
+// once. Every accepted shot must be stored exactly once, and the accepted
+// shots must make a legal game: one player moving at a time and no cell
+// fired at twice.
+func TestConcurrentAttacks(t *testing.T) {
+	// Misses everywhere but the first four cells of the carrier, so the
+	// game never ends
+	var cells []string
+	for _, row := range "ABDFHJ" {
+		for col := 1; col <= 10; col++ {
+			if row == 'A' && col > 4 {
+				continue
+			}
+			cells = append(cells, fmt.Sprintf("%c%d", row, col))
+		}
+	}
+
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(st, nil, nil, TimeControl{})
+			roomID := fmt.Sprintf("test-concurrent-%d", time.Now().UnixNano())
+			startTestGame(t, s, st, roomID)
+			state, err := s.State(roomID)
+			if err != nil || state == nil {
+				t.Fatalf("State: %v, %v", state, err)
+			}
+			first := state.Turn
+
+			// Keep firing until enough shots went through that turns changed
+			// hands many times
+			const workers = 8 // per player
+			const target = 60
+			deadline := time.Now().Add(10 * time.Second)
+			var mu sync.Mutex
+			accepted := map[string]map[string]int{"p1": {}, "p2": {}}
+			misses := map[string]int{}
+			count := 0
+			var wg sync.WaitGroup
+			for w := 0; w < 2*workers; w++ {
+				player := []string{"p1", "p2"}[w%2]
+				rng := rand.New(rand.NewSource(int64(w)))
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for time.Now().Before(deadline) {
+						mu.Lock()
+						done := count >= target
+						mu.Unlock()
+						if done {
+							return
+						}
+						cell := cells[rng.Intn(len(cells))]
+						attack, _, gameOver, state, err := s.ProcessAttack(roomID, player, cell)
+						if err != nil {
+							continue
+						}
+						if gameOver != nil {
+							t.Errorf("game ended on %s by %s", cell, player)
+						}
+						if state == nil || (state.Turn != "p1" && state.Turn != "p2") {
+							t.Errorf("state after %s fired at %s = %+v", player, cell, state)
+						}
+						mu.Lock()
+						accepted[player][attack.Coordinate]++
+						if attack.Result == string(engine.ResultMiss) {
+							misses[player]++
+						}
+						count++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			total := 0
+			for player, shots := range accepted {
+				for cell, n := range shots {
+					if n != 1 {
+						t.Errorf("%s fired at %s %d times", player, cell, n)
+					}
+				}
+				total += len(shots)
+				stored, err := st.Attacks(context.Background(), roomID, player)
+				if err != nil {
+					t.Fatalf("Attacks(%s): %v", player, err)
+				}
+				if len(stored) != len(shots) {
+					t.Errorf("%s: %d shots stored, %d accepted", player, len(stored), len(shots))
+				}
+				for _, cell := range stored {
+					if shots[cell] == 0 {
+						t.Errorf("%s: stored shot at %s was never accepted", player, cell)
+					}
+				}
+			}
+			if total < target {
+				t.Fatalf("only %d shots accepted", total)
+			}
+			checkTurns(t, s, roomID, first, misses)
+			checkEventLog(t, s, st, roomID, total)
+		})
+	}
+}
+
+// checkTurns checks the misses of each player against the turn: only a
+// miss passes the turn, so the first player has missed as often as the
+// other, and is to move, or once more, and is waiting.
+func checkTurns(t *testing.T, s *Service, roomID, first string, misses map[string]int) {
+	t.Helper()
+	other := "p1"
+	if first == "p1" {
+		other = "p2"
+	}
+	state, err := s.State(roomID)
+	if err != nil || state == nil {
+		t.Fatalf("State: %v, %v", state, err)
+	}
+	turn := state.Turn
+	switch misses[first] - misses[other] {
+	case 0:
+		if turn != first {
+			t.Errorf("misses %v with %s first, but %s is to move", misses, first, turn)
+		}
+	case 1:
+		if turn != other {
+			t.Errorf("misses %v with %s first, but %s is to move", misses, first, turn)
+		}
+	default:
+		t.Errorf("misses %v with %s first: turns did not alternate", misses, first)
+	}
+}
+
+// checkEventLog replays the attacks of a room's event log against a fresh
+// game with the same fleets and first turn.
+func checkEventLog(t *testing.T, s *Service, st store.Store, roomID string, shots int) {
+	t.Helper()
+	var records [][]byte
+	err := st.UpdateRoom(context.Background(), roomID, func(tx store.RoomTx) error {
+		var err error
+		records, err = tx.EventLog()
+		return err
+	})
+	if err != nil {
+		t.Fatalf("EventLog: %v", err)
+	}
+
+	fired := map[string][]string{}
+	g := engine.New("p1", "p2")
+	for _, p := range []string{"p1", "p2"} {
+		g, _, _ = g.Place(p, testFleet())
+	}
+	attacks := 0
+	for _, record := range records {
+		var e replay.Event
+		if err := json.Unmarshal(record, &e); err != nil {
+			t.Fatalf("bad event %s: %v", record, err)
+		}
+		switch e.Type {
+		case replay.EventGameStart:
+			if g, _, err = g.Start(e.Player); err != nil {
+				t.Fatalf("start: %v", err)
+			}
+		case replay.EventAttackResult:
+			attacks++
+			fired[e.Player] = append(fired[e.Player], e.Coordinate)
+			if g, _, err = g.Fire(e.Player, e.Coordinate); err != nil {
+				t.Fatalf("logged attack %d by %s at %s is illegal: %v", attacks, e.Player, e.Coordinate, err)
+			}
+		}
+	}
+	if attacks != shots {
+		t.Errorf("event log has %d attacks, %d accepted", attacks, shots)
+	}
+	// Shots are stored in the order they were fired
+	for player, cells := range fired {
+		stored, err := st.Attacks(context.Background(), roomID, player)
+		if err != nil {
+			t.Fatalf("Attacks(%s): %v", player, err)
+		}
+		if !reflect.DeepEqual(stored, cells) {
+			t.Errorf("%s: stored shots %v, logged %v", player, stored, cells)
+		}
+	}
+	state, err := s.State(roomID)
+	if err != nil || state == nil {
+		t.Fatalf("State: %v, %v", state, err)
+	}
+	turn := state.Turn
+	if turn != g.Turn {
+		t.Errorf("stored turn %s, replayed turn %s", turn, g.Turn)
+	}
+}
+
+// lingeringStore never clears rooms, holding open the window between a
+// game ending and its room being deleted.
+type lingeringStore struct {
+	store.Store
+}
+
+func (lingeringStore) DeleteRoom(ctx context.Context, roomID string) error {
+	return nil
+}
+
+func TestFinishedGameRejectsMoves(t *testing.T) {
+	st := lingeringStore{store.NewMemory()}
+	s := NewService(st, nil, nil, TimeControl{})
+	roomID := "test-finished"
+	startTestGame(t, s, st, roomID)
+
+	gameOver, err := s.Resign(roomID, "p1")
+	if err != nil {
+		t.Fatalf("Resign: %v", err)
+	}
+	if gameOver.Winner != "p2" || gameOver.Reason != ReasonResign {
+		t.Fatalf("game over = %+v", gameOver)
+	}
+	state, err := s.State(roomID)
+	if err != nil || state == nil || state.Winner != "p2" || !state.Over() {
+		t.Fatalf("state after resign = %+v, %v", state, err)
+	}
+
+	moves := map[string]func() error{
+		"resign":  func() error { _, err := s.Resign(roomID, "p2"); return err },
+		"abandon": func() error { _, err := s.Abandon(roomID, "p1"); return err },
+		"attack": func() error {
+			_, _, _, _, err := s.ProcessAttack(roomID, state.Turn, "J10")
+			return err
+		},
+		"offer draw":  func() error { _, err := s.OfferDraw(roomID, "p2"); return err },
+		"accept draw": func() error { _, err := s.AcceptDraw(roomID, "p2"); return err },
+		"timeout": func() error {
+			result, err := s.HandleTimeout(roomID, state.Turn, state.Deadline)
+			if err == nil && result != nil {
+				return fmt.Errorf("timeout applied: %+v", result)
+			}
+			return engine.ErrGameOver
+		},
+	}
+	for name, move := range moves {
+		if err := move(); !errors.Is(err, engine.ErrGameOver) {
+			t.Errorf("%s after the game ended: err = %v, want ErrGameOver", name, err)
+		}
+	}
+}
+
+func TestAbandonDuringPlacement(t *testing.T) {
+	st := lingeringStore{store.NewMemory()}
+	s := NewService(st, nil, nil, TimeControl{})
+	roomID := "test-placement"
+	ctx := context.Background()
+	if err := st.CreateRoom(ctx, roomID, []string{"p1", "p2"}, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	stateJSON, _ := json.Marshal(GameState{RoomID: roomID})
+	st.SaveGameState(ctx, roomID, stateJSON, time.Hour)
+	if _, err := s.PlaceShips(roomID, "p1", testFleet()); err != nil {
+		t.Fatal(err)
+	}
+
+	gameOver, err := s.Abandon(roomID, "p2")
+	if err != nil {
+		t.Fatalf("Abandon: %v", err)
+	}
+	if gameOver.Winner != "p1" || gameOver.Loser != "p2" || gameOver.Reason != ReasonAbandoned {
+		t.Errorf("game over = %+v", gameOver)
+	}
+	if _, err := s.PlaceShips(roomID, "p2", testFleet()); !errors.Is(err, engine.ErrGameOver) {
+		t.Errorf("PlaceShips after the game ended: err = %v, want ErrGameOver", err)
+	}
+}
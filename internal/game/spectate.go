@@ -0,0 +1,99 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+var ErrNotWatchable = errors.New("game cannot be watched")
+
+// liveGames lists the rooms with a game in progress, for spectators.
+const liveGames = "live_games"
+
+// SpectatorView returns the public state of a running game. Private games
+// and games that have not started cannot be watched.
+func (s *Service) SpectatorView(roomID string) (*SpectatorView, error) {
+	var view *SpectatorView
+	err := s.store.UpdateRoom(s.ctx, roomID, func(tx store.RoomTx) error {
+		g, gameState, err := loadGame(tx, roomID)
+		if err != nil {
+			return err
+		}
+		if gameState.Private || !g.Started() || g.Over() {
+			return ErrNotWatchable
+		}
+		view = &SpectatorView{
+			RoomID:     roomID,
+			Rules:      g.Rules,
+			Players:    g.Players,
+			Turn:       g.Turn,
+			SalvoShots: gameState.SalvoShots,
+			Shots:      make(map[string][]engine.Shot),
+			SunkShips:  make(map[string][]ShipType),
+			Deadline:   gameState.Deadline,
+			Banks:      gameState.Banks,
+		}
+		for _, p := range g.Players {
+			view.Shots[p] = append([]engine.Shot{}, g.ShotsBy(p)...)
+			view.SunkShips[p] = append([]ShipType{}, g.SunkShips(g.Opponent(p))...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
+// LiveGames returns the games in progress that can be watched, oldest first.
+func (s *Service) LiveGames() ([]LiveGame, error) {
+	entries, err := s.store.Entries(s.ctx, liveGames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list live games: %v", err)
+	}
+	games := []LiveGame{}
+	for _, entry := range entries {
+		state, err := s.State(entry.ID)
+		if err != nil {
+			log.Printf("Failed to read live game %s: %v", entry.ID, err)
+			continue
+		}
+		if state == nil {
+			// The room expired without the game finishing
+			s.store.Remove(s.ctx, liveGames, entry.ID)
+			continue
+		}
+		if state.Private {
+			continue
+		}
+		players, err := s.store.RoomPlayers(s.ctx, entry.ID)
+		if err != nil || len(players) != 2 {
+			continue
+		}
+		game := LiveGame{
+			RoomID:    entry.ID,
+			Rules:     state.Rules,
+			Turn:      state.Turn,
+			StartedAt: state.StartedAt,
+			Unranked:  state.Unranked,
+		}
+		copy(game.Players[:], players)
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+// fleetsOf returns the ships of both players of a game.
+func fleetsOf(g engine.Game) map[string][]Ship {
+	fleets := make(map[string][]Ship)
+	for i, p := range g.Players {
+		if g.Boards[i] != nil {
+			fleets[p] = g.Boards[i].Ships
+		}
+	}
+	return fleets
+}
@@ -0,0 +1,77 @@
+package game
+
+import "time"
+
+// What the server does for a player who runs out of time.
+const (
+	TimeoutRandomShot = "random"  // fire at a random cell not yet attacked
+	TimeoutSkip       = "skip"    // pass the turn without a shot
+	TimeoutForfeit    = "forfeit" // end the game, the player loses
+)
+
+// TimeControl limits how long a player may think. Every shot gets
+// TurnSeconds; once that runs out the player's bank, shared by all their
+// shots like a chess clock, is used. With TurnSeconds 0 the bank is the only
+// clock and running out of it always forfeits the game.
+type TimeControl struct {
+	TurnSeconds int    `json:"turnSeconds"`
+	BankSeconds int    `json:"bankSeconds"`
+	OnTimeout   string `json:"onTimeout"`
+}
+
+// Enabled reports whether turns are timed at all.
+func (tc TimeControl) Enabled() bool {
+	return tc.TurnSeconds > 0 || tc.BankSeconds > 0
+}
+
+// timeoutAction returns what happens when a turn expires.
+func (tc TimeControl) timeoutAction() string {
+	if tc.TurnSeconds == 0 {
+		return TimeoutForfeit
+	}
+	switch tc.OnTimeout {
+	case TimeoutSkip, TimeoutForfeit:
+		return tc.OnTimeout
+	default:
+		return TimeoutRandomShot
+	}
+}
+
+// startClocks sets up the time control of a game that is starting.
+func (gs *GameState) startClocks(tc TimeControl, players [2]string, now time.Time) {
+	if !tc.Enabled() {
+		return
+	}
+	gs.TimeControl = &tc
+	gs.Banks = map[string]int64{}
+	for _, player := range players {
+		gs.Banks[player] = int64(tc.BankSeconds) * 1000
+	}
+	gs.startTurn(now)
+}
+
+// startTurn sets the deadline of the player now to move.
+func (gs *GameState) startTurn(now time.Time) {
+	if gs.TimeControl == nil || gs.Turn == "" {
+		gs.TurnStartedAt, gs.Deadline = 0, 0
+		return
+	}
+	gs.TurnStartedAt = now.UnixMilli()
+	gs.Deadline = gs.TurnStartedAt + int64(gs.TimeControl.TurnSeconds)*1000 + gs.Banks[gs.Turn]
+}
+
+// chargeBank takes the time player spent beyond the per-turn allowance out of
+// their bank.
+func (gs *GameState) chargeBank(player string, now time.Time) {
+	if gs.TimeControl == nil || gs.TurnStartedAt == 0 {
+		return
+	}
+	over := now.UnixMilli() - gs.TurnStartedAt - int64(gs.TimeControl.TurnSeconds)*1000
+	if over <= 0 {
+		return
+	}
+	gs.Banks[player] -= over
+	if gs.Banks[player] < 0 {
+		gs.Banks[player] = 0
+	}
+}
@@ -0,0 +1,215 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/store"
+	"github.com/krishanu7/battleship-backend/internal/store/storetest"
+)
+
+func TestTimeoutAction(t *testing.T) {
+	tests := []struct {
+		tc   TimeControl
+		want string
+	}{
+		{TimeControl{TurnSeconds: 30}, TimeoutRandomShot},
+		{TimeControl{TurnSeconds: 30, OnTimeout: "nap"}, TimeoutRandomShot},
+		{TimeControl{TurnSeconds: 30, OnTimeout: TimeoutSkip}, TimeoutSkip},
+		{TimeControl{TurnSeconds: 30, OnTimeout: TimeoutForfeit}, TimeoutForfeit},
+		// With only a bank, running out of it always forfeits
+		{TimeControl{BankSeconds: 300, OnTimeout: TimeoutSkip}, TimeoutForfeit},
+	}
+	for _, tt := range tests {
+		if got := tt.tc.timeoutAction(); got != tt.want {
+			t.Errorf("%+v: timeoutAction() = %s, want %s", tt.tc, got, tt.want)
+		}
+	}
+}
+
+func TestStartClocks(t *testing.T) {
+	now := time.UnixMilli(1_000_000)
+	players := [2]string{"p1", "p2"}
+
+	var off GameState
+	off.Turn = "p1"
+	off.startClocks(TimeControl{}, players, now)
+	if off.TimeControl != nil || off.Deadline != 0 {
+		t.Errorf("untimed game got clocks: %+v", off)
+	}
+
+	gs := GameState{Turn: "p1"}
+	gs.startClocks(TimeControl{TurnSeconds: 10, BankSeconds: 60}, players, now)
+	if want := map[string]int64{"p1": 60000, "p2": 60000}; !reflect.DeepEqual(gs.Banks, want) {
+		t.Errorf("banks = %v, want %v", gs.Banks, want)
+	}
+	if gs.TurnStartedAt != now.UnixMilli() || gs.Deadline != now.UnixMilli()+70000 {
+		t.Errorf("turn started %d with deadline %d", gs.TurnStartedAt, gs.Deadline)
+	}
+}
+
+func TestChargeBank(t *testing.T) {
+	start := time.UnixMilli(1_000_000)
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		bank    int64
+	}{
+		{"within the turn", 5 * time.Second, 60000},
+		{"exactly the turn", 10 * time.Second, 60000},
+		{"into the bank", 25 * time.Second, 45000},
+		{"past the bank", 100 * time.Second, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gs := GameState{Turn: "p1"}
+			gs.startClocks(TimeControl{TurnSeconds: 10, BankSeconds: 60}, [2]string{"p1", "p2"}, start)
+			now := start.Add(tt.elapsed)
+			gs.chargeBank("p1", now)
+			if gs.Banks["p1"] != tt.bank || gs.Banks["p2"] != 60000 {
+				t.Fatalf("banks = %v, want p1 %d", gs.Banks, tt.bank)
+			}
+			// The next turn of p1 may use what is left of the bank
+			gs.startTurn(now)
+			if want := now.UnixMilli() + 10000 + tt.bank; gs.Deadline != want {
+				t.Errorf("deadline = %d, want %d", gs.Deadline, want)
+			}
+		})
+	}
+
+	// An untimed game has no bank to charge
+	var gs GameState
+	gs.chargeBank("p1", start)
+	if gs.Banks != nil {
+		t.Errorf("untimed game charged: %v", gs.Banks)
+	}
+}
+
+func TestHandleTimeout(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		for _, action := range []string{TimeoutSkip, TimeoutRandomShot} {
+			t.Run(name+"/"+action, func(t *testing.T) {
+				s := NewService(st, nil, nil, TimeControl{TurnSeconds: 30, BankSeconds: 60, OnTimeout: action})
+				roomID := fmt.Sprintf("test-timeout-%d", time.Now().UnixNano())
+				startTestGame(t, s, st, roomID)
+				state, err := s.State(roomID)
+				if err != nil || state == nil {
+					t.Fatalf("State: %v, %v", state, err)
+				}
+				player, other := state.Turn, "p1"
+				if player == "p1" {
+					other = "p2"
+				}
+
+				// Timeouts that do not hold are ignored
+				stale := map[string]struct {
+					player   string
+					deadline int64
+				}{
+					"not yet due":    {player, state.Deadline},
+					"not their turn": {other, state.Deadline},
+				}
+				for what, call := range stale {
+					if result, err := s.HandleTimeout(roomID, call.player, call.deadline); err != nil || result != nil {
+						t.Errorf("%s: HandleTimeout = %+v, %v, want nothing", what, result, err)
+					}
+				}
+
+				deadline := expireTurn(t, st, state, 100*time.Second)
+				if result, err := s.HandleTimeout(roomID, player, deadline+1); err != nil || result != nil {
+					t.Errorf("other deadline: HandleTimeout = %+v, %v, want nothing", result, err)
+				}
+				result, err := s.HandleTimeout(roomID, player, deadline)
+				if err != nil || result == nil {
+					t.Fatalf("HandleTimeout = %+v, %v", result, err)
+				}
+				if result.Action != action || result.Player != player || result.GameOver != nil {
+					t.Errorf("result = %+v", result)
+				}
+				// The whole bank went on the turn that timed out
+				if result.State.Banks[player] != 0 || result.State.Banks[other] != 60000 {
+					t.Errorf("banks = %v", result.State.Banks)
+				}
+
+				attacks, err := st.Attacks(context.Background(), roomID, player)
+				if err != nil {
+					t.Fatalf("Attacks: %v", err)
+				}
+				switch action {
+				case TimeoutSkip:
+					if result.Attack != nil || len(attacks) != 0 || result.State.Turn != other {
+						t.Errorf("skip fired %v, turn %s", attacks, result.State.Turn)
+					}
+				case TimeoutRandomShot:
+					if result.Attack == nil || !reflect.DeepEqual(attacks, []string{result.Attack.Coordinate}) {
+						t.Fatalf("random shot %+v, stored %v", result.Attack, attacks)
+					}
+					want := player
+					if result.Attack.Result == string(engine.ResultMiss) {
+						want = other
+					}
+					if result.State.Turn != want {
+						t.Errorf("%s after a %s, want %s to move", result.State.Turn, result.Attack.Result, want)
+					}
+				}
+				if result.State.Turn == player && result.State.Deadline <= deadline {
+					t.Errorf("deadline %d not moved on from %d", result.State.Deadline, deadline)
+				}
+
+				// The same timeout fires only once
+				if again, err := s.HandleTimeout(roomID, player, deadline); err != nil || again != nil {
+					t.Errorf("second HandleTimeout = %+v, %v, want nothing", again, err)
+				}
+			})
+		}
+	}
+}
+
+// expireTurn moves the start of the current turn ago into the past and
+// returns its deadline, which is then already due.
+func expireTurn(t *testing.T, st store.Store, state *GameState, ago time.Duration) int64 {
+	t.Helper()
+	state.TurnStartedAt = time.Now().Add(-ago).UnixMilli()
+	state.Deadline = state.TurnStartedAt + int64(state.TimeControl.TurnSeconds)*1000 + state.Banks[state.Turn]
+	stateJSON, _ := json.Marshal(state)
+	if err := st.SaveGameState(context.Background(), state.RoomID, stateJSON, time.Hour); err != nil {
+		t.Fatalf("SaveGameState: %v", err)
+	}
+	return state.Deadline
+}
+
+func TestHandleTimeoutForfeit(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			st := lingeringStore{st}
+			s := NewService(st, nil, nil, TimeControl{BankSeconds: 60})
+			roomID := fmt.Sprintf("test-timeout-forfeit-%d", time.Now().UnixNano())
+			startTestGame(t, s, st, roomID)
+			state, err := s.State(roomID)
+			if err != nil || state == nil {
+				t.Fatalf("State: %v, %v", state, err)
+			}
+			player := state.Turn
+
+			deadline := expireTurn(t, st, state, 61*time.Second)
+			result, err := s.HandleTimeout(roomID, player, deadline)
+			if err != nil || result == nil {
+				t.Fatalf("HandleTimeout = %+v, %v", result, err)
+			}
+			if result.Action != TimeoutForfeit || result.State != nil {
+				t.Errorf("result = %+v", result)
+			}
+			if over := result.GameOver; over == nil || over.Loser != player || over.Reason != ReasonTimeout {
+				t.Errorf("game over = %+v, want %s to lose on time", over, player)
+			}
+			if state, err := s.State(roomID); err != nil || state == nil || state.Winner == player || !state.Over() {
+				t.Errorf("state after the timeout = %+v, %v", state, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package history
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Match is a finished game as stored in the matches table.
+type Match struct {
+	ID              int64           `json:"id"`
+	RoomID          string          `json:"roomId"`
+	Player1         string          `json:"player1"`
+	Player2         string          `json:"player2"`
+	Winner          string          `json:"winner,omitempty"`
+	Loser           string          `json:"loser,omitempty"`
+	EndReason       string          `json:"endReason,omitempty"` // empty when a fleet was sunk
+	Mode            string          `json:"mode"`
+	Rules           json.RawMessage `json:"rules,omitempty"` // board size and fleet
+	Ranked          bool            `json:"ranked"`          // false for games against a bot
+	StartedAt       time.Time       `json:"startedAt"`
+	EndedAt         time.Time       `json:"endedAt"`
+	Player1Board    json.RawMessage `json:"player1Board,omitempty"`
+	Player2Board    json.RawMessage `json:"player2Board,omitempty"`
+	Shots           json.RawMessage `json:"shots,omitempty"` // ordered shot log
+	Events          json.RawMessage `json:"-"`                // full event log, served as a replay
+	Player1EloDelta int             `json:"player1EloDelta"`
+	Player2EloDelta int             `json:"player2EloDelta"`
+}
+
+// Page is one page of a player's match list.
+type Page struct {
+	Matches []Match `json:"matches"`
+	Limit   int     `json:"limit"`
+	Offset  int     `json:"offset"`
+	Total   int     `json:"total"`
+}
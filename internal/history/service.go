@@ -0,0 +1,124 @@
+package history
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrMatchNotFound = errors.New("match not found")
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+type Service struct {
+	db *sql.DB
+}
+
+func NewService(db *sql.DB) *Service {
+	return &Service{db: db}
+}
+
+// Record stores a finished match and returns its ID.
+func (s *Service) Record(m *Match) (int64, error) {
+	var winner, loser sql.NullString
+	if m.Winner != "" {
+		winner = sql.NullString{String: m.Winner, Valid: true}
+		loser = sql.NullString{String: m.Loser, Valid: true}
+	}
+	err := s.db.QueryRow(
+		`INSERT INTO matches (room_id, player1_id, player2_id, winner_id, loser_id, end_reason, mode, rules, ranked, started_at, ended_at,
+		                      player1_board, player2_board, shots, events, player1_elo_delta, player2_elo_delta)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		 RETURNING id`,
+		m.RoomID, m.Player1, m.Player2, winner, loser, m.EndReason, m.Mode, nullJSON(m.Rules), m.Ranked, m.StartedAt, m.EndedAt,
+		[]byte(m.Player1Board), []byte(m.Player2Board), []byte(m.Shots), []byte(m.Events),
+		m.Player1EloDelta, m.Player2EloDelta,
+	).Scan(&m.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record match: %v", err)
+	}
+	return m.ID, nil
+}
+
+// Get returns a match with boards, shot log and event log.
+func (s *Service) Get(id int64) (*Match, error) {
+	var m Match
+	var winner, loser sql.NullString
+	var rules, board1, board2, shots, events []byte
+	err := s.db.QueryRow(
+		`SELECT id, room_id, player1_id, player2_id, winner_id, loser_id, end_reason, mode, rules, ranked, started_at, ended_at,
+		        player1_board, player2_board, shots, events, player1_elo_delta, player2_elo_delta
+		 FROM matches WHERE id = $1`, id,
+	).Scan(&m.ID, &m.RoomID, &m.Player1, &m.Player2, &winner, &loser, &m.EndReason, &m.Mode, &rules, &m.Ranked, &m.StartedAt, &m.EndedAt,
+		&board1, &board2, &shots, &events, &m.Player1EloDelta, &m.Player2EloDelta)
+	if err == sql.ErrNoRows {
+		return nil, ErrMatchNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get match: %v", err)
+	}
+	m.Winner, m.Loser = winner.String, loser.String
+	m.Rules, m.Player1Board, m.Player2Board, m.Shots, m.Events = rules, board1, board2, shots, events
+	return &m, nil
+}
+
+// nullJSON stores an empty document as NULL.
+func nullJSON(doc json.RawMessage) interface{} {
+	if len(doc) == 0 {
+		return nil
+	}
+	return []byte(doc)
+}
+
+// ListByPlayer returns the player's matches, most recent first, without
+// boards and shot logs.
+func (s *Service) ListByPlayer(playerID string, limit, offset int) (*Page, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	page := &Page{Matches: []Match{}, Limit: limit, Offset: offset}
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM matches WHERE player1_id = $1 OR player2_id = $1", playerID,
+	).Scan(&page.Total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count matches: %v", err)
+	}
+
+	rows, err := s.db.Query(
+		`SELECT id, room_id, player1_id, player2_id, winner_id, loser_id, end_reason, mode, ranked, started_at, ended_at,
+		        player1_elo_delta, player2_elo_delta
+		 FROM matches WHERE player1_id = $1 OR player2_id = $1
+		 ORDER BY ended_at DESC, id DESC
+		 LIMIT $2 OFFSET $3`,
+		playerID, limit, offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list matches: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Match
+		var winner, loser sql.NullString
+		if err := rows.Scan(&m.ID, &m.RoomID, &m.Player1, &m.Player2, &winner, &loser, &m.EndReason, &m.Mode, &m.Ranked,
+			&m.StartedAt, &m.EndedAt, &m.Player1EloDelta, &m.Player2EloDelta); err != nil {
+			return nil, fmt.Errorf("failed to scan match: %v", err)
+		}
+		m.Winner, m.Loser = winner.String, loser.String
+		page.Matches = append(page.Matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
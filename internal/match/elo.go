@@ -0,0 +1,140 @@
+package match
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+// Players are matched by Elo. Everyone who starts matching accepts opponents
+// within a rating window that starts narrow and widens the longer they wait,
+// and the matchmaker pairs the two players closest in rating who accept each
+// other.
+const (
+	defaultRating = 1500 // Elo of players without finished games
+	baseWindow    = 100  // rating gap accepted right away
+	windowGrowth  = 10   // rating points added per second waited
+	maxWindow     = 1000
+	matchInterval = time.Second
+)
+
+// rating returns the player's Elo from stats.
+func (s *Service) rating(playerID string) (int, error) {
+	if s.db == nil {
+		return defaultRating, nil
+	}
+	var elo int
+	err := s.db.QueryRow("SELECT elo FROM stats WHERE player_id = $1", playerID).Scan(&elo)
+	if err == sql.ErrNoRows {
+		return defaultRating, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rating: %w", err)
+	}
+	return elo, nil
+}
+
+// window returns the largest rating gap a player accepts after waiting so
+// long. Entries without a join time have waited indefinitely.
+func window(waited time.Duration) int {
+	w := baseWindow + windowGrowth*int(waited/time.Second)
+	if w > maxWindow || w < 0 {
+		return maxWindow
+	}
+	return w
+}
+
+func waited(entry store.QueueEntry, now time.Time) time.Duration {
+	if entry.JoinedAt.IsZero() {
+		return time.Duration(1<<63 - 1)
+	}
+	return now.Sub(entry.JoinedAt).Round(time.Second)
+}
+
+// bestPair picks from entries, sorted by rating, the pair with the smallest
+// rating gap that both accept. Equal gaps go to the pair that has waited
+// longest.
+func bestPair(entries []store.QueueEntry, now time.Time) (store.QueueEntry, store.QueueEntry, bool) {
+	var a, b store.QueueEntry
+	found := false
+	bestGap, bestWait := 0, time.Duration(0)
+	for i := range entries {
+		iWindow := window(waited(entries[i], now))
+		for j := i + 1; j < len(entries); j++ {
+			gap := entries[j].Rating - entries[i].Rating
+			if gap > iWindow {
+				// Sorted by rating: the rest are further away
+				break
+			}
+			if gap > window(waited(entries[j], now)) {
+				continue
+			}
+			wait := waited(entries[i], now)/2 + waited(entries[j], now)/2
+			if !found || gap < bestGap || gap == bestGap && wait > bestWait {
+				a, b = entries[i], entries[j]
+				bestGap, bestWait = gap, wait
+				found = true
+			}
+		}
+	}
+	return a, b, found
+}
+
+// Metrics describes matchmaking in one ruleset: who is waiting now, and the
+// waits and rating gaps of its recent matches.
+type Metrics struct {
+	Ruleset            string  `json:"ruleset"`
+	Waiting            int     `json:"waiting"` // players who started matching
+	RecentMatches      int     `json:"recentMatches"`
+	AverageWaitSeconds float64 `json:"averageWaitSeconds"`
+	MaxWaitSeconds     float64 `json:"maxWaitSeconds"`
+	AverageRatingGap   float64 `json:"averageRatingGap"`
+	MaxRatingGap       int     `json:"maxRatingGap"`
+}
+
+// Metrics returns the matchmaking metrics of every ruleset with queues.
+func (s *Service) Metrics() ([]Metrics, error) {
+	keys, err := s.keys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rulesets: %w", err)
+	}
+	metrics := make([]Metrics, 0, len(keys))
+	for _, key := range keys {
+		m := Metrics{Ruleset: key}
+		waiting, err := s.store.Len(s.ctx, queue(s.startQueue, key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read start queue: %w", err)
+		}
+		m.Waiting = int(waiting)
+
+		records := s.recentMatches(key)
+		gaps := 0
+		var totalWait time.Duration
+		for _, record := range records {
+			totalWait += record.Wait
+			if wait := record.Wait.Seconds(); wait > m.MaxWaitSeconds {
+				m.MaxWaitSeconds = wait
+			}
+			if record.Gap < 0 {
+				continue
+			}
+			gaps++
+			m.AverageRatingGap += float64(record.Gap)
+			if record.Gap > m.MaxRatingGap {
+				m.MaxRatingGap = record.Gap
+			}
+		}
+		// Every match is recorded once per player
+		m.RecentMatches = len(records) / 2
+		if len(records) > 0 {
+			m.AverageWaitSeconds = totalWait.Seconds() / float64(len(records))
+		}
+		if gaps > 0 {
+			m.AverageRatingGap /= float64(gaps)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
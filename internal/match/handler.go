@@ -2,7 +2,13 @@ package match
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/krishanu7/battleship-backend/internal/auth"
+	"github.com/krishanu7/battleship-backend/internal/bot"
+	"github.com/krishanu7/battleship-backend/internal/game"
 )
 
 type Handler struct {
@@ -10,6 +16,51 @@ type Handler struct {
 	matchChan chan MatchResult
 }
 
+// JoinQueueRequest picks the ruleset to be matched in. Both fields are
+// optional; Mode is shorthand for the mode of Rules, and anything unset
+// falls back to the classic rules.
+type JoinQueueRequest struct {
+	Mode  string      `json:"mode"` // "classic" (default) or "salvo"
+	Rules *game.Rules `json:"rules"`
+}
+
+// rules returns the ruleset asked for, before defaults are applied.
+func (req JoinQueueRequest) rules() game.Rules {
+	var rules game.Rules
+	if req.Rules != nil {
+		rules = *req.Rules
+	}
+	if rules.Mode == "" {
+		rules.Mode = req.Mode
+	}
+	return rules
+}
+
+// PlayBotRequest asks for a game against the computer. The ruleset is
+// chosen as when joining the queue.
+type PlayBotRequest struct {
+	JoinQueueRequest
+	Difficulty string `json:"difficulty"` // "easy", "medium" (default) or "hard"
+}
+
+type PlayBotResponse struct {
+	RoomID     string `json:"roomId"`
+	Opponent   string `json:"opponent"`
+	Difficulty string `json:"difficulty"`
+}
+
+// CreateLobbyRequest picks the ruleset of a lobby as when joining the queue,
+// and whether its game is unranked or private.
+type CreateLobbyRequest struct {
+	JoinQueueRequest
+	LobbyOptions
+}
+
+// StartLobbyResponse is the room a lobby's game was started in.
+type StartLobbyResponse struct {
+	RoomID string `json:"roomId"`
+}
+
 func NewHandler(service *Service, matchChan chan MatchResult) *Handler {
 	return &Handler{
 		service:   service,
@@ -18,15 +69,27 @@ func NewHandler(service *Service, matchChan chan MatchResult) *Handler {
 }
 
 func (h *Handler) JoinQueue(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		PlayerID string `json:"playerId"`
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request payload", http.StatusBadRequest)
+
+	// The body is optional; without it the player queues for classic
+	var req JoinQueueRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	rules := req.rules()
+	if err := rules.WithDefaults().Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if err := h.service.AddToQueue(req.PlayerID); err != nil {
+	if err := h.service.AddToQueue(playerID, rules); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -35,14 +98,12 @@ func (h *Handler) JoinQueue(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) LeaveQueue(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		PlayerID string `json:"playerId"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PlayerID == "" {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	if err := h.service.RemoveFromQueue(req.PlayerID); err != nil {
+	if err := h.service.RemoveFromQueue(playerID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -51,15 +112,13 @@ func (h *Handler) LeaveQueue(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) StartMatch(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		PlayerID string `json:"playerId"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PlayerID == "" {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if err := h.service.StartMatching(req.PlayerID); err != nil {
+	if err := h.service.StartMatching(playerID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -69,15 +128,13 @@ func (h *Handler) StartMatch(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) CancelMatch(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		PlayerID string `json:"playerId"`
-	}	
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PlayerID == "" {
-		http.Error(w, "invalid request", http.StatusBadRequest)
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	if err := h.service.CancelMatching(req.PlayerID); err != nil {
+	if err := h.service.CancelMatching(playerID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -86,9 +143,9 @@ func (h *Handler) CancelMatch(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetMatchStatus(w http.ResponseWriter, r *http.Request) {
-	playerID := r.URL.Query().Get("playerId")
-	if playerID == "" {
-		http.Error(w, "missing playerId", http.StatusBadRequest)
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -107,4 +164,213 @@ func (h *Handler) GetMatchStatus(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
-}
\ No newline at end of file
+}
+
+// GetQueuePosition reports where the player stands in matchmaking and how
+// much longer they are expected to wait.
+func (h *Handler) GetQueuePosition(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	pos, err := h.service.Position(playerID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pos == nil {
+		http.Error(w, "not in queue", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pos)
+}
+
+// GetMetrics reports the waiting times and rating gaps of recent matches.
+func (h *Handler) GetMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics, err := h.service.Metrics()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// PlayBot starts an unranked game against a bot right away.
+func (h *Handler) PlayBot(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req PlayBotRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Difficulty == "" {
+		req.Difficulty = bot.Medium
+	}
+	if !bot.ValidDifficulty(req.Difficulty) {
+		http.Error(w, "unknown difficulty", http.StatusBadRequest)
+		return
+	}
+	rules := req.rules()
+	if err := rules.WithDefaults().Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	roomID, botID, err := h.service.PlayBot(playerID, req.Difficulty, rules)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PlayBotResponse{RoomID: roomID, Opponent: botID, Difficulty: req.Difficulty})
+}
+
+// lobbyError writes err with the status that fits it.
+func lobbyError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrLobbyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrNotHost), errors.Is(err, ErrNotInLobby):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrLobbyFull), errors.Is(err, ErrLobbyNotReady):
+		status = http.StatusConflict
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// CreateLobby opens a lobby. The ruleset is chosen as when joining the
+// queue and can be changed later by the host; the game is ranked and public
+// unless asked otherwise.
+func (h *Handler) CreateLobby(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req CreateLobbyRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	rules := req.rules()
+	if err := rules.WithDefaults().Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lobby, err := h.service.CreateLobby(playerID, rules, req.LobbyOptions)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(lobby)
+}
+
+// GetLobby shows who is in a lobby and what will be played.
+func (h *Handler) GetLobby(w http.ResponseWriter, r *http.Request) {
+	lobby, err := h.service.Lobby(mux.Vars(r)["code"])
+	if err != nil {
+		lobbyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobby)
+}
+
+// JoinLobby joins a lobby by its invite code.
+func (h *Handler) JoinLobby(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	lobby, err := h.service.JoinLobby(mux.Vars(r)["code"], playerID)
+	if err != nil {
+		lobbyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobby)
+}
+
+// LeaveLobby leaves a lobby; the lobby closes when the host leaves.
+func (h *Handler) LeaveLobby(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.LeaveLobby(mux.Vars(r)["code"], playerID); err != nil {
+		lobbyError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("Player left lobby"))
+}
+
+// SetLobbyRules lets the host change the ruleset of a lobby.
+func (h *Handler) SetLobbyRules(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req JoinQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	rules := req.rules()
+	if err := rules.WithDefaults().Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lobby, err := h.service.SetLobbyRules(mux.Vars(r)["code"], playerID, rules)
+	if err != nil {
+		lobbyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lobby)
+}
+
+// StartLobby lets the host start the game once a friend has joined. Both
+// players get match_found like after matchmaking.
+func (h *Handler) StartLobby(w http.ResponseWriter, r *http.Request) {
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	roomID, err := h.service.StartLobby(mux.Vars(r)["code"], playerID)
+	if err != nil {
+		lobbyError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StartLobbyResponse{RoomID: roomID})
+}
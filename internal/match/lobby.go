@@ -0,0 +1,321 @@
+package match
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+var (
+	ErrLobbyNotFound = errors.New("lobby not found")
+	ErrLobbyFull     = errors.New("lobby is full")
+	ErrNotHost       = errors.New("only the host can do this")
+	ErrNotInLobby    = errors.New("player is not in this lobby")
+	ErrLobbyNotReady = errors.New("lobby is waiting for a second player")
+)
+
+// Invite codes are short and avoid letters and digits that are easily
+// mistaken for one another, so they can be read out to a friend.
+const (
+	codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	codeLength   = 6
+	codeAttempts = 10
+	lobbyTTL     = 1 * time.Hour
+	lobbySize    = 2
+)
+
+// LobbyOptions are chosen by the host when opening a lobby. By default a
+// lobby's game is ranked and can be spectated, like a game from the queue.
+type LobbyOptions struct {
+	Unranked bool `json:"unranked"`
+	Private  bool `json:"private"` // cannot be spectated
+}
+
+// Lobby is a room a host opens for a friend to join by invite code.
+type Lobby struct {
+	Code    string     `json:"code"`
+	Host    string     `json:"host"`
+	Members []string   `json:"members"` // host first
+	Rules   game.Rules `json:"rules"`
+	LobbyOptions
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (l *Lobby) member(playerID string) bool {
+	for _, m := range l.Members {
+		if m == playerID {
+			return true
+		}
+	}
+	return false
+}
+
+func newCode() (string, error) {
+	code := make([]byte, codeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = codeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// NormalizeCode accepts codes as people type them: in any case and with
+// spaces or dashes.
+func NormalizeCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// CreateLobby opens a lobby hosted by the player with the given ruleset and
+// options.
+func (s *Service) CreateLobby(hostID string, rules game.Rules, opts LobbyOptions) (*Lobby, error) {
+	rules = rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	lobby := &Lobby{
+		Host:         hostID,
+		Members:      []string{hostID},
+		Rules:        rules,
+		LobbyOptions: opts,
+		CreatedAt:    time.Now(),
+	}
+	for attempt := 0; attempt < codeAttempts; attempt++ {
+		code, err := newCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate code: %w", err)
+		}
+		lobby.Code = code
+		lobbyJSON, err := json.Marshal(lobby)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal lobby: %w", err)
+		}
+		err = s.store.CreateLobby(s.ctx, code, lobbyJSON, lobbyTTL)
+		if errors.Is(err, store.ErrConflict) {
+			// Code taken
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store lobby: %w", err)
+		}
+		log.Printf("Player %s opened lobby %s (%s)", hostID, code, rules.Key())
+		return lobby, nil
+	}
+	return nil, fmt.Errorf("failed to find a free lobby code")
+}
+
+// Lobby returns the lobby with the given code.
+func (s *Service) Lobby(code string) (*Lobby, error) {
+	lobbyJSON, err := s.store.Lobby(s.ctx, NormalizeCode(code))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrLobbyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lobby: %w", err)
+	}
+	var lobby Lobby
+	if err := json.Unmarshal(lobbyJSON, &lobby); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal lobby: %w", err)
+	}
+	return &lobby, nil
+}
+
+// updateLobby applies fn to a lobby atomically. fn returns false to close
+// the lobby for the given reason. The members before and after the update
+// are told about it, except for the player who made it. fn must not use the
+// store.
+func (s *Service) updateLobby(code, playerID, closeReason string, fn func(l *Lobby) (bool, error)) (*Lobby, error) {
+	code = NormalizeCode(code)
+	var lobby *Lobby
+	var before []string
+	err := s.store.UpdateLobby(s.ctx, code, lobbyTTL, func(lobbyJSON []byte) ([]byte, error) {
+		lobby = &Lobby{}
+		if err := json.Unmarshal(lobbyJSON, lobby); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal lobby: %w", err)
+		}
+		before = append([]string(nil), lobby.Members...)
+		keep, err := fn(lobby)
+		if err != nil {
+			return nil, err
+		}
+		if !keep {
+			lobby = nil
+			return nil, nil
+		}
+		return json.Marshal(lobby)
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrLobbyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	told := make(map[string]bool)
+	for _, m := range before {
+		told[m] = false
+	}
+	if lobby != nil {
+		for _, m := range lobby.Members {
+			told[m] = false
+		}
+	}
+	for m := range told {
+		if m != playerID {
+			s.notifyLobby(m, code, lobby, closeReason)
+		}
+	}
+	return lobby, nil
+}
+
+// JoinLobby adds the player to a lobby. Joining a lobby again is a no-op.
+func (s *Service) JoinLobby(code, playerID string) (*Lobby, error) {
+	return s.updateLobby(code, playerID, "", func(l *Lobby) (bool, error) {
+		if l.member(playerID) {
+			return true, nil
+		}
+		if len(l.Members) >= lobbySize {
+			return false, ErrLobbyFull
+		}
+		l.Members = append(l.Members, playerID)
+		return true, nil
+	})
+}
+
+// LeaveLobby removes the player from a lobby. The lobby closes when its
+// host leaves.
+func (s *Service) LeaveLobby(code, playerID string) error {
+	_, err := s.updateLobby(code, playerID, "host_left", func(l *Lobby) (bool, error) {
+		if !l.member(playerID) {
+			return false, ErrNotInLobby
+		}
+		if playerID == l.Host {
+			return false, nil
+		}
+		kept := l.Members[:0]
+		for _, m := range l.Members {
+			if m != playerID {
+				kept = append(kept, m)
+			}
+		}
+		l.Members = kept
+		return true, nil
+	})
+	return err
+}
+
+// SetLobbyRules changes the ruleset of a lobby. Only the host may.
+func (s *Service) SetLobbyRules(code, playerID string, rules game.Rules) (*Lobby, error) {
+	rules = rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %w", err)
+	}
+	return s.updateLobby(code, playerID, "", func(l *Lobby) (bool, error) {
+		if playerID != l.Host {
+			return false, ErrNotHost
+		}
+		l.Rules = rules
+		return true, nil
+	})
+}
+
+// StartLobby closes a full lobby and starts its game in a new room, exactly
+// as a match from the queue would. Only the host may. It returns the room.
+func (s *Service) StartLobby(code, playerID string) (string, error) {
+	current, err := s.Lobby(code)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range current.Members {
+		roomID, err := s.store.FindRoomByPlayer(s.ctx, m)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up room: %w", err)
+		}
+		if roomID != "" {
+			return "", fmt.Errorf("player %s already in room %s", m, roomID)
+		}
+	}
+
+	var players []string
+	var rules game.Rules
+	var opts LobbyOptions
+	_, err = s.updateLobby(code, playerID, "started", func(l *Lobby) (bool, error) {
+		if playerID != l.Host {
+			return false, ErrNotHost
+		}
+		if len(l.Members) < lobbySize {
+			return false, ErrLobbyNotReady
+		}
+		if strings.Join(l.Members, ",") != strings.Join(current.Members, ",") {
+			// Someone left and joined since the rooms were checked
+			return false, ErrLobbyNotReady
+		}
+		players = append([]string(nil), l.Members...)
+		rules = l.Rules
+		opts = l.LobbyOptions
+		return false, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// The lobby is gone, and with it the members' reason to wait for a match
+	for _, p := range players {
+		s.RemoveFromQueue(p)
+		s.CancelMatching(p)
+	}
+	roomID, err := game.NewRoomID()
+	if err != nil {
+		return "", err
+	}
+	if err := s.store.CreateRoom(s.ctx, roomID, players, 1*time.Hour); err != nil {
+		return "", fmt.Errorf("failed to store room mapping: %w", err)
+	}
+	if err := s.saveState(game.GameState{RoomID: roomID, Rules: rules, Unranked: opts.Unranked, Private: opts.Private}); err != nil {
+		s.store.DeleteRoom(s.ctx, roomID)
+		return "", err
+	}
+
+	log.Printf("Lobby %s started room %s for %s and %s", NormalizeCode(code), roomID, players[0], players[1])
+	s.notifyMatch(MatchResult{Player1: players[0], Player2: players[1], RoomID: roomID, Rules: rules, Unranked: opts.Unranked})
+	return roomID, nil
+}
+
+// LobbyNotice tells a member that a lobby changed, or that it closed for
+// Reason.
+type LobbyNotice struct {
+	Type   string `json:"type"` // "lobby_updated" or "lobby_closed"
+	Player string `json:"player"`
+	Code   string `json:"code"`
+	Lobby  *Lobby `json:"lobby,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// notifyLobby tells a member that a lobby changed; a nil lobby was closed,
+// because the host left or started the game.
+func (s *Service) notifyLobby(playerID, code string, lobby *Lobby, closeReason string) {
+	notification := LobbyNotice{
+		Type:   "lobby_updated",
+		Player: playerID,
+		Code:   code,
+		Lobby:  lobby,
+	}
+	if lobby == nil {
+		notification.Type = "lobby_closed"
+		notification.Reason = closeReason
+	}
+	if err := s.notifier.Send(playerID, notification); err != nil {
+		log.Printf("Failed to send lobby notification to %s: %v", playerID, err)
+	}
+}
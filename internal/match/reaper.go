@@ -0,0 +1,280 @@
+package match
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+// Reasons a player is dropped from matchmaking, sent with queue_timeout
+const (
+	TimeoutMaxWait      = "max_wait"
+	TimeoutDisconnected = "disconnected"
+)
+
+// presenceGrace is how long a new entry may wait for its player's general
+// connection before the reaper counts the player as gone.
+const presenceGrace = 30 * time.Second
+
+// Recent matches of each ruleset are kept to estimate waiting times and to
+// report matchmaking metrics.
+const (
+	maxRecordedWaits = 100
+	waitWindow       = time.Hour
+)
+
+// waitsQueue lists the recent waits of a ruleset as
+// "playerID/milliseconds/ratingGap".
+func waitsQueue(key string) string {
+	return "matchmaking_waits:" + key
+}
+
+// RunReaper evicts stale queue entries every interval: players who have
+// waited longer than maxWait, and players without a live general
+// connection. A maxWait of 0 lets players wait for as long as they stay
+// connected.
+func (s *Service) RunReaper(interval, maxWait time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.reap(maxWait); err != nil {
+			log.Printf("Queue reaper failed: %v", err)
+		}
+	}
+}
+
+func (s *Service) reap(maxWait time.Duration) error {
+	keys, err := s.keys()
+	if err != nil {
+		return fmt.Errorf("failed to list rulesets: %w", err)
+	}
+	now := time.Now()
+	for _, key := range keys {
+		for _, base := range []string{s.mainQueue, s.startQueue} {
+			q := queue(base, key)
+			entries, err := s.store.Entries(s.ctx, q)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", q, err)
+			}
+			for _, entry := range entries {
+				reason := s.staleReason(entry, now, maxWait)
+				if reason == "" {
+					continue
+				}
+				removed, err := s.store.Remove(s.ctx, q, entry.ID)
+				if err != nil {
+					log.Printf("Failed to evict %s from %s: %v", entry.ID, q, err)
+					continue
+				}
+				// The matchmaker may have taken the player meanwhile
+				if !removed {
+					continue
+				}
+				log.Printf("Evicted %s from %s: %s", entry.ID, q, reason)
+				s.notifyTimeout(entry.ID, reason)
+			}
+		}
+		s.trimWaits(key, now)
+		s.prune(key)
+	}
+	return nil
+}
+
+// staleReason returns why an entry should be evicted, or "" to keep it.
+func (s *Service) staleReason(entry store.QueueEntry, now time.Time, maxWait time.Duration) string {
+	waited := now.Sub(entry.JoinedAt)
+	if maxWait > 0 && waited > maxWait {
+		return TimeoutMaxWait
+	}
+	if waited < presenceGrace {
+		return ""
+	}
+	present, err := s.store.IsPresent(s.ctx, entry.ID)
+	if err != nil {
+		log.Printf("Failed to check presence of %s: %v", entry.ID, err)
+		return ""
+	}
+	if !present {
+		return TimeoutDisconnected
+	}
+	return ""
+}
+
+// dropAbsent returns the players with a live general connection and tells
+// the others they left matchmaking.
+func (s *Service) dropAbsent(players ...string) []string {
+	var present []string
+	for _, p := range players {
+		ok, err := s.store.IsPresent(s.ctx, p)
+		if err == nil && !ok {
+			log.Printf("Dropped %s from matchmaking: not connected", p)
+			s.notifyTimeout(p, TimeoutDisconnected)
+			continue
+		}
+		present = append(present, p)
+	}
+	return present
+}
+
+// QueueTimeout tells a player they were removed from matchmaking, for
+// Reason.
+type QueueTimeout struct {
+	Type   string `json:"type"` // "queue_timeout"
+	Player string `json:"player"`
+	Reason string `json:"reason"`
+}
+
+// notifyTimeout tells a player they were removed from matchmaking.
+func (s *Service) notifyTimeout(playerID, reason string) {
+	notification := QueueTimeout{
+		Type:   "queue_timeout",
+		Player: playerID,
+		Reason: reason,
+	}
+	if err := s.notifier.Send(playerID, notification); err != nil {
+		log.Printf("Failed to send queue_timeout to %s: %v", playerID, err)
+	}
+}
+
+// recordMatch remembers how long both players of a match waited in the start
+// queue and how far apart their ratings were.
+func (s *Service) recordMatch(key string, a, b store.QueueEntry, matchedAt time.Time) {
+	gap := b.Rating - a.Rating
+	if gap < 0 {
+		gap = -gap
+	}
+	waits := waitsQueue(key)
+	for _, entry := range []store.QueueEntry{a, b} {
+		if entry.JoinedAt.IsZero() {
+			continue
+		}
+		record := fmt.Sprintf("%s/%d/%d", entry.ID, matchedAt.Sub(entry.JoinedAt).Milliseconds(), gap)
+		if err := s.store.Push(s.ctx, waits, record); err != nil {
+			continue
+		}
+		if n, err := s.store.Len(s.ctx, waits); err == nil && n > maxRecordedWaits {
+			s.store.Pop(s.ctx, waits)
+		}
+	}
+}
+
+// trimWaits forgets waits recorded longer ago than waitWindow.
+func (s *Service) trimWaits(key string, now time.Time) {
+	waits := waitsQueue(key)
+	entries, err := s.store.Entries(s.ctx, waits)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if now.Sub(entry.JoinedAt) > waitWindow {
+			s.store.Remove(s.ctx, waits, entry.ID)
+		}
+	}
+}
+
+// matchRecord is one player's side of a recent match.
+type matchRecord struct {
+	Wait time.Duration
+	Gap  int // rating gap to the opponent, -1 if not recorded
+}
+
+// recentMatches returns what was recorded of the matches of a ruleset within
+// waitWindow, one record per matched player.
+func (s *Service) recentMatches(key string) []matchRecord {
+	entries, err := s.store.Entries(s.ctx, waitsQueue(key))
+	if err != nil {
+		return nil
+	}
+	var records []matchRecord
+	for _, entry := range entries {
+		if time.Since(entry.JoinedAt) > waitWindow {
+			continue
+		}
+		fields := strings.Split(entry.ID, "/")
+		if len(fields) < 2 {
+			continue
+		}
+		wait, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		record := matchRecord{Wait: time.Duration(wait) * time.Millisecond, Gap: -1}
+		if len(fields) > 2 {
+			if gap, err := strconv.Atoi(fields[2]); err == nil {
+				record.Gap = gap
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// averageWait returns the mean of the recent waits of a ruleset, and false
+// when there are none.
+func (s *Service) averageWait(key string) (time.Duration, bool) {
+	records := s.recentMatches(key)
+	if len(records) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, record := range records {
+		total += record.Wait
+	}
+	return total / time.Duration(len(records)), true
+}
+
+// QueuePosition is where a player stands in matchmaking.
+type QueuePosition struct {
+	Status        string `json:"status"`   // "in_queue" or "waiting" (started matching)
+	Position      int    `json:"position"` // 1 is first in line
+	QueueLength   int    `json:"queueLength"`
+	WaitedSeconds int64  `json:"waitedSeconds"`
+	// Estimated from recent matches of the same ruleset; absent without any
+	EstimatedWaitSeconds *int64 `json:"estimatedWaitSeconds,omitempty"`
+}
+
+// Position returns where the player stands in the queues of their ruleset,
+// or nil if they are not queued.
+func (s *Service) Position(playerID string) (*QueuePosition, error) {
+	for _, q := range []struct{ base, status string }{{s.startQueue, "waiting"}, {s.mainQueue, "in_queue"}} {
+		key, err := s.queuedKey(q.base, playerID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check queue: %w", err)
+		}
+		if key == "" {
+			continue
+		}
+		entries, err := s.store.Entries(s.ctx, queue(q.base, key))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read queue: %w", err)
+		}
+		for i, entry := range entries {
+			if entry.ID != playerID {
+				continue
+			}
+			pos := &QueuePosition{
+				Status:      q.status,
+				Position:    i + 1,
+				QueueLength: len(entries),
+			}
+			waited := time.Duration(0)
+			if !entry.JoinedAt.IsZero() {
+				waited = time.Since(entry.JoinedAt)
+				pos.WaitedSeconds = int64(waited / time.Second)
+			}
+			if average, ok := s.averageWait(key); ok {
+				left := int64((average - waited) / time.Second)
+				if left < 0 {
+					left = 0
+				}
+				pos.EstimatedWaitSeconds = &left
+			}
+			return pos, nil
+		}
+	}
+	return nil, nil
+}
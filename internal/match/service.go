@@ -2,199 +2,501 @@ package match
 
 import (
 	"context"
-	"crypto/sha1"
-	"encoding/hex"
+	"database/sql"
+	"encoding/json"
 	"fmt"
-	"github.com/redis/go-redis/v9"
 	"log"
 	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/bot"
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/notify"
+	"github.com/krishanu7/battleship-backend/internal/store"
 )
 
 type Service struct {
-	redisClient *redis.Client
-	ctx         context.Context
-	mainQueue   string // list of player in queue
-	startQueue  string // player who pressed start button
-	setName     string // set of players in queue
-	channel     string // channel for pub/sub
+	store      store.Store
+	db         *sql.DB // for ratings; nil rates everyone at defaultRating
+	ctx        context.Context
+	mainQueue  string // list of player in queue, one per ruleset
+	startQueue string // player who pressed start button, one per ruleset, rated by Elo
+	rulesets   string // keys of the rulesets that have queues
+	channel    string // channel for pub/sub
+	notifier   *notify.Service
 }
 
 type MatchResult struct {
-	Player1 string
-	Player2 string
-	RoomID  string
+	Player1  string
+	Player2  string
+	RoomID   string
+	Rules    game.Rules
+	Unranked bool
 }
 
-func NewService(rdb *redis.Client) *Service {
+func NewService(st store.Store, db *sql.DB) *Service {
 	return &Service{
-		redisClient: rdb,
-		ctx:         context.Background(),
-		mainQueue:   "matchmaking_queue",
-		startQueue:  "match_start_queue",
-		setName:     "queued_players",
-		channel:     "matchmaking_channel",
+		store:      st,
+		db:         db,
+		ctx:        context.Background(),
+		mainQueue:  "matchmaking_queue",
+		startQueue: "match_start_queue",
+		rulesets:   "matchmaking_rulesets",
+		channel:    "matchmaking_channel",
+		notifier:   notify.NewService(st),
+	}
+}
+
+// queue returns the name of the queue of a ruleset key. Players are only ever
+// matched with someone who chose the same ruleset. Classic keeps the original
+// unsuffixed names.
+func queue(base, key string) string {
+	if key == game.ModeClassic {
+		return base
+	}
+	return base + ":" + key
+}
+
+// keys returns the keys of all rulesets with queues, classic first.
+func (s *Service) keys() ([]string, error) {
+	registered, err := s.store.Members(s.ctx, s.rulesets)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{game.ModeClassic}
+	for _, key := range registered {
+		if key != game.ModeClassic {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// register records a ruleset key so its queues are searched.
+func (s *Service) register(key string) error {
+	if key == game.ModeClassic {
+		return nil
+	}
+	exists, err := s.store.Contains(s.ctx, s.rulesets, key)
+	if err != nil || exists {
+		return err
+	}
+	if err := s.store.Push(s.ctx, s.rulesets, key); err != nil {
+		// Registered concurrently
+		if exists, _ := s.store.Contains(s.ctx, s.rulesets, key); exists {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// prune forgets a ruleset once both of its queues are empty.
+func (s *Service) prune(key string) {
+	if key == game.ModeClassic || !s.empty(key) {
+		return
+	}
+	s.store.Remove(s.ctx, s.rulesets, key)
+	// A player may have queued in between
+	if !s.empty(key) {
+		s.register(key)
 	}
 }
 
-func (s *Service) AddToQueue(playerID string) error {
-	// Check if player is already in the set
-	exists, err := s.redisClient.SIsMember(s.ctx, s.setName, playerID).Result()
+func (s *Service) empty(key string) bool {
+	for _, base := range []string{s.mainQueue, s.startQueue} {
+		n, err := s.store.Len(s.ctx, queue(base, key))
+		if err != nil || n > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// queuedKey returns the key of the ruleset the player is queued for, or "".
+func (s *Service) queuedKey(base, playerID string) (string, error) {
+	keys, err := s.keys()
 	if err != nil {
-		return fmt.Errorf("failed to check queue set: %w", err)
+		return "", err
+	}
+	for _, key := range keys {
+		exists, err := s.store.Contains(s.ctx, queue(base, key), playerID)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			return key, nil
+		}
 	}
-	if exists {
+	return "", nil
+}
+
+func (s *Service) AddToQueue(playerID string, rules game.Rules) error {
+	rules = rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	// Check if player is already in the queue
+	queued, err := s.queuedKey(s.mainQueue, playerID)
+	if err != nil {
+		return fmt.Errorf("failed to check queue: %w", err)
+	}
+	if queued != "" {
 		return fmt.Errorf("player already in queue")
 	}
 
-	// Add to list and set
-	if err := s.redisClient.LPush(s.ctx, s.mainQueue, playerID).Err(); err != nil {
+	key := rules.Key()
+	if err := s.store.Push(s.ctx, queue(s.mainQueue, key), playerID); err != nil {
 		return fmt.Errorf("failed to add to queue: %w", err)
 	}
-	if err := s.redisClient.SAdd(s.ctx, s.setName, playerID).Err(); err != nil {
-		// Rollback list addition on set failure
-		s.redisClient.LRem(s.ctx, s.mainQueue, 0, playerID)
-		return fmt.Errorf("failed to add to queue set: %w", err)
+	if err := s.register(key); err != nil {
+		s.store.Remove(s.ctx, queue(s.mainQueue, key), playerID)
+		return fmt.Errorf("failed to register ruleset: %w", err)
 	}
 	return nil
 }
 
 func (s *Service) RemoveFromQueue(playerID string) error {
-	// Remove from list (first occurrence) and from set
-	if err := s.redisClient.LRem(s.ctx, s.mainQueue, 0, playerID).Err(); err != nil {
-		return fmt.Errorf("failed to remove from queue: %w", err)
+	key, err := s.queuedKey(s.mainQueue, playerID)
+	if err != nil {
+		return fmt.Errorf("failed to check queue: %w", err)
 	}
-	if err := s.redisClient.SRem(s.ctx, s.setName, playerID).Err(); err != nil {
-		return fmt.Errorf("failed to remove from set: %w", err)
+	if key == "" {
+		return nil
 	}
+	if _, err := s.store.Remove(s.ctx, queue(s.mainQueue, key), playerID); err != nil {
+		return fmt.Errorf("failed to remove from queue: %w", err)
+	}
+	s.prune(key)
 	return nil
 }
 
-func (s* Service) StartMatching(playerID string) error {
+func (s *Service) StartMatching(playerID string) error {
 	// check if player is in the matching_queue
-	exists, err := s.redisClient.SIsMember(s.ctx, s.setName, playerID).Result()
-	if err != nil || !exists {
+	key, err := s.queuedKey(s.mainQueue, playerID)
+	if err != nil || key == "" {
 		return fmt.Errorf("player not in queue")
 	}
-	// Remove from matchmaking_queue 
-	if err := s.RemoveFromQueue(playerID); err != nil {
-		return fmt.Errorf("failed to remove from queue: %w", err)
-	}
-	// Add to match_start_queue
-	if err := s.redisClient.LPush(s.ctx, s.startQueue, playerID).Err(); err != nil {
+	// Add to match_start_queue of the same ruleset before leaving the main
+	// queue, so the ruleset stays registered throughout
+	startQueue := queue(s.startQueue, key)
+	if err := s.store.Push(s.ctx, startQueue, playerID); err != nil {
 		return fmt.Errorf("failed to add to start queue: %w", err)
 	}
-	// Publish to matchmaking channel
-	if err := s.redisClient.Publish(s.ctx, s.channel, playerID).Err(); err != nil {
-		s.redisClient.LRem(s.ctx, s.startQueue, 0, playerID)
+	rating, err := s.rating(playerID)
+	if err != nil {
+		log.Printf("Matching %s at %d: %v", playerID, defaultRating, err)
+		rating = defaultRating
+	}
+	if err := s.store.Rate(s.ctx, startQueue, playerID, rating); err != nil {
+		s.store.Remove(s.ctx, startQueue, playerID)
+		return fmt.Errorf("failed to rate player: %w", err)
+	}
+	// Remove from matchmaking_queue
+	if _, err := s.store.Remove(s.ctx, queue(s.mainQueue, key), playerID); err != nil {
+		s.store.Remove(s.ctx, startQueue, playerID)
+		return fmt.Errorf("failed to remove from queue: %w", err)
+	}
+	// Publish the ruleset to the matchmaking channel
+	if err := s.store.Publish(s.ctx, s.channel, []byte(key)); err != nil {
+		s.store.Remove(s.ctx, startQueue, playerID)
+		s.prune(key)
 		return fmt.Errorf("failed to publish to channel: %w", err)
 	}
 	return nil
 }
+
 // TODO: Think about how to handle this
 func (s *Service) CancelMatching(playerID string) error {
-	if err := s.redisClient.LRem(s.ctx, s.startQueue, 0, playerID).Err(); err != nil {
-
+	key, err := s.queuedKey(s.startQueue, playerID)
+	if err != nil {
+		return fmt.Errorf("failed to check start queue: %w", err)
+	}
+	if key == "" {
+		return nil
+	}
+	if _, err := s.store.Remove(s.ctx, queue(s.startQueue, key), playerID); err != nil {
 		return fmt.Errorf("failed to remove from start queue: %w", err)
 	}
+	s.prune(key)
 	return nil
 }
 
-func (s *Service) MatchPlayers() (string, string, string, error) {
-	p1, err := s.redisClient.RPop(s.ctx, s.startQueue).Result()
+// MatchPlayers takes the best pair of players off the start queue of a
+// ruleset and puts them in a new room. It fails when no two players accept
+// each other's rating yet.
+func (s *Service) MatchPlayers(rules game.Rules) (string, string, string, error) {
+	rules = rules.WithDefaults()
+	key := rules.Key()
+	startQueue := queue(s.startQueue, key)
+	entries, err := s.store.ByRating(s.ctx, startQueue)
 	if err != nil {
+		return "", "", "", fmt.Errorf("failed to read start queue: %w", err)
+	}
+	now := time.Now()
+	a, b, ok := bestPair(entries, now)
+	if !ok {
 		return "", "", "", fmt.Errorf("not enough players")
 	}
-	p2, err := s.redisClient.RPop(s.ctx, s.startQueue).Result()
-	if err != nil {
-		s.redisClient.LPush(s.ctx, s.startQueue, p1)
+	if err := s.store.Take(s.ctx, startQueue, a.ID, b.ID); err != nil {
+		return "", "", "", fmt.Errorf("failed to take players: %w", err)
+	}
+	defer s.prune(key)
+
+	// A player who closed the app would be matched into a dead room
+	present := s.dropAbsent(a.ID, b.ID)
+	if len(present) < 2 {
+		for _, entry := range []store.QueueEntry{a, b} {
+			if len(present) == 1 && present[0] == entry.ID {
+				s.requeue(startQueue, entry)
+			}
+		}
 		return "", "", "", fmt.Errorf("not enough players")
 	}
 
-	roomID := generateRoomID(p1, p2)
+	roomID, err := game.NewRoomID()
+	if err != nil {
+		s.requeue(startQueue, a)
+		s.requeue(startQueue, b)
+		return "", "", "", err
+	}
 
-	// Store room-player mapping in Redis
-	roomKey := fmt.Sprintf("room:%s", roomID)
-	if err := s.redisClient.SAdd(s.ctx, roomKey, p1, p2).Err(); err != nil {
-		s.redisClient.LPush(s.ctx, s.startQueue, p1, p2)
+	// Store room-player mapping, expiring after an hour
+	if err := s.store.CreateRoom(s.ctx, roomID, []string{a.ID, b.ID}, 1*time.Hour); err != nil {
+		s.requeue(startQueue, a)
+		s.requeue(startQueue, b)
 		return "", "", "", fmt.Errorf("failed to store room mapping: %w", err)
 	}
-	// Set expiration for room mapping (e.g., 1 hour)
-	s.redisClient.Expire(s.ctx, roomKey, 1*time.Hour)
+	// Store the ruleset with the room; the game service starts the game in it
+	if err := s.saveState(game.GameState{RoomID: roomID, Rules: rules}); err != nil {
+		s.store.DeleteRoom(s.ctx, roomID)
+		s.requeue(startQueue, a)
+		s.requeue(startQueue, b)
+		return "", "", "", err
+	}
+
+	log.Printf("Paired %s (%d) with %s (%d) after %s and %s",
+		a.ID, a.Rating, b.ID, b.Rating, waited(a, now), waited(b, now))
+	s.recordMatch(key, a, b, now)
+	return a.ID, b.ID, roomID, nil
+}
+
+// requeue puts a taken entry back into the start queue with its rating.
+func (s *Service) requeue(startQueue string, entry store.QueueEntry) {
+	if err := s.store.Push(s.ctx, startQueue, entry.ID); err != nil {
+		log.Printf("Failed to requeue %s: %v", entry.ID, err)
+		return
+	}
+	s.store.Rate(s.ctx, startQueue, entry.ID, entry.Rating)
+}
+
+// saveState seeds the game state of a new room with its settings.
+func (s *Service) saveState(state game.GameState) error {
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal game state: %w", err)
+	}
+	err = s.store.UpdateRoom(s.ctx, state.RoomID, func(tx store.RoomTx) error {
+		tx.SaveGameState(stateJSON, 1*time.Hour)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store game rules: %w", err)
+	}
+	return nil
+}
+
+// PlayBot starts an unranked game between the player and a bot of the given
+// difficulty without going through the queue. The bot is run by whichever
+// server takes the room from bot.Queue. It returns the room and the bot's
+// player ID.
+func (s *Service) PlayBot(playerID, difficulty string, rules game.Rules) (string, string, error) {
+	if !bot.ValidDifficulty(difficulty) {
+		return "", "", fmt.Errorf("unknown difficulty %q", difficulty)
+	}
+	rules = rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		return "", "", fmt.Errorf("invalid rules: %w", err)
+	}
+	roomID, err := s.store.FindRoomByPlayer(s.ctx, playerID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to look up room: %w", err)
+	}
+	if roomID != "" {
+		return "", "", fmt.Errorf("player already in room %s", roomID)
+	}
+	// Leave matchmaking; the player is about to be busy
+	if err := s.RemoveFromQueue(playerID); err != nil {
+		return "", "", err
+	}
+	if err := s.CancelMatching(playerID); err != nil {
+		return "", "", err
+	}
+
+	botID := bot.NewID(difficulty)
+	roomID, err = game.NewRoomID()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.store.CreateRoom(s.ctx, roomID, []string{playerID, botID}, 1*time.Hour); err != nil {
+		return "", "", fmt.Errorf("failed to store room mapping: %w", err)
+	}
+	if err := s.saveState(game.GameState{RoomID: roomID, Rules: rules, Unranked: true}); err != nil {
+		s.store.DeleteRoom(s.ctx, roomID)
+		return "", "", err
+	}
+	if err := s.store.Push(s.ctx, bot.Queue, roomID); err != nil {
+		s.store.DeleteRoom(s.ctx, roomID)
+		return "", "", fmt.Errorf("failed to queue bot: %w", err)
+	}
+	if err := s.store.Publish(s.ctx, bot.Channel, []byte(roomID)); err != nil {
+		s.store.Remove(s.ctx, bot.Queue, roomID)
+		s.store.DeleteRoom(s.ctx, roomID)
+		return "", "", fmt.Errorf("failed to publish to channel: %w", err)
+	}
 
-	return p1, p2, roomID, nil
+	log.Printf("Started %s bot %s against %s in room %s", difficulty, botID, playerID, roomID)
+	s.notifyMatch(MatchResult{Player1: playerID, Player2: botID, RoomID: roomID, Rules: rules, Unranked: true})
+	return roomID, botID, nil
 }
 
+// RunMatchmaker matches players whenever someone starts matching, and every
+// matchInterval for the players whose rating windows have since widened.
 func (s *Service) RunMatchmaker(matchChan chan MatchResult) {
-	pubsub := s.redisClient.Subscribe(s.ctx, s.channel)
-	defer pubsub.Close()
+	sub := s.store.Subscribe(s.ctx, s.channel)
+	defer sub.Close()
+	ticker := time.NewTicker(matchInterval)
+	defer ticker.Stop()
 
 	for {
-		_, err := pubsub.ReceiveMessage(s.ctx)
-		if err != nil {
-			log.Printf("Pub/sub error: %v", err)
-			continue
+		select {
+		case payload, ok := <-sub.Messages():
+			if !ok {
+				log.Printf("Matchmaking subscription closed")
+				return
+			}
+			// The payload is the key of the ruleset a player started matching in
+			rules, err := engine.ParseRulesKey(string(payload))
+			if err != nil {
+				log.Printf("Ignoring matchmaking message %q: %v", payload, err)
+				continue
+			}
+			s.matchAll(rules, matchChan)
+		case <-ticker.C:
+			keys, err := s.keys()
+			if err != nil {
+				log.Printf("Failed to list rulesets: %v", err)
+				continue
+			}
+			for _, key := range keys {
+				if rules, err := engine.ParseRulesKey(key); err == nil {
+					s.matchAll(rules, matchChan)
+				}
+			}
 		}
+	}
+}
 
+// matchAll pairs players of a ruleset until no acceptable pair is left.
+func (s *Service) matchAll(rules game.Rules, matchChan chan MatchResult) {
+	for {
 		// Check if there are enough players
-		length, err := s.redisClient.LLen(s.ctx, s.startQueue).Result()
+		length, err := s.store.Len(s.ctx, queue(s.startQueue, rules.Key()))
 		if err != nil || length < 2 {
-			continue
+			return
 		}
 
 		// Attempt to match players
-		p1, p2, roomID, err := s.MatchPlayers()
+		p1, p2, roomID, err := s.MatchPlayers(rules)
 		if err != nil {
-			continue
+			return
 		}
 
 		matchChan <- MatchResult{
 			Player1: p1,
 			Player2: p2,
 			RoomID:  roomID,
+			Rules:   rules,
+		}
+	}
+}
+
+// PublishMatches sends a match_found notification to both players of every
+// result received on matchChan.
+func (s *Service) PublishMatches(matchChan chan MatchResult) {
+	for result := range matchChan {
+		log.Printf("Matched players %s and %s in room %s (%s)", result.Player1, result.Player2, result.RoomID, result.Rules.Key())
+		s.notifyMatch(result)
+	}
+}
+
+// notifyMatch sends a match_found notification to the players of a match;
+// bots are told through bot.Queue instead.
+func (s *Service) notifyMatch(result MatchResult) {
+	for _, player := range []string{result.Player1, result.Player2} {
+		if _, isBot := bot.Difficulty(player); isBot {
+			continue
+		}
+		opponent := result.Player2
+		if player == result.Player2 {
+			opponent = result.Player1
+		}
+		notification := game.MatchFound{
+			Type:     "match_found",
+			RoomID:   result.RoomID,
+			Player:   player,
+			Opponent: opponent,
+			Mode:     result.Rules.Mode,
+			Rules:    result.Rules,
+			Unranked: result.Unranked,
+		}
+		if err := s.notifier.Send(player, notification); err != nil {
+			log.Printf("Failed to send notification to %s: %v", player, err)
 		}
 	}
 }
 
 func (s *Service) GetMatchStatus(playerID string) (string, string, error) {
 	// Check if player is in match_start_queue
-	length, err := s.redisClient.LLen(s.ctx, s.startQueue).Result()
+	waiting, err := s.queuedKey(s.startQueue, playerID)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to check start queue: %w", err)
 	}
-	for i := int64(0); i < length; i++ {
-		player, err := s.redisClient.LIndex(s.ctx, s.startQueue, i).Result()
-		if err == nil && player == playerID {
-			return "waiting", "", nil
-		}
+	if waiting != "" {
+		return "waiting", "", nil
 	}
 
 	// Check if player is in a room
-	keys, err := s.redisClient.Keys(s.ctx, "room:*").Result()
+	roomID, err := s.store.FindRoomByPlayer(s.ctx, playerID)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to scan rooms: %w", err)
+		return "", "", fmt.Errorf("failed to look up room: %w", err)
 	}
-	for _, key := range keys {
-		exists, err := s.redisClient.SIsMember(s.ctx, key, playerID).Result()
-		if err == nil && exists {
-			roomID := key[len("room:"):]
-			return "matched", roomID, nil
-		}
+	if roomID != "" {
+		return "matched", roomID, nil
 	}
 
 	// Check if player is in matchmaking_queue
-	exists, err := s.redisClient.SIsMember(s.ctx, s.setName, playerID).Result()
-	if err == nil && exists {
+	queued, err := s.queuedKey(s.mainQueue, playerID)
+	if err == nil && queued != "" {
 		return "in_queue", "", nil
 	}
 
 	return "not_found", "", nil
 }
 
+// QueueLength returns the number of players queued over all rulesets.
 func (s *Service) QueueLength() (int64, error) {
-	return s.redisClient.LLen(s.ctx, s.mainQueue).Result()
-}
-
-func generateRoomID(player1, player2 string) string {
-	hash := sha1.Sum([]byte(player1 + ":" + player2))
-	return hex.EncodeToString(hash[:])
+	keys, err := s.keys()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, key := range keys {
+		n, err := s.store.Len(s.ctx, queue(s.mainQueue, key))
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
 }
@@ -0,0 +1,109 @@
+// Package notify delivers notifications to the general connections of
+// players. Every notification is kept in the player's inbox until the client
+// acknowledges it, so a player who was not connected, or whose connection
+// was busy, gets it on their next connection.
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/bot"
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+// Channel carries every notification to every server; each forwards those
+// of the players connected to it.
+const Channel = "notifications"
+
+// An inbox keeps the last inboxSize notifications for inboxTTL after the
+// last one.
+const (
+	inboxSize = 100
+	inboxTTL  = 24 * time.Hour
+)
+
+// Store is what notifications need from the store.
+type Store interface {
+	store.InboxStore
+	store.PubSub
+}
+
+type Service struct {
+	store Store
+	ctx   context.Context
+}
+
+func NewService(st Store) *Service {
+	return &Service{
+		store: st,
+		ctx:   context.Background(),
+	}
+}
+
+// Send keeps a notification in the player's inbox and publishes it. The
+// notification must marshal to a JSON object; clients get it with its inbox
+// ID added as "id", which they acknowledge it with. Bots have no inbox.
+func (s *Service) Send(playerID string, notification interface{}) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	if _, isBot := bot.Difficulty(playerID); !isBot {
+		id, err := s.store.AppendInbox(s.ctx, playerID, payload, inboxSize, inboxTTL)
+		if err != nil {
+			return fmt.Errorf("failed to store notification: %v", err)
+		}
+		if payload, err = withID(id, payload); err != nil {
+			return err
+		}
+	}
+	if err := s.store.Publish(s.ctx, Channel, payload); err != nil {
+		// It is still delivered from the inbox on the next connection
+		log.Printf("Failed to publish notification for %s: %v", playerID, err)
+	}
+	return nil
+}
+
+// Unread returns the notifications the player has not acknowledged, oldest
+// first, as they are sent to clients.
+func (s *Service) Unread(playerID string) ([]json.RawMessage, error) {
+	entries, err := s.store.Inbox(s.ctx, playerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inbox: %v", err)
+	}
+	notifications := make([]json.RawMessage, 0, len(entries))
+	for _, e := range entries {
+		n, err := withID(e.ID, e.Notification)
+		if err != nil {
+			log.Printf("Skipping notification %s of %s: %v", e.ID, playerID, err)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+// Ack removes notifications the client has handled from the inbox.
+func (s *Service) Ack(playerID string, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := s.store.AckInbox(s.ctx, playerID, ids...); err != nil {
+		return fmt.Errorf("failed to acknowledge notifications: %v", err)
+	}
+	return nil
+}
+
+// withID adds the inbox ID to a notification.
+func withID(id string, notification []byte) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(notification, &fields); err != nil {
+		return nil, fmt.Errorf("notification is not a JSON object: %v", err)
+	}
+	fields["id"], _ = json.Marshal(id)
+	return json.Marshal(fields)
+}
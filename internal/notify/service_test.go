@@ -0,0 +1,126 @@
+package notify
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/store/storetest"
+)
+
+type testNotification struct {
+	Type string `json:"type"`
+	N    int    `json:"n"`
+	ID   string `json:"id"`
+}
+
+// unread returns the inbox of a player, decoded.
+func unread(t *testing.T, s *Service, playerID string) []testNotification {
+	t.Helper()
+	raw, err := s.Unread(playerID)
+	if err != nil {
+		t.Fatalf("Unread: %v", err)
+	}
+	out := make([]testNotification, len(raw))
+	for i, r := range raw {
+		if err := json.Unmarshal(r, &out[i]); err != nil {
+			t.Fatalf("notification %s: %v", r, err)
+		}
+	}
+	return out
+}
+
+func TestInboxDelivery(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(st)
+			player := fmt.Sprintf("test-player-%d", time.Now().UnixNano())
+			sub := st.Subscribe(s.ctx, Channel)
+			defer sub.Close()
+
+			for i := 1; i <= 3; i++ {
+				if err := s.Send(player, testNotification{Type: "test", N: i}); err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+			}
+
+			inbox := unread(t, s, player)
+			if len(inbox) != 3 {
+				t.Fatalf("inbox = %+v, want 3 notifications", inbox)
+			}
+			for i, n := range inbox {
+				if n.N != i+1 || n.ID == "" {
+					t.Errorf("notification %d = %+v, want n %d with an id", i, n, i+1)
+				}
+			}
+
+			// Connected clients get the notification with the same id
+			select {
+			case msg := <-sub.Messages():
+				var n testNotification
+				if err := json.Unmarshal(msg, &n); err != nil || n.ID != inbox[0].ID {
+					t.Errorf("published %s, want id %s", msg, inbox[0].ID)
+				}
+			case <-time.After(time.Second):
+				t.Error("notification not published")
+			}
+		})
+	}
+}
+
+func TestInboxAck(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(st)
+			player := fmt.Sprintf("test-player-%d", time.Now().UnixNano())
+			for i := 1; i <= 3; i++ {
+				if err := s.Send(player, testNotification{Type: "test", N: i}); err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+			}
+			inbox := unread(t, s, player)
+
+			// Unknown and malformed IDs are ignored
+			if err := s.Ack(player, inbox[1].ID, "0-1", "not-an-id"); err != nil {
+				t.Fatalf("Ack: %v", err)
+			}
+			left := unread(t, s, player)
+			if len(left) != 2 || left[0].N != 1 || left[1].N != 3 {
+				t.Errorf("inbox after ack = %+v, want 1 and 3", left)
+			}
+
+			if err := s.Ack(player, inbox[0].ID, inbox[2].ID); err != nil {
+				t.Fatalf("Ack: %v", err)
+			}
+			if left := unread(t, s, player); len(left) != 0 {
+				t.Errorf("inbox after acking all = %+v, want empty", left)
+			}
+		})
+	}
+}
+
+func TestInboxTrimmed(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := NewService(st)
+			player := fmt.Sprintf("test-player-%d", time.Now().UnixNano())
+			const extra = 5
+			for i := 1; i <= inboxSize+extra; i++ {
+				if err := s.Send(player, testNotification{Type: "test", N: i}); err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+			}
+
+			// Only the newest inboxSize are kept
+			inbox := unread(t, s, player)
+			if len(inbox) != inboxSize {
+				t.Fatalf("inbox holds %d notifications, want %d", len(inbox), inboxSize)
+			}
+			if inbox[0].N != extra+1 || inbox[inboxSize-1].N != inboxSize+extra {
+				t.Errorf("inbox runs from %d to %d, want %d to %d",
+					inbox[0].N, inbox[inboxSize-1].N, extra+1, inboxSize+extra)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package protocol
+
+import "encoding/json"
+
+// Dispatcher routes the messages of a connection of type C to the handler
+// registered for their type.
+type Dispatcher[C any] struct {
+	handlers map[string]func(c C, env Envelope) error
+}
+
+func NewDispatcher[C any]() *Dispatcher[C] {
+	return &Dispatcher[C]{handlers: make(map[string]func(c C, env Envelope) error)}
+}
+
+// On registers fn for messages of msgType, with their payload decoded into
+// an M.
+func On[C, M any](d *Dispatcher[C], msgType string, fn func(c C, msg M) error) {
+	d.handlers[msgType] = func(c C, env Envelope) error {
+		var msg M
+		if len(env.Payload) > 0 {
+			if err := json.Unmarshal(env.Payload, &msg); err != nil {
+				return Errorf(CodeMalformed, "invalid %s payload: %v", msgType, err)
+			}
+		}
+		return fn(c, msg)
+	}
+}
+
+// Dispatch hands env to its handler and returns the handler's error, or a
+// CodeUnknownType error if there is none.
+func (d *Dispatcher[C]) Dispatch(c C, env Envelope) error {
+	handler, ok := d.handlers[env.Type]
+	if !ok {
+		return Errorf(CodeUnknownType, "unknown message type %q", env.Type)
+	}
+	return handler(c, env)
+}
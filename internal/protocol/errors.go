@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/game"
+)
+
+// Codes of the error replies.
+const (
+	// CodeMalformed is for frames that cannot be read or messages whose
+	// payload does not fit their type.
+	CodeMalformed = "malformed"
+	// CodeUnknownType is for messages of a type the connection does not
+	// handle.
+	CodeUnknownType = "unknown_type"
+	// CodeUnsupportedVersion is for envelopes of another protocol version
+	// than the one agreed on.
+	CodeUnsupportedVersion = "unsupported_version"
+	// CodeNotYourTurn is for moves made while the opponent is to move.
+	CodeNotYourTurn = "not_your_turn"
+	// CodeInvalidMove is for shots the rules do not allow: off the board,
+	// at a cell fired at before, or of the wrong kind or number.
+	CodeInvalidMove = "invalid_move"
+	// CodeNotStarted is for moves made before both fleets are placed.
+	CodeNotStarted = "not_started"
+	// CodeGameOver is for moves made after the game ended.
+	CodeGameOver = "game_over"
+	// CodeRejected is for other well-formed messages the server refused,
+	// such as accepting a draw that was not offered.
+	CodeRejected = "rejected"
+	// CodeInternal is for messages the server failed to handle. Its reply
+	// does not say why; the cause is only logged.
+	CodeInternal = "internal"
+)
+
+// knownErrors are the errors of the game whose messages may be shown to the
+// player, with the code each is reported with.
+var knownErrors = []struct {
+	err  error
+	code string
+}{
+	{engine.ErrNotYourTurn, CodeNotYourTurn},
+	{engine.ErrInvalidCoordinate, CodeInvalidMove},
+	{engine.ErrAlreadyAttacked, CodeInvalidMove},
+	{engine.ErrWrongMode, CodeInvalidMove},
+	{engine.ErrSalvoSize, CodeInvalidMove},
+	{engine.ErrNotReady, CodeNotStarted},
+	{engine.ErrNotStarted, CodeNotStarted},
+	{engine.ErrGameOver, CodeGameOver},
+	{engine.ErrUnknownPlayer, CodeRejected},
+	{engine.ErrAlreadyPlaced, CodeRejected},
+	{game.ErrNoDrawOffer, CodeRejected},
+	{game.ErrNoRematch, CodeRejected},
+	{game.ErrNotWatchable, CodeRejected},
+}
+
+// internalMessage is sent for errors that are not known, so that what went
+// wrong inside the server is not shown to clients.
+const internalMessage = "the server could not handle the message"
+
+// Error is a message the server could not handle, with a code saying why.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func Errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorReply is the error message sent for err in reply to the message
+// with ID correlationID. An *Error and the known errors of the game are
+// reported with their code and message; any other error is CodeInternal
+// with a generic message.
+func ErrorReply(err error, correlationID string) ErrorMessage {
+	reply := ErrorMessage{
+		Type:          TypeError,
+		Code:          CodeInternal,
+		Message:       internalMessage,
+		CorrelationID: correlationID,
+	}
+	var perr *Error
+	if errors.As(err, &perr) {
+		reply.Code, reply.Message = perr.Code, perr.Message
+		return reply
+	}
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			reply.Code, reply.Message = known.code, err.Error()
+			break
+		}
+	}
+	return reply
+}
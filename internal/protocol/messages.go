@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/match"
+)
+
+// Types of the messages clients send.
+const (
+	// On a game connection
+	TypeAttack      = "attack"
+	TypeSalvo       = "salvo"
+	TypeChat        = "chat"
+	TypeResign      = "resign"
+	TypeOfferDraw   = "offer_draw"
+	TypeAcceptDraw  = "accept_draw"
+	TypeDeclineDraw = "decline_draw"
+	// On a game or general connection
+	TypeRematch        = "rematch"
+	TypeDeclineRematch = "decline_rematch"
+	// On a general connection
+	TypeAck = "ack"
+)
+
+// Types of the messages the server sends.
+const (
+	// On a game connection, to players and spectators
+	TypeAttackResult = "attack_result"
+	TypeShipSunk     = "ship_sunk"
+	TypeSalvoResult  = "salvo_result"
+	TypeTurn         = "turn"
+	TypeTurnTimeout  = "turn_timeout"
+	TypeGameOver     = "game_over"
+	TypeSpectators   = "spectators"
+	// On a game connection, to players only. Chat uses TypeChat.
+	TypeStateSync            = "state_sync"
+	TypeDrawOffered          = "draw_offered"
+	TypeDrawDeclined         = "draw_declined"
+	TypeOpponentDisconnected = "opponent_disconnected"
+	TypeOpponentReconnected  = "opponent_reconnected"
+	// On a game connection, to spectators only
+	TypeSpectating     = "spectating"
+	TypeSpectatorState = "spectator_state"
+	// On a general connection, as notifications
+	TypeMatchFound      = "match_found"
+	TypeGameStart       = "game_start"
+	TypeShipsPlaced     = "ships_placed"
+	TypeQueueTimeout    = "queue_timeout"
+	TypeLobbyUpdated    = "lobby_updated"
+	TypeLobbyClosed     = "lobby_closed"
+	TypeRematchOffered  = "rematch_offered"
+	TypeRematchDeclined = "rematch_declined"
+	// On any connection
+	TypeError = "error"
+)
+
+// Client messages. Resign and the draw messages have no payload.
+
+type Attack struct {
+	Coordinate string `json:"coordinate"`
+}
+
+type Salvo struct {
+	Coordinates []string `json:"coordinates"`
+}
+
+type Chat struct {
+	Message string `json:"message"`
+}
+
+type Resign struct{}
+
+type OfferDraw struct{}
+
+type AcceptDraw struct{}
+
+type DeclineDraw struct{}
+
+// Rematch asks for a rematch of the game in RoomID, which a game connection
+// leaves out. BestOf starts a series of that many games.
+type Rematch struct {
+	RoomID string `json:"roomId"`
+	BestOf int    `json:"bestOf"`
+}
+
+type DeclineRematch struct {
+	RoomID string `json:"roomId"`
+}
+
+// Ack acknowledges notifications by their IDs.
+type Ack struct {
+	IDs []string `json:"ids"`
+}
+
+// Server messages.
+
+type AttackResult struct {
+	Type       string `json:"type"`
+	Coordinate string `json:"coordinate"`
+	Result     string `json:"result"`
+	NextTurn   string `json:"nextTurn"`
+}
+
+type ShipSunk struct {
+	Type     string `json:"type"`
+	Ship     string `json:"ship"`
+	PlayerID string `json:"playerId"` // who sank it
+}
+
+type SalvoResult struct {
+	Type      string        `json:"type"`
+	PlayerID  string        `json:"playerId"`
+	Shots     []game.Attack `json:"shots"`
+	SunkShips []string      `json:"sunkShips"`
+	NextTurn  string        `json:"nextTurn"`
+}
+
+// Turn announces the player to move. For timed games it carries the
+// deadline and the remaining banks so clients can render a countdown.
+type Turn struct {
+	Type       string           `json:"type"`
+	PlayerID   string           `json:"playerId"`
+	SalvoShots int              `json:"salvoShots,omitempty"` // shots to fire in salvo mode
+	Deadline   int64            `json:"deadline,omitempty"`   // unix milliseconds
+	Banks      map[string]int64 `json:"banks,omitempty"`      // milliseconds
+}
+
+type TurnTimeout struct {
+	Type     string `json:"type"`
+	PlayerID string `json:"playerId"`
+	Action   string `json:"action"`
+}
+
+type GameOver struct {
+	Type   string                 `json:"type"`
+	Winner string                 `json:"winner"`
+	Loser  string                 `json:"loser"`
+	Reason string                 `json:"reason,omitempty"`
+	Draw   bool                   `json:"draw,omitempty"`
+	Series *game.Series           `json:"series,omitempty"` // score including this game
+	Fleets map[string][]game.Ship `json:"fleets,omitempty"` // revealed to everyone
+}
+
+// Spectators is how many spectators watch the room.
+type Spectators struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// StateSync is the player's view of the game, sent on every (re)connection.
+type StateSync struct {
+	Type              string `json:"type"`
+	OpponentConnected bool   `json:"opponentConnected"`
+	*game.Snapshot
+}
+
+type ChatMessage struct {
+	Type    string `json:"type"`
+	Sender  string `json:"sender"`
+	Message string `json:"message"`
+}
+
+// PlayerEvent is a message about what a player did, of type
+// TypeDrawOffered, TypeDrawDeclined or TypeOpponentReconnected.
+type PlayerEvent struct {
+	Type     string `json:"type"`
+	PlayerID string `json:"playerId"`
+}
+
+type OpponentDisconnected struct {
+	Type         string `json:"type"`
+	PlayerID     string `json:"playerId"`
+	Message      string `json:"message"`
+	GraceSeconds int    `json:"graceSeconds"`
+	Deadline     int64  `json:"deadline"` // unix milliseconds
+}
+
+type Spectating struct {
+	Type    string `json:"type"`
+	RoomID  string `json:"roomId"`
+	DelayMs int64  `json:"delayMs"` // how far behind the game the spectator is
+}
+
+type SpectatorState struct {
+	Type  string              `json:"type"`
+	State *game.SpectatorView `json:"state"`
+}
+
+type GameStart struct {
+	Type       string     `json:"type"`
+	RoomID     string     `json:"roomId"`
+	Player     string     `json:"player"`
+	Mode       string     `json:"mode"`
+	Rules      game.Rules `json:"rules"`
+	Turn       string     `json:"turn"`
+	SalvoShots int        `json:"salvoShots,omitempty"`
+	Deadline   int64      `json:"deadline,omitempty"` // unix milliseconds
+}
+
+// ErrorMessage reports a message the server could not handle.
+type ErrorMessage struct {
+	Type          string `json:"type"`
+	Code          string `json:"code"`
+	Message       string `json:"message"`
+	CorrelationID string `json:"correlationId,omitempty"`
+}
+
+// Notifications the game and match services build themselves. Every
+// notification names the player it is for.
+type (
+	MatchFound    = game.MatchFound
+	ShipsPlaced   = game.ShipsPlaced
+	RematchNotice = game.RematchNotice
+	QueueTimeout  = match.QueueTimeout
+	LobbyNotice   = match.LobbyNotice
+)
+
+// Notification is what every notification starts with.
+type Notification struct {
+	Type   string `json:"type"`
+	Player string `json:"player"`
+	RoomID string `json:"roomId"`
+}
@@ -0,0 +1,98 @@
+// Package protocol defines the messages exchanged over the game and general
+// WebSocket connections and how they are framed.
+//
+// Clients that negotiate Subprotocol exchange Envelopes. Older clients that
+// do not, version 0, send and receive the messages themselves as flat JSON
+// objects with their type in a "type" field. That flat form is also how
+// messages travel inside the server; they are wrapped in an envelope on the
+// way out to a client that wants one.
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Version is the current protocol version.
+const Version = 1
+
+// Subprotocol is the WebSocket subprotocol a client offers to speak Version.
+const Subprotocol = "battleship.v1"
+
+// VersionOf returns the protocol version of a connection from the
+// subprotocol agreed on it.
+func VersionOf(subprotocol string) int {
+	if subprotocol == Subprotocol {
+		return Version
+	}
+	return 0
+}
+
+// Envelope frames every message of a versioned connection. ID identifies a
+// message; a reply carries the ID of the request in CorrelationID.
+// Notifications are acknowledged by their ID.
+type Envelope struct {
+	Type          string          `json:"type"`
+	Version       int             `json:"version"`
+	ID            string          `json:"id,omitempty"`
+	CorrelationID string          `json:"correlationId,omitempty"`
+	Payload       json.RawMessage `json:"payload,omitempty"`
+}
+
+// Decode reads a frame received from a client speaking version.
+//
+// Version 0 frames are flat messages, so the whole frame is the payload.
+func Decode(frame []byte, version int) (Envelope, error) {
+	if version == 0 {
+		var header struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}
+		if err := json.Unmarshal(frame, &header); err != nil {
+			return Envelope{}, Errorf(CodeMalformed, "invalid message: %v", err)
+		}
+		if header.Type == "" {
+			return Envelope{}, Errorf(CodeMalformed, "message has no type")
+		}
+		return Envelope{Type: header.Type, ID: header.ID, Payload: frame}, nil
+	}
+
+	var env Envelope
+	if err := json.Unmarshal(frame, &env); err != nil {
+		return Envelope{}, Errorf(CodeMalformed, "invalid envelope: %v", err)
+	}
+	if env.Type == "" {
+		return env, Errorf(CodeMalformed, "message has no type")
+	}
+	// The version was agreed on when connecting, so it may be left out
+	if env.Version != 0 && env.Version != version {
+		return env, Errorf(CodeUnsupportedVersion, "version %d is not supported on this connection", env.Version)
+	}
+	return env, nil
+}
+
+// Encode turns a flat message into a frame for a client speaking version.
+func Encode(message []byte, version int) ([]byte, error) {
+	if version == 0 {
+		return message, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		return nil, fmt.Errorf("message is not a JSON object: %v", err)
+	}
+	env := Envelope{Version: version}
+	for name, dst := range map[string]*string{"type": &env.Type, "id": &env.ID, "correlationId": &env.CorrelationID} {
+		if raw, ok := fields[name]; ok {
+			json.Unmarshal(raw, dst)
+			delete(fields, name)
+		}
+	}
+	if len(fields) > 0 {
+		payload, err := json.Marshal(fields)
+		if err != nil {
+			return nil, err
+		}
+		env.Payload = payload
+	}
+	return json.Marshal(env)
+}
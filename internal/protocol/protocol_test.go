@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+)
+
+func TestDecodeMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		frame   string
+		version int
+	}{
+		{"plain text", "hello", 0},
+		{"no type", `{"coordinate":"A1"}`, 0},
+		{"plain text envelope", "hello", Version},
+		{"envelope without type", `{"version":1}`, Version},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.frame), tt.version)
+			var perr *Error
+			if !errors.As(err, &perr) || perr.Code != CodeMalformed {
+				t.Errorf("Decode(%q) error = %v, want %s", tt.frame, err, CodeMalformed)
+			}
+		})
+	}
+}
+
+func TestErrorReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		code    string
+		message string
+	}{
+		{"protocol", Errorf(CodeUnknownType, "unknown message type %q", "x"), CodeUnknownType, `unknown message type "x"`},
+		{"engine", engine.ErrNotYourTurn, CodeNotYourTurn, "not your turn"},
+		{"wrapped engine", fmt.Errorf("%w: A1", engine.ErrAlreadyAttacked), CodeInvalidMove, "coordinate already attacked: A1"},
+		{"internal", errors.New("failed to get game state: dial tcp 10.0.0.3:6379: connection refused"), CodeInternal, internalMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := ErrorReply(tt.err, "m1")
+			if reply.Code != tt.code || reply.Message != tt.message || reply.CorrelationID != "m1" {
+				t.Errorf("ErrorReply = %+v, want %s %q", reply, tt.code, tt.message)
+			}
+		})
+	}
+}
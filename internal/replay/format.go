@@ -0,0 +1,100 @@
+// Package replay defines the ordered event log of a game, the versioned
+// replay file built from it, and a validator that re-simulates a replay
+// against the rules engine.
+package replay
+
+import "github.com/krishanu7/battleship-backend/internal/engine"
+
+// FormatVersion is the version written to replay files. Import rejects any
+// other version.
+const FormatVersion = 1
+
+const (
+	EventPlacement    = "placement"
+	EventGameStart    = "game_start"
+	EventAttackResult = "attack_result"
+	EventSalvo        = "salvo"
+	EventShipSunk     = "ship_sunk"
+	EventTurnSkipped  = "turn_skipped"
+	EventForfeit      = "forfeit"
+	EventGameOver     = "game_over"
+	EventDraw         = "draw"
+)
+
+// Event is one entry of a game's event log. Which fields are set depends on
+// Type:
+//
+//	placement      Player, Ships
+//	game_start     Player (first to move)
+//	attack_result  Player, Coordinate, Result
+//	salvo          Player, Shots
+//	ship_sunk      Player (shooter), Target, Ship
+//	turn_skipped   Player
+//	forfeit        Player (loser), Reason
+//	game_over      Winner, Loser
+//	draw           (no fields, ends the game)
+type Event struct {
+	Seq        int           `json:"seq,omitempty"`
+	Type       string        `json:"type"`
+	At         int64         `json:"at"` // unix milliseconds
+	Player     string        `json:"player,omitempty"`
+	Ships      []engine.Ship `json:"ships,omitempty"`
+	Coordinate string        `json:"coordinate,omitempty"`
+	Result     string        `json:"result,omitempty"`
+	Shots      []engine.Shot `json:"shots,omitempty"`
+	Target     string        `json:"target,omitempty"`
+	Ship       string        `json:"ship,omitempty"`
+	Winner     string        `json:"winner,omitempty"`
+	Loser      string        `json:"loser,omitempty"`
+	Reason     string        `json:"reason,omitempty"`
+}
+
+// Replay is the downloadable replay file of one game.
+
+type Replay struct {
+	Version   int           `json:"version"`
+	MatchID   int64         `json:"matchId,omitempty"`
+	RoomID    string        `json:"roomId,omitempty"`
+	Mode      string        `json:"mode"`
+	Rules     *engine.Rules `json:"rules,omitempty"`
+	Players   [2]string     `json:"players"`
+	StartedAt int64         `json:"startedAt"` // unix milliseconds
+	EndedAt   int64         `json:"endedAt"`
+	Events    []Event       `json:"events"`
+}
+
+// Number assigns sequence numbers to events in log order, starting at 1.
+func Number(events []Event) {
+	for i := range events {
+		events[i].Seq = i + 1
+	}
+}
+
+// FromEngine converts the events of one engine move into log events. Events
+// that the log does not record, such as turn changes, are skipped.
+func FromEngine(events []engine.Event, at int64) []Event {
+	var out []Event
+	for _, ev := range events {
+		switch ev := ev.(type) {
+		case engine.Placed:
+			// Placements are logged with their ships by the caller
+		case engine.Hit:
+			out = append(out, Event{Type: EventAttackResult, At: at, Player: ev.Player, Coordinate: ev.Coordinate, Result: string(engine.ResultHit)})
+		case engine.Miss:
+			out = append(out, Event{Type: EventAttackResult, At: at, Player: ev.Player, Coordinate: ev.Coordinate, Result: string(engine.ResultMiss)})
+		case engine.Salvo:
+			out = append(out, Event{Type: EventSalvo, At: at, Player: ev.Player, Shots: ev.Shots})
+		case engine.Sunk:
+			out = append(out, Event{Type: EventShipSunk, At: at, Player: ev.Player, Target: ev.Target, Ship: string(ev.Ship)})
+		case engine.Skipped:
+			out = append(out, Event{Type: EventTurnSkipped, At: at, Player: ev.Player})
+		case engine.Forfeited:
+			out = append(out, Event{Type: EventForfeit, At: at, Player: ev.Player, Reason: ev.Reason})
+		case engine.Won:
+			out = append(out, Event{Type: EventGameOver, At: at, Winner: ev.Winner, Loser: ev.Loser})
+		case engine.Drawn:
+			out = append(out, Event{Type: EventDraw, At: at})
+		}
+	}
+	return out
+}
@@ -0,0 +1,146 @@
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/krishanu7/battleship-backend/internal/engine"
+	"github.com/krishanu7/battleship-backend/internal/history"
+)
+
+// maxImportSize bounds uploaded replay files.
+const maxImportSize = 1 << 20
+
+type Handler struct {
+	history *history.Service
+}
+
+func NewHandler(hist *history.Service) *Handler {
+	return &Handler{
+		history: hist,
+	}
+}
+
+// FromMatch builds the replay file of a recorded match.
+func FromMatch(m *history.Match) (*Replay, error) {
+	r := &Replay{
+		Version:   FormatVersion,
+		MatchID:   m.ID,
+		RoomID:    m.RoomID,
+		Mode:      m.Mode,
+		Players:   [2]string{m.Player1, m.Player2},
+		StartedAt: m.StartedAt.UnixMilli(),
+		EndedAt:   m.EndedAt.UnixMilli(),
+		Events:    []Event{},
+	}
+	if len(m.Rules) > 0 {
+		r.Rules = &engine.Rules{}
+		if err := json.Unmarshal(m.Rules, r.Rules); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rules of match %d: %v", m.ID, err)
+		}
+	}
+	if len(m.Events) > 0 {
+		if err := json.Unmarshal(m.Events, &r.Events); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal events of match %d: %v", m.ID, err)
+		}
+	}
+	Number(r.Events)
+	return r, nil
+}
+
+func (h *Handler) loadReplay(w http.ResponseWriter, r *http.Request) (*Replay, bool) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid match id", http.StatusBadRequest)
+		return nil, false
+	}
+	match, err := h.history.Get(id)
+	if errors.Is(err, history.ErrMatchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	} else if err != nil {
+		log.Printf("Failed to get match %d: %v", id, err)
+		http.Error(w, "failed to get match", http.StatusInternalServerError)
+		return nil, false
+	}
+	rep, err := FromMatch(match)
+	if err != nil {
+		log.Printf("Failed to build replay: %v", err)
+		http.Error(w, "failed to build replay", http.StatusInternalServerError)
+		return nil, false
+	}
+	return rep, true
+}
+
+// Events returns the ordered event stream of a finished match.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	rep, ok := h.loadReplay(w, r)
+	if !ok {
+		return
+	}
+	resp := struct {
+		MatchID int64   `json:"matchId"`
+		Events  []Event `json:"events"`
+	}{
+		MatchID: rep.MatchID,
+		Events:  rep.Events,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Download serves the replay file of a finished match as an attachment.
+func (h *Handler) Download(w http.ResponseWriter, r *http.Request) {
+	rep, ok := h.loadReplay(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="match-%d.replay.json"`, rep.MatchID))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+}
+
+// Import accepts a replay file and re-simulates it against the rules.
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) {
+	var rep Replay
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxImportSize)).Decode(&rep); err != nil {
+		http.Error(w, "invalid replay file", http.StatusBadRequest)
+		return
+	}
+
+	g, err := Validate(&rep)
+	w.Header().Set("Content-Type", "application/json")
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"valid": false,
+			"error": verr,
+		})
+		return
+	}
+
+	resp := struct {
+		Valid   bool      `json:"valid"`
+		Version int       `json:"version"`
+		Players [2]string `json:"players"`
+		Events  int       `json:"events"`
+		Winner  string    `json:"winner,omitempty"`
+		Drawn   bool      `json:"drawn,omitempty"`
+	}{
+		Valid:   true,
+		Version: rep.Version,
+		Players: rep.Players,
+		Events:  len(rep.Events),
+		Winner:  g.Winner,
+		Drawn:   g.Drawn,
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,184 @@
+package replay
+
+import (
+	"fmt"
+
+	"github.com/krishanu7/battleship-backend/internal/engine"
+)
+
+// ValidationError points at the first event that is inconsistent with the
+// rules. Seq is 0 for problems with the file as a whole.
+type ValidationError struct {
+	Seq    int    `json:"seq"`
+	Reason string `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Seq == 0 {
+		return "invalid replay: " + e.Reason
+	}
+	return fmt.Sprintf("invalid replay at event %d: %s", e.Seq, e.Reason)
+}
+
+// Validate re-simulates the replay with the rules engine. Every recorded
+// result, sunk ship and game over must be exactly what the engine produces,
+// and nothing the engine produces may be missing from the log. It returns
+// the final game on success.
+func Validate(r *Replay) (engine.Game, error) {
+	if r.Version != FormatVersion {
+		return engine.Game{}, &ValidationError{Reason: fmt.Sprintf("unsupported version %d", r.Version)}
+	}
+	if r.Players[0] == "" || r.Players[1] == "" || r.Players[0] == r.Players[1] {
+		return engine.Game{}, &ValidationError{Reason: "replay must name two distinct players"}
+	}
+
+	// Replays of classic-size games may carry only the mode
+	rules := engine.Rules{Mode: r.Mode}
+	if r.Rules != nil {
+		rules = *r.Rules
+	}
+	rules = rules.WithDefaults()
+	if err := rules.Validate(); err != nil {
+		return engine.Game{}, &ValidationError{Reason: err.Error()}
+	}
+	g := engine.NewWithRules(rules, r.Players[0], r.Players[1])
+	// Derived events produced by the last shot that the log has yet to show
+	var pending []engine.Event
+	fail := func(seq int, format string, args ...interface{}) (engine.Game, error) {
+		return engine.Game{}, &ValidationError{Seq: seq, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	for i, e := range r.Events {
+		seq := e.Seq
+		if seq == 0 {
+			seq = i + 1
+		}
+		if e.Type != EventShipSunk && e.Type != EventGameOver && len(pending) > 0 {
+			return fail(seq, "missing %s before %s", describe(pending[0]), e.Type)
+		}
+
+		var err error
+		switch e.Type {
+		case EventPlacement:
+			g, _, err = g.Place(e.Player, e.Ships)
+			if err != nil {
+				return fail(seq, "placement by %s rejected: %v", e.Player, err)
+			}
+
+		case EventGameStart:
+			g, _, err = g.Start(e.Player)
+			if err != nil {
+				return fail(seq, "game start rejected: %v", err)
+			}
+
+		case EventAttackResult:
+			var events []engine.Event
+			g, events, err = g.Fire(e.Player, e.Coordinate)
+			if err != nil {
+				return fail(seq, "attack by %s at %s rejected: %v", e.Player, e.Coordinate, err)
+			}
+			pending = pending[:0]
+			for _, ev := range events {
+				switch ev := ev.(type) {
+				case engine.Hit:
+					if e.Result != string(engine.ResultHit) {
+						return fail(seq, "%s is a hit, replay says %q", e.Coordinate, e.Result)
+					}
+				case engine.Miss:
+					if e.Result != string(engine.ResultMiss) {
+						return fail(seq, "%s is a miss, replay says %q", e.Coordinate, e.Result)
+					}
+				case engine.Sunk, engine.Won:
+					pending = append(pending, ev)
+				}
+			}
+
+		case EventSalvo:
+			coordinates := make([]string, len(e.Shots))
+			for i, shot := range e.Shots {
+				coordinates[i] = shot.Coordinate
+			}
+			var events []engine.Event
+			g, events, err = g.FireSalvo(e.Player, coordinates)
+			if err != nil {
+				return fail(seq, "salvo by %s rejected: %v", e.Player, err)
+			}
+			pending = pending[:0]
+			for _, ev := range events {
+				switch ev := ev.(type) {
+				case engine.Salvo:
+					for i, shot := range ev.Shots {
+						if e.Shots[i].Result != shot.Result {
+							return fail(seq, "%s is a %s, replay says %q", shot.Coordinate, shot.Result, e.Shots[i].Result)
+						}
+					}
+				case engine.Sunk, engine.Won:
+					pending = append(pending, ev)
+				}
+			}
+
+		case EventTurnSkipped:
+			g, _, err = g.Skip(e.Player)
+			if err != nil {
+				return fail(seq, "skip by %s rejected: %v", e.Player, err)
+			}
+
+		case EventForfeit:
+			var events []engine.Event
+			g, events, err = g.Forfeit(e.Player, e.Reason)
+			if err != nil {
+				return fail(seq, "forfeit by %s rejected: %v", e.Player, err)
+			}
+			pending = pending[:0]
+			for _, ev := range events {
+				if won, ok := ev.(engine.Won); ok {
+					pending = append(pending, won)
+				}
+			}
+
+		case EventDraw:
+			g, _, err = g.Draw()
+			if err != nil {
+				return fail(seq, "draw rejected: %v", err)
+			}
+
+		case EventShipSunk:
+			if len(pending) == 0 {
+				return fail(seq, "no ship was sunk by the previous shot")
+			}
+			sunk, ok := pending[0].(engine.Sunk)
+			if !ok || sunk.Player != e.Player || string(sunk.Ship) != e.Ship {
+				return fail(seq, "expected %s, replay has %s sinking %s", describe(pending[0]), e.Player, e.Ship)
+			}
+			pending = pending[1:]
+
+		case EventGameOver:
+			if len(pending) == 0 {
+				return fail(seq, "game is not over")
+			}
+			won, ok := pending[0].(engine.Won)
+			if !ok || won.Winner != e.Winner || won.Loser != e.Loser {
+				return fail(seq, "expected %s, replay has winner %s", describe(pending[0]), e.Winner)
+			}
+			pending = pending[1:]
+
+		default:
+			return fail(seq, "unknown event type %q", e.Type)
+		}
+	}
+	if len(pending) > 0 {
+		return fail(0, "log ends without %s", describe(pending[0]))
+	}
+	return g, nil
+}
+
+func describe(e engine.Event) string {
+	switch e := e.(type) {
+	case engine.Sunk:
+		return fmt.Sprintf("%s sinking %s", e.Player, e.Ship)
+	case engine.Won:
+		return fmt.Sprintf("game over won by %s", e.Winner)
+	default:
+		return fmt.Sprintf("%T", e)
+	}
+}
@@ -0,0 +1,70 @@
+package store
+
+import "fmt"
+
+// Key layout shared by the Redis and in-memory stores.
+
+func roomKey(roomID string) string {
+	return "room:" + roomID
+}
+
+// roomDataPrefix matches every key owned by a room except the member set.
+func roomDataPrefix(roomID string) string {
+	return "room:" + roomID + ":"
+}
+
+func boardKey(roomID, playerID string) string {
+	return fmt.Sprintf("room:%s:board:%s", roomID, playerID)
+}
+
+func gameKey(roomID string) string {
+	return "room:" + roomID + ":game"
+}
+
+// attacksKey is the set of cells a player has fired at, to reject repeats,
+// and shotsKey the same cells as a list in the order they were fired.
+func attacksKey(roomID, playerID string) string {
+	return fmt.Sprintf("room:%s:attacks:%s", roomID, playerID)
+}
+
+func shotsKey(roomID, playerID string) string {
+	return fmt.Sprintf("room:%s:shots:%s", roomID, playerID)
+}
+
+func eventsKey(roomID string) string {
+	return "room:" + roomID + ":events"
+}
+
+func playerRoomKey(playerID string) string {
+	return "player:" + playerID + ":room"
+}
+
+func presenceKey(playerID string) string {
+	return "player:" + playerID + ":online"
+}
+
+// queueJoinedKey holds the join time of every entry of a queue.
+func queueJoinedKey(queue string) string {
+	return "queue_joined:" + queue
+}
+
+// queueRatingsKey is a sorted set of the rated entries of a queue, scored by
+// rating.
+func queueRatingsKey(queue string) string {
+	return "queue_ratings:" + queue
+}
+
+func lobbyKey(code string) string {
+	return "lobby:" + code
+}
+
+// rematchKey holds the rematch offer of a finished game. It outlives the
+// room, so it is not under the room's prefix.
+func rematchKey(roomID string) string {
+	return "rematch:" + roomID
+}
+
+// inboxKey is a stream of the unacknowledged notifications of a player.
+func inboxKey(playerID string) string {
+	return "player:" + playerID + ":inbox"
+}
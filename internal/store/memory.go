@@ -0,0 +1,557 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory implements Store in process memory using the same key layout as
+// Redis. It lets the server run as a single binary without Redis.
+type Memory struct {
+	mu      sync.Mutex
+	strings map[string][]byte
+	sets    map[string]map[string]struct{}
+	lists   map[string][]string             // oldest entry first
+	joined  map[string]map[string]time.Time // join time per queue entry
+	ratings map[string]map[string]int       // rating per rated queue entry
+	streams map[string][]InboxEntry         // oldest entry first
+	expires map[string]time.Time
+	// lastID is the last stream entry ID handed out, as milliseconds and
+	// sequence like Redis stream IDs.
+	lastID [2]int64
+
+	subsMu sync.Mutex
+	subs   map[string]map[*memorySubscription]struct{}
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		strings: make(map[string][]byte),
+		sets:    make(map[string]map[string]struct{}),
+		lists:   make(map[string][]string),
+		joined:  make(map[string]map[string]time.Time),
+		ratings: make(map[string]map[string]int),
+		streams: make(map[string][]InboxEntry),
+		expires: make(map[string]time.Time),
+		subs:    make(map[string]map[*memorySubscription]struct{}),
+	}
+}
+
+// expire drops key if its TTL has passed. Callers must hold mu.
+func (s *Memory) expire(key string) {
+	if deadline, ok := s.expires[key]; ok && time.Now().After(deadline) {
+		s.del(key)
+	}
+}
+
+// del removes key of any type. Callers must hold mu.
+func (s *Memory) del(key string) {
+	delete(s.strings, key)
+	delete(s.sets, key)
+	delete(s.lists, key)
+	delete(s.streams, key)
+	delete(s.expires, key)
+}
+
+// setTTL sets or clears the expiry of key. Callers must hold mu.
+func (s *Memory) setTTL(key string, ttl time.Duration) {
+	if ttl > 0 {
+		s.expires[key] = time.Now().Add(ttl)
+	} else {
+		delete(s.expires, key)
+	}
+}
+
+func (s *Memory) getString(key string) ([]byte, error) {
+	s.expire(key)
+	value, ok := s.strings[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (s *Memory) setString(key string, value []byte, ttl time.Duration) {
+	s.strings[key] = append([]byte(nil), value...)
+	s.setTTL(key, ttl)
+}
+
+func (s *Memory) setMembers(key string) []string {
+	s.expire(key)
+	members := make([]string, 0, len(s.sets[key]))
+	for m := range s.sets[key] {
+		members = append(members, m)
+	}
+	return members
+}
+
+func (s *Memory) setAdd(key string, members ...string) {
+	s.expire(key)
+	set, ok := s.sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		s.sets[key] = set
+	}
+	for _, m := range members {
+		set[m] = struct{}{}
+	}
+}
+
+func (s *Memory) setHas(key, member string) bool {
+	s.expire(key)
+	_, ok := s.sets[key][member]
+	return ok
+}
+
+func (s *Memory) CreateRoom(ctx context.Context, roomID string, players []string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setAdd(roomKey(roomID), players...)
+	s.setTTL(roomKey(roomID), ttl)
+	for _, p := range players {
+		s.setString(playerRoomKey(p), []byte(roomID), ttl)
+	}
+	return nil
+}
+
+func (s *Memory) RoomPlayers(ctx context.Context, roomID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setMembers(roomKey(roomID)), nil
+}
+
+func (s *Memory) IsRoomMember(ctx context.Context, roomID, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.setHas(roomKey(roomID), playerID), nil
+}
+
+func (s *Memory) FindRoomByPlayer(ctx context.Context, playerID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roomID, err := s.getString(playerRoomKey(playerID))
+	if err == ErrNotFound {
+		return "", nil
+	}
+	return string(roomID), err
+}
+
+func (s *Memory) DeleteRoom(ctx context.Context, roomID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.setMembers(roomKey(roomID)) {
+		if current, err := s.getString(playerRoomKey(p)); err == nil && string(current) == roomID {
+			s.del(playerRoomKey(p))
+		}
+	}
+	s.del(roomKey(roomID))
+	for key := range s.keysWithPrefix(roomDataPrefix(roomID)) {
+		s.del(key)
+	}
+	return nil
+}
+
+// keysWithPrefix collects keys of every type. Callers must hold mu.
+func (s *Memory) keysWithPrefix(prefix string) map[string]struct{} {
+	keys := make(map[string]struct{})
+	for key := range s.strings {
+		if strings.HasPrefix(key, prefix) {
+			keys[key] = struct{}{}
+		}
+	}
+	for key := range s.sets {
+		if strings.HasPrefix(key, prefix) {
+			keys[key] = struct{}{}
+		}
+	}
+	for key := range s.lists {
+		if strings.HasPrefix(key, prefix) {
+			keys[key] = struct{}{}
+		}
+	}
+	return keys
+}
+
+func (s *Memory) SaveBoard(ctx context.Context, roomID, playerID string, board []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setString(boardKey(roomID, playerID), board, ttl)
+	return nil
+}
+
+func (s *Memory) Board(ctx context.Context, roomID, playerID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getString(boardKey(roomID, playerID))
+}
+
+func (s *Memory) HasBoard(ctx context.Context, roomID, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.getString(boardKey(roomID, playerID))
+	return err == nil, nil
+}
+
+func (s *Memory) SaveGameState(ctx context.Context, roomID string, state []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setString(gameKey(roomID), state, ttl)
+	return nil
+}
+
+func (s *Memory) GameState(ctx context.Context, roomID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getString(gameKey(roomID))
+}
+
+func (s *Memory) AddAttack(ctx context.Context, roomID, playerID, coordinate string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addAttack(roomID, playerID, coordinate)
+	return nil
+}
+
+func (s *Memory) Attacks(ctx context.Context, roomID, playerID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attacks(roomID, playerID), nil
+}
+
+// addAttack appends a shot to the ordered list unless the set shows the
+// cell was fired at already. Callers must hold mu.
+func (s *Memory) addAttack(roomID, playerID, coordinate string) {
+	set := attacksKey(roomID, playerID)
+	if s.setHas(set, coordinate) {
+		return
+	}
+	s.setAdd(set, coordinate)
+	list := shotsKey(roomID, playerID)
+	s.expire(list)
+	s.lists[list] = append(s.lists[list], coordinate)
+}
+
+// attacks returns the shots of a player in order. Callers must hold mu.
+func (s *Memory) attacks(roomID, playerID string) []string {
+	list := shotsKey(roomID, playerID)
+	s.expire(list)
+	return append([]string{}, s.lists[list]...)
+}
+
+func (s *Memory) Push(ctx context.Context, queue, playerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if indexOf(s.lists[queue], playerID) >= 0 {
+		return fmt.Errorf("player %s already in %s", playerID, queue)
+	}
+	s.lists[queue] = append(s.lists[queue], playerID)
+	if s.joined[queue] == nil {
+		s.joined[queue] = make(map[string]time.Time)
+	}
+	s.joined[queue][playerID] = time.Now()
+	return nil
+}
+
+func (s *Memory) Pop(ctx context.Context, queue string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.lists[queue]
+	if len(list) == 0 {
+		return "", ErrNotFound
+	}
+	s.lists[queue] = list[1:]
+	delete(s.joined[queue], list[0])
+	delete(s.ratings[queue], list[0])
+	return list[0], nil
+}
+
+func (s *Memory) Remove(ctx context.Context, queue, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(queue, playerID), nil
+}
+
+// remove drops an entry from a queue and reports whether it was there.
+// Callers must hold mu.
+func (s *Memory) remove(queue, playerID string) bool {
+	list := s.lists[queue]
+	kept := list[:0:0]
+	for _, p := range list {
+		if p != playerID {
+			kept = append(kept, p)
+		}
+	}
+	s.lists[queue] = kept
+	delete(s.joined[queue], playerID)
+	delete(s.ratings[queue], playerID)
+	return len(kept) < len(list)
+}
+
+func (s *Memory) Contains(ctx context.Context, queue, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return indexOf(s.lists[queue], playerID) >= 0, nil
+}
+
+func (s *Memory) Len(ctx context.Context, queue string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.lists[queue])), nil
+}
+
+func (s *Memory) Members(ctx context.Context, queue string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.lists[queue]...), nil
+}
+
+func (s *Memory) Entries(ctx context.Context, queue string) ([]QueueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]QueueEntry, len(s.lists[queue]))
+	for i, p := range s.lists[queue] {
+		entries[i] = QueueEntry{ID: p, JoinedAt: s.joined[queue][p]}
+	}
+	return entries, nil
+}
+
+func (s *Memory) Rate(ctx context.Context, queue, playerID string, rating int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if indexOf(s.lists[queue], playerID) < 0 {
+		return ErrNotFound
+	}
+	if s.ratings[queue] == nil {
+		s.ratings[queue] = make(map[string]int)
+	}
+	s.ratings[queue][playerID] = rating
+	return nil
+}
+
+func (s *Memory) ByRating(ctx context.Context, queue string) ([]QueueEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []QueueEntry
+	for _, p := range s.lists[queue] {
+		if rating, ok := s.ratings[queue][p]; ok {
+			entries = append(entries, QueueEntry{ID: p, JoinedAt: s.joined[queue][p], Rating: rating})
+		}
+	}
+	// Like a Redis sorted set: by rating, then by ID
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Rating != entries[j].Rating {
+			return entries[i].Rating < entries[j].Rating
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, nil
+}
+
+func (s *Memory) Take(ctx context.Context, queue string, playerIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range playerIDs {
+		if indexOf(s.lists[queue], p) < 0 {
+			return ErrNotFound
+		}
+	}
+	for _, p := range playerIDs {
+		s.remove(queue, p)
+	}
+	return nil
+}
+
+func (s *Memory) SetPresent(ctx context.Context, playerID string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setString(presenceKey(playerID), []byte("1"), ttl)
+	return nil
+}
+
+func (s *Memory) ClearPresent(ctx context.Context, playerID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.del(presenceKey(playerID))
+	return nil
+}
+
+func (s *Memory) IsPresent(ctx context.Context, playerID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.getString(presenceKey(playerID))
+	return err == nil, nil
+}
+
+func (s *Memory) CreateLobby(ctx context.Context, code string, lobby []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.getString(lobbyKey(code)); err == nil {
+		return ErrConflict
+	}
+	s.setString(lobbyKey(code), lobby, ttl)
+	return nil
+}
+
+func (s *Memory) Lobby(ctx context.Context, code string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getString(lobbyKey(code))
+}
+
+// UpdateLobby holds the store lock for the whole of fn, which runs once.
+func (s *Memory) UpdateLobby(ctx context.Context, code string, ttl time.Duration, fn func(lobby []byte) ([]byte, error)) error {
+	return s.update(lobbyKey(code), ttl, fn)
+}
+
+func (s *Memory) SaveRematch(ctx context.Context, roomID string, rematch []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setString(rematchKey(roomID), rematch, ttl)
+	return nil
+}
+
+func (s *Memory) UpdateRematch(ctx context.Context, roomID string, ttl time.Duration, fn func(rematch []byte) ([]byte, error)) error {
+	return s.update(rematchKey(roomID), ttl, fn)
+}
+
+func (s *Memory) AppendInbox(ctx context.Context, playerID string, notification []byte, max int64, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := inboxKey(playerID)
+	s.expire(key)
+
+	ms := time.Now().UnixMilli()
+	if ms > s.lastID[0] {
+		s.lastID = [2]int64{ms, 0}
+	} else {
+		s.lastID[1]++
+	}
+	id := fmt.Sprintf("%d-%d", s.lastID[0], s.lastID[1])
+
+	entries := append(s.streams[key], InboxEntry{ID: id, Notification: append([]byte(nil), notification...)})
+	if max > 0 && int64(len(entries)) > max {
+		entries = entries[int64(len(entries))-max:]
+	}
+	s.streams[key] = entries
+	s.setTTL(key, ttl)
+	return id, nil
+}
+
+func (s *Memory) Inbox(ctx context.Context, playerID string) ([]InboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := inboxKey(playerID)
+	s.expire(key)
+	return append([]InboxEntry{}, s.streams[key]...), nil
+}
+
+func (s *Memory) AckInbox(ctx context.Context, playerID string, ids ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := inboxKey(playerID)
+	s.expire(key)
+	acked := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		acked[id] = true
+	}
+	var kept []InboxEntry
+	for _, e := range s.streams[key] {
+		if !acked[e.ID] {
+			kept = append(kept, e)
+		}
+	}
+	if len(kept) == 0 {
+		s.del(key)
+		return nil
+	}
+	s.streams[key] = kept
+	return nil
+}
+
+// update replaces the string at key with what fn makes of it, or deletes it
+// if fn returns nil.
+func (s *Memory) update(key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, err := s.getString(key)
+	if err != nil {
+		return err
+	}
+	updated, err := fn(value)
+	if err != nil {
+		return err
+	}
+	if updated == nil {
+		s.del(key)
+		return nil
+	}
+	s.setString(key, updated, ttl)
+	return nil
+}
+
+// subscriptionBuffer is how many messages a slow subscriber may fall behind
+// before messages to it are dropped, like a Redis client output buffer.
+const subscriptionBuffer = 256
+
+func (s *Memory) Publish(ctx context.Context, channel string, message []byte) error {
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	for sub := range s.subs[channel] {
+		select {
+		case sub.ch <- append([]byte(nil), message...):
+		default:
+			log.Printf("Dropping message on %s: subscriber is full", channel)
+		}
+	}
+	return nil
+}
+
+func (s *Memory) Subscribe(ctx context.Context, channel string) Subscription {
+	sub := &memorySubscription{
+		store:   s,
+		channel: channel,
+		ch:      make(chan []byte, subscriptionBuffer),
+	}
+	s.subsMu.Lock()
+	defer s.subsMu.Unlock()
+	if s.subs[channel] == nil {
+		s.subs[channel] = make(map[*memorySubscription]struct{})
+	}
+	s.subs[channel][sub] = struct{}{}
+	return sub
+}
+
+type memorySubscription struct {
+	store   *Memory
+	channel string
+	ch      chan []byte
+	once    sync.Once
+}
+
+func (s *memorySubscription) Messages() <-chan []byte {
+	return s.ch
+}
+
+func (s *memorySubscription) Close() error {
+	s.once.Do(func() {
+		s.store.subsMu.Lock()
+		defer s.store.subsMu.Unlock()
+		delete(s.store.subs[s.channel], s)
+		close(s.ch)
+	})
+	return nil
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// UpdateRoom holds the store lock for the whole of fn, so transactions on
+// the in-memory store never conflict and fn runs exactly once.
+func (s *Memory) UpdateRoom(ctx context.Context, roomID string, fn func(tx RoomTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &memoryRoomTx{store: s, roomID: roomID}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for _, write := range tx.writes {
+		write()
+	}
+	return nil
+}
+
+// memoryRoomTx reads through to the store; the caller already holds mu.
+type memoryRoomTx struct {
+	store  *Memory
+	roomID string
+	writes []func()
+}
+
+func (t *memoryRoomTx) Players() ([]string, error) {
+	return t.store.setMembers(roomKey(t.roomID)), nil
+}
+
+func (t *memoryRoomTx) Board(playerID string) ([]byte, error) {
+	return t.store.getString(boardKey(t.roomID, playerID))
+}
+
+func (t *memoryRoomTx) GameState() ([]byte, error) {
+	return t.store.getString(gameKey(t.roomID))
+}
+
+func (t *memoryRoomTx) Attacks(playerID string) ([]string, error) {
+	return t.store.attacks(t.roomID, playerID), nil
+}
+
+func (t *memoryRoomTx) EventLog() ([][]byte, error) {
+	key := eventsKey(t.roomID)
+	t.store.expire(key)
+	log := make([][]byte, len(t.store.lists[key]))
+	for i, r := range t.store.lists[key] {
+		log[i] = []byte(r)
+	}
+	return log, nil
+}
+
+func (t *memoryRoomTx) SaveBoard(playerID string, board []byte, ttl time.Duration) {
+	t.writes = append(t.writes, func() {
+		t.store.setString(boardKey(t.roomID, playerID), board, ttl)
+	})
+}
+
+func (t *memoryRoomTx) SaveGameState(state []byte, ttl time.Duration) {
+	t.writes = append(t.writes, func() {
+		t.store.setString(gameKey(t.roomID), state, ttl)
+	})
+}
+
+func (t *memoryRoomTx) AddAttack(playerID, coordinate string, ttl time.Duration) {
+	t.writes = append(t.writes, func() {
+		t.store.addAttack(t.roomID, playerID, coordinate)
+		t.store.setTTL(attacksKey(t.roomID, playerID), ttl)
+		t.store.setTTL(shotsKey(t.roomID, playerID), ttl)
+	})
+}
+
+func (t *memoryRoomTx) AppendEvent(record []byte, ttl time.Duration) {
+	t.writes = append(t.writes, func() {
+		key := eventsKey(t.roomID)
+		t.store.expire(key)
+		t.store.lists[key] = append(t.store.lists[key], string(record))
+		t.store.setTTL(key, ttl)
+	})
+}
@@ -0,0 +1,466 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis implements Store on top of a go-redis client.
+type Redis struct {
+	rdb *redis.Client
+}
+
+func NewRedis(rdb *redis.Client) *Redis {
+	return &Redis{rdb: rdb}
+}
+
+// Client exposes the underlying client for code that needs Redis directly.
+func (s *Redis) Client() *redis.Client {
+	return s.rdb
+}
+
+func (s *Redis) CreateRoom(ctx context.Context, roomID string, players []string, ttl time.Duration) error {
+	members := make([]interface{}, len(players))
+	for i, p := range players {
+		members[i] = p
+	}
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, roomKey(roomID), members...)
+		pipe.Expire(ctx, roomKey(roomID), ttl)
+		for _, p := range players {
+			pipe.Set(ctx, playerRoomKey(p), roomID, ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *Redis) RoomPlayers(ctx context.Context, roomID string) ([]string, error) {
+	return s.rdb.SMembers(ctx, roomKey(roomID)).Result()
+}
+
+func (s *Redis) IsRoomMember(ctx context.Context, roomID, playerID string) (bool, error) {
+	return s.rdb.SIsMember(ctx, roomKey(roomID), playerID).Result()
+}
+
+func (s *Redis) FindRoomByPlayer(ctx context.Context, playerID string) (string, error) {
+	roomID, err := s.rdb.Get(ctx, playerRoomKey(playerID)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return roomID, err
+}
+
+func (s *Redis) DeleteRoom(ctx context.Context, roomID string) error {
+	players, err := s.rdb.SMembers(ctx, roomKey(roomID)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{roomKey(roomID)}
+	iter := s.rdb.Scan(ctx, 0, roomDataPrefix(roomID)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if err := s.rdb.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	// Only clear the player mapping if it still points at this room
+	for _, p := range players {
+		current, err := s.rdb.Get(ctx, playerRoomKey(p)).Result()
+		if err == nil && current == roomID {
+			s.rdb.Del(ctx, playerRoomKey(p))
+		}
+	}
+	return nil
+}
+
+func (s *Redis) SaveBoard(ctx context.Context, roomID, playerID string, board []byte, ttl time.Duration) error {
+	return s.rdb.Set(ctx, boardKey(roomID, playerID), board, ttl).Err()
+}
+
+func (s *Redis) Board(ctx context.Context, roomID, playerID string) ([]byte, error) {
+	return s.get(ctx, boardKey(roomID, playerID))
+}
+
+func (s *Redis) HasBoard(ctx context.Context, roomID, playerID string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, boardKey(roomID, playerID)).Result()
+	return n == 1, err
+}
+
+func (s *Redis) SaveGameState(ctx context.Context, roomID string, state []byte, ttl time.Duration) error {
+	return s.rdb.Set(ctx, gameKey(roomID), state, ttl).Err()
+}
+
+func (s *Redis) GameState(ctx context.Context, roomID string) ([]byte, error) {
+	return s.get(ctx, gameKey(roomID))
+}
+
+// addAttack appends a shot to the ordered list unless the set shows the
+// cell was fired at already.
+var addAttack = redis.NewScript(`
+if redis.call("SADD", KEYS[1], ARGV[1]) == 1 then
+	redis.call("RPUSH", KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+func (s *Redis) AddAttack(ctx context.Context, roomID, playerID, coordinate string) error {
+	keys := []string{attacksKey(roomID, playerID), shotsKey(roomID, playerID)}
+	return addAttack.Run(ctx, s.rdb, keys, coordinate).Err()
+}
+
+func (s *Redis) Attacks(ctx context.Context, roomID, playerID string) ([]string, error) {
+	return s.rdb.LRange(ctx, shotsKey(roomID, playerID), 0, -1).Result()
+}
+
+// Queues are Redis lists: new entries are pushed on the left and the oldest
+// entry is popped from the right.
+
+// push adds a player to a queue and records the join time unless the player
+// is queued already, in one step so concurrent joins cannot both get in. A
+// script rather than WATCH, since every join and match touches the queue.
+var push = redis.NewScript(`
+if redis.call("LPOS", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("LPUSH", KEYS[1], ARGV[1])
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+return 1
+`)
+
+func (s *Redis) Push(ctx context.Context, queue, playerID string) error {
+	keys := []string{queue, queueJoinedKey(queue)}
+	added, err := push.Run(ctx, s.rdb, keys, playerID, time.Now().UnixMilli()).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return fmt.Errorf("player %s already in %s", playerID, queue)
+	}
+	return nil
+}
+
+func (s *Redis) Pop(ctx context.Context, queue string) (string, error) {
+	playerID, err := s.rdb.RPop(ctx, queue).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	s.rdb.HDel(ctx, queueJoinedKey(queue), playerID)
+	s.rdb.ZRem(ctx, queueRatingsKey(queue), playerID)
+	return playerID, nil
+}
+
+func (s *Redis) Remove(ctx context.Context, queue, playerID string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = removeEntry(ctx, pipe, queue, playerID)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+// removeEntry queues the removal of an entry and returns the LREM, whose
+// count tells whether the entry was queued.
+func removeEntry(ctx context.Context, pipe redis.Pipeliner, queue, playerID string) *redis.IntCmd {
+	removed := pipe.LRem(ctx, queue, 0, playerID)
+	pipe.HDel(ctx, queueJoinedKey(queue), playerID)
+	pipe.ZRem(ctx, queueRatingsKey(queue), playerID)
+	return removed
+}
+
+func (s *Redis) Contains(ctx context.Context, queue, playerID string) (bool, error) {
+	_, err := s.rdb.LPos(ctx, queue, playerID, redis.LPosArgs{}).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Redis) Len(ctx context.Context, queue string) (int64, error) {
+	return s.rdb.LLen(ctx, queue).Result()
+}
+
+func (s *Redis) Members(ctx context.Context, queue string) ([]string, error) {
+	members, err := s.rdb.LRange(ctx, queue, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	// LPUSH puts the newest entry first
+	for i, j := 0, len(members)-1; i < j; i, j = i+1, j-1 {
+		members[i], members[j] = members[j], members[i]
+	}
+	return members, nil
+}
+
+func (s *Redis) Entries(ctx context.Context, queue string) ([]QueueEntry, error) {
+	members, err := s.Members(ctx, queue)
+	if err != nil {
+		return nil, err
+	}
+	joined, err := s.rdb.HGetAll(ctx, queueJoinedKey(queue)).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]QueueEntry, len(members))
+	for i, m := range members {
+		entries[i].ID = m
+		if ms, err := strconv.ParseInt(joined[m], 10, 64); err == nil {
+			entries[i].JoinedAt = time.UnixMilli(ms)
+		}
+	}
+	return entries, nil
+}
+
+// Ratings live in a sorted set next to the list, so the rated entries of a
+// queue can be read in rating order.
+
+func (s *Redis) Rate(ctx context.Context, queue, playerID string, rating int) error {
+	exists, err := s.Contains(ctx, queue, playerID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return s.rdb.ZAdd(ctx, queueRatingsKey(queue), redis.Z{Score: float64(rating), Member: playerID}).Err()
+}
+
+func (s *Redis) ByRating(ctx context.Context, queue string) ([]QueueEntry, error) {
+	rated, err := s.rdb.ZRangeWithScores(ctx, queueRatingsKey(queue), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	joined, err := s.rdb.HGetAll(ctx, queueJoinedKey(queue)).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]QueueEntry, len(rated))
+	for i, z := range rated {
+		entries[i].ID, _ = z.Member.(string)
+		entries[i].Rating = int(z.Score)
+		if ms, err := strconv.ParseInt(joined[entries[i].ID], 10, 64); err == nil {
+			entries[i].JoinedAt = time.UnixMilli(ms)
+		}
+	}
+	return entries, nil
+}
+
+// Take watches the queue so that two matchmakers never take the same entry.
+func (s *Redis) Take(ctx context.Context, queue string, playerIDs ...string) error {
+	err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+		for _, p := range playerIDs {
+			_, err := tx.LPos(ctx, queue, p, redis.LPosArgs{}).Result()
+			if err == redis.Nil {
+				return ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, p := range playerIDs {
+				removeEntry(ctx, pipe, queue, p)
+			}
+			return nil
+		})
+		return err
+	}, queue)
+	if errors.Is(err, redis.TxFailedErr) {
+		return ErrConflict
+	}
+	return err
+}
+
+func (s *Redis) SetPresent(ctx context.Context, playerID string, ttl time.Duration) error {
+	return s.rdb.Set(ctx, presenceKey(playerID), 1, ttl).Err()
+}
+
+func (s *Redis) ClearPresent(ctx context.Context, playerID string) error {
+	return s.rdb.Del(ctx, presenceKey(playerID)).Err()
+}
+
+func (s *Redis) IsPresent(ctx context.Context, playerID string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, presenceKey(playerID)).Result()
+	return n > 0, err
+}
+
+func (s *Redis) CreateLobby(ctx context.Context, code string, lobby []byte, ttl time.Duration) error {
+	created, err := s.rdb.SetNX(ctx, lobbyKey(code), lobby, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *Redis) Lobby(ctx context.Context, code string) ([]byte, error) {
+	return s.get(ctx, lobbyKey(code))
+}
+
+func (s *Redis) UpdateLobby(ctx context.Context, code string, ttl time.Duration, fn func(lobby []byte) ([]byte, error)) error {
+	return s.update(ctx, lobbyKey(code), ttl, fn)
+}
+
+func (s *Redis) SaveRematch(ctx context.Context, roomID string, rematch []byte, ttl time.Duration) error {
+	return s.rdb.Set(ctx, rematchKey(roomID), rematch, ttl).Err()
+}
+
+func (s *Redis) UpdateRematch(ctx context.Context, roomID string, ttl time.Duration, fn func(rematch []byte) ([]byte, error)) error {
+	return s.update(ctx, rematchKey(roomID), ttl, fn)
+}
+
+func (s *Redis) AppendInbox(ctx context.Context, playerID string, notification []byte, max int64, ttl time.Duration) (string, error) {
+	var add *redis.StringCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		add = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: inboxKey(playerID),
+			MaxLen: max,
+			Values: map[string]interface{}{"n": notification},
+		})
+		pipe.Expire(ctx, inboxKey(playerID), ttl)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return add.Val(), nil
+}
+
+func (s *Redis) Inbox(ctx context.Context, playerID string) ([]InboxEntry, error) {
+	messages, err := s.rdb.XRange(ctx, inboxKey(playerID), "-", "+").Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]InboxEntry, 0, len(messages))
+	for _, m := range messages {
+		notification, _ := m.Values["n"].(string)
+		entries = append(entries, InboxEntry{ID: m.ID, Notification: []byte(notification)})
+	}
+	return entries, nil
+}
+
+func (s *Redis) AckInbox(ctx context.Context, playerID string, ids ...string) error {
+	// XDEL fails on malformed IDs rather than ignoring them
+	valid := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if isStreamID(id) {
+			valid = append(valid, id)
+		}
+	}
+	if len(valid) == 0 {
+		return nil
+	}
+	return s.rdb.XDel(ctx, inboxKey(playerID), valid...).Err()
+}
+
+// isStreamID reports whether id has the <milliseconds>-<sequence> form of
+// stream entry IDs.
+func isStreamID(id string) bool {
+	ms, seq, ok := strings.Cut(id, "-")
+	if !ok {
+		return false
+	}
+	_, err1 := strconv.ParseUint(ms, 10, 64)
+	_, err2 := strconv.ParseUint(seq, 10, 64)
+	return err1 == nil && err2 == nil
+}
+
+// update runs fn on the string at key under WATCH and writes back what it
+// returns, or deletes the key for nil, retrying when another client changed
+// the key first.
+func (s *Redis) update(ctx context.Context, key string, ttl time.Duration, fn func(value []byte) ([]byte, error)) error {
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			value, err := tx.Get(ctx, key).Bytes()
+			if errors.Is(err, redis.Nil) {
+				return ErrNotFound
+			}
+			if err != nil {
+				return err
+			}
+			updated, err := fn(value)
+			if err != nil {
+				return err
+			}
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				if updated == nil {
+					pipe.Del(ctx, key)
+				} else {
+					pipe.Set(ctx, key, updated, ttl)
+				}
+				return nil
+			})
+			return err
+		}, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return err
+	}
+	return ErrConflict
+}
+
+func (s *Redis) Publish(ctx context.Context, channel string, message []byte) error {
+	return s.rdb.Publish(ctx, channel, message).Err()
+}
+
+func (s *Redis) Subscribe(ctx context.Context, channel string) Subscription {
+	sub := &redisSubscription{
+		pubsub: s.rdb.Subscribe(ctx, channel),
+		ch:     make(chan []byte),
+		done:   make(chan struct{}),
+	}
+	go sub.forward()
+	return sub
+}
+
+type redisSubscription struct {
+	pubsub *redis.PubSub
+	ch     chan []byte
+	done   chan struct{}
+}
+
+func (s *redisSubscription) forward() {
+	defer close(s.ch)
+	for msg := range s.pubsub.Channel() {
+		select {
+		case s.ch <- []byte(msg.Payload):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *redisSubscription) Messages() <-chan []byte {
+	return s.ch
+}
+
+func (s *redisSubscription) Close() error {
+	close(s.done)
+	return s.pubsub.Close()
+}
+
+func (s *Redis) get(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// maxTxAttempts bounds how often UpdateRoom retries after a WATCH conflict.
+const maxTxAttempts = 20
+
+// UpdateRoom runs fn under WATCH on every key of the room and commits its
+// writes in a MULTI/EXEC block, retrying when another client got there first.
+func (s *Redis) UpdateRoom(ctx context.Context, roomID string, fn func(tx RoomTx) error) error {
+	players, err := s.rdb.SMembers(ctx, roomKey(roomID)).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{roomKey(roomID), gameKey(roomID), eventsKey(roomID)}
+	for _, p := range players {
+		keys = append(keys, boardKey(roomID, p), attacksKey(roomID, p), shotsKey(roomID, p))
+	}
+
+	for attempt := 0; attempt < maxTxAttempts; attempt++ {
+		err := s.rdb.Watch(ctx, func(tx *redis.Tx) error {
+			rtx := &redisRoomTx{ctx: ctx, tx: tx, roomID: roomID}
+			if err := fn(rtx); err != nil {
+				return err
+			}
+			_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				for _, write := range rtx.writes {
+					write(pipe)
+				}
+				return nil
+			})
+			return err
+		}, keys...)
+		if errors.Is(err, redis.TxFailedErr) {
+			time.Sleep(time.Duration(attempt+1) * time.Millisecond)
+			continue
+		}
+		return err
+	}
+	return ErrConflict
+}
+
+type redisRoomTx struct {
+	ctx    context.Context
+	tx     *redis.Tx
+	roomID string
+	writes []func(pipe redis.Pipeliner)
+}
+
+func (t *redisRoomTx) Players() ([]string, error) {
+	return t.tx.SMembers(t.ctx, roomKey(t.roomID)).Result()
+}
+
+func (t *redisRoomTx) Board(playerID string) ([]byte, error) {
+	return t.get(boardKey(t.roomID, playerID))
+}
+
+func (t *redisRoomTx) GameState() ([]byte, error) {
+	return t.get(gameKey(t.roomID))
+}
+
+func (t *redisRoomTx) Attacks(playerID string) ([]string, error) {
+	return t.tx.LRange(t.ctx, shotsKey(t.roomID, playerID), 0, -1).Result()
+}
+
+func (t *redisRoomTx) EventLog() ([][]byte, error) {
+	records, err := t.tx.LRange(t.ctx, eventsKey(t.roomID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	log := make([][]byte, len(records))
+	for i, r := range records {
+		log[i] = []byte(r)
+	}
+	return log, nil
+}
+
+func (t *redisRoomTx) SaveBoard(playerID string, board []byte, ttl time.Duration) {
+	key := boardKey(t.roomID, playerID)
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.Set(t.ctx, key, board, ttl)
+	})
+}
+
+func (t *redisRoomTx) SaveGameState(state []byte, ttl time.Duration) {
+	key := gameKey(t.roomID)
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.Set(t.ctx, key, state, ttl)
+	})
+}
+
+// AddAttack records a shot the caller has checked is not a repeat; both
+// keys are watched, so the check holds until the write.
+func (t *redisRoomTx) AddAttack(playerID, coordinate string, ttl time.Duration) {
+	set, list := attacksKey(t.roomID, playerID), shotsKey(t.roomID, playerID)
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.SAdd(t.ctx, set, coordinate)
+		pipe.RPush(t.ctx, list, coordinate)
+		pipe.Expire(t.ctx, set, ttl)
+		pipe.Expire(t.ctx, list, ttl)
+	})
+}
+
+func (t *redisRoomTx) AppendEvent(record []byte, ttl time.Duration) {
+	key := eventsKey(t.roomID)
+	t.writes = append(t.writes, func(pipe redis.Pipeliner) {
+		pipe.RPush(t.ctx, key, record)
+		pipe.Expire(t.ctx, key, ttl)
+	})
+}
+
+func (t *redisRoomTx) get(key string) ([]byte, error) {
+	value, err := t.tx.Get(t.ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
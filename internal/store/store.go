@@ -0,0 +1,191 @@
+// Package store defines the persistence interfaces used by the game, match
+// and websocket layers, with a Redis implementation for production and an
+// in-memory implementation for local development and tests.
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when a key does not exist or has expired.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned by UpdateRoom when the room kept changing
+	// underneath the transaction and it could not be committed. Take,
+	// CreateLobby and UpdateLobby return it in the same situations for
+	// queues and lobbies.
+	ErrConflict = errors.New("room was modified concurrently")
+)
+
+// RoomStore tracks which players belong to which room.
+type RoomStore interface {
+	CreateRoom(ctx context.Context, roomID string, players []string, ttl time.Duration) error
+	RoomPlayers(ctx context.Context, roomID string) ([]string, error)
+	IsRoomMember(ctx context.Context, roomID, playerID string) (bool, error)
+	// FindRoomByPlayer returns the room the player is in, or "" if none.
+	FindRoomByPlayer(ctx context.Context, playerID string) (string, error)
+	// DeleteRoom removes the room and every piece of state stored under it.
+	DeleteRoom(ctx context.Context, roomID string) error
+}
+
+// BoardStore holds each player's serialized board.
+type BoardStore interface {
+	SaveBoard(ctx context.Context, roomID, playerID string, board []byte, ttl time.Duration) error
+	Board(ctx context.Context, roomID, playerID string) ([]byte, error)
+	HasBoard(ctx context.Context, roomID, playerID string) (bool, error)
+}
+
+// GameStateStore holds the serialized game state of a room.
+type GameStateStore interface {
+	SaveGameState(ctx context.Context, roomID string, state []byte, ttl time.Duration) error
+	GameState(ctx context.Context, roomID string) ([]byte, error)
+}
+
+// AttackStore records the coordinates each player has fired at. Attacks
+// returns them in the order they were added; adding a coordinate twice
+// keeps the first.
+type AttackStore interface {
+	AddAttack(ctx context.Context, roomID, playerID, coordinate string) error
+	Attacks(ctx context.Context, roomID, playerID string) ([]string, error)
+}
+
+// RoomTx is a consistent view of one room inside UpdateRoom. Reads see the
+// room as of the start of the attempt; writes are buffered and applied
+// together only if nothing else modified the room in the meantime.
+type RoomTx interface {
+	Players() ([]string, error)
+	Board(playerID string) ([]byte, error)
+	GameState() ([]byte, error)
+	// Attacks returns the player's shots in the order they were fired.
+	Attacks(playerID string) ([]string, error)
+	// EventLog returns every record passed to AppendEvent, oldest first.
+	EventLog() ([][]byte, error)
+
+	SaveBoard(playerID string, board []byte, ttl time.Duration)
+	SaveGameState(state []byte, ttl time.Duration)
+	AddAttack(playerID, coordinate string, ttl time.Duration)
+	AppendEvent(record []byte, ttl time.Duration)
+}
+
+// RoomUpdater runs read-validate-write sequences on a room atomically.
+type RoomUpdater interface {
+	// UpdateRoom calls fn with a transaction on the room and commits its
+	// writes atomically. fn may be called more than once if the room changes
+	// concurrently, so it must not have side effects outside tx. If fn
+	// returns an error nothing is written and the error is returned.
+	UpdateRoom(ctx context.Context, roomID string, fn func(tx RoomTx) error) error
+}
+
+// QueueEntry is a queued player and when they joined the queue. JoinedAt is
+// zero for entries pushed before join times were recorded. Rating is only
+// filled in by ByRating.
+type QueueEntry struct {
+	ID       string
+	JoinedAt time.Time
+	Rating   int
+}
+
+// QueueStore is a set of FIFO queues of player IDs without duplicates.
+// Every entry remembers when it was pushed and may be given a rating, which
+// lets a queue also be read in rating order.
+type QueueStore interface {
+	Push(ctx context.Context, queue, playerID string) error
+	// Pop removes and returns the oldest entry, or ErrNotFound.
+	Pop(ctx context.Context, queue string) (string, error)
+	// Remove drops an entry and reports whether it was queued, so that of
+	// concurrent removals only one sees true.
+	Remove(ctx context.Context, queue, playerID string) (bool, error)
+	Contains(ctx context.Context, queue, playerID string) (bool, error)
+	Len(ctx context.Context, queue string) (int64, error)
+	// Members returns every entry, oldest first.
+	Members(ctx context.Context, queue string) ([]string, error)
+	// Entries returns every entry with its join time, oldest first.
+	Entries(ctx context.Context, queue string) ([]QueueEntry, error)
+	// Rate sets the rating of an entry. Ratings are dropped with the entry.
+	Rate(ctx context.Context, queue, playerID string, rating int) error
+	// ByRating returns the rated entries with their join times, lowest
+	// rating first.
+	ByRating(ctx context.Context, queue string) ([]QueueEntry, error)
+	// Take removes all of the given entries at once, or none of them with
+	// ErrNotFound if any is no longer queued.
+	Take(ctx context.Context, queue string, playerIDs ...string) error
+}
+
+// PresenceStore records which players hold a live connection. Presence
+// expires unless it is refreshed, so it is cleared even when the server
+// holding the connection dies.
+type PresenceStore interface {
+	SetPresent(ctx context.Context, playerID string, ttl time.Duration) error
+	ClearPresent(ctx context.Context, playerID string) error
+	IsPresent(ctx context.Context, playerID string) (bool, error)
+}
+
+// LobbyStore holds private lobbies, serialized, under their invite codes.
+type LobbyStore interface {
+	// CreateLobby stores a new lobby, or fails with ErrConflict if the code
+	// is taken.
+	CreateLobby(ctx context.Context, code string, lobby []byte, ttl time.Duration) error
+	Lobby(ctx context.Context, code string) ([]byte, error)
+	// UpdateLobby replaces a lobby with what fn makes of it, atomically, and
+	// deletes it if fn returns nil. fn may be called more than once. It
+	// returns ErrNotFound if there is no such lobby, and fn's error as is.
+	UpdateLobby(ctx context.Context, code string, ttl time.Duration, fn func(lobby []byte) ([]byte, error)) error
+}
+
+// RematchStore holds, for a while after a game ended, what its players need
+// to play again: the room itself is gone by then.
+type RematchStore interface {
+	SaveRematch(ctx context.Context, roomID string, rematch []byte, ttl time.Duration) error
+	// UpdateRematch works like UpdateLobby.
+	UpdateRematch(ctx context.Context, roomID string, ttl time.Duration, fn func(rematch []byte) ([]byte, error)) error
+}
+
+// InboxEntry is a notification in a player's inbox.
+type InboxEntry struct {
+	ID           string
+	Notification []byte
+}
+
+// InboxStore keeps each player's notifications until they acknowledge them,
+// so none are lost while the player is not connected.
+type InboxStore interface {
+	// AppendInbox adds a notification to the player's inbox and returns its
+	// ID. IDs increase with every notification. The inbox keeps the last max
+	// notifications and expires ttl after the last one was added.
+	AppendInbox(ctx context.Context, playerID string, notification []byte, max int64, ttl time.Duration) (string, error)
+	// Inbox returns the unacknowledged notifications, oldest first.
+	Inbox(ctx context.Context, playerID string) ([]InboxEntry, error)
+	// AckInbox removes notifications from the inbox. IDs not in it are
+	// ignored.
+	AckInbox(ctx context.Context, playerID string, ids ...string) error
+}
+
+// PubSub delivers messages to every current subscriber of a channel.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, message []byte) error
+	Subscribe(ctx context.Context, channel string) Subscription
+}
+
+// Subscription is a live subscription to one channel. Messages is closed
+// after Close is called.
+type Subscription interface {
+	Messages() <-chan []byte
+	Close() error
+}
+
+// Store is everything the server needs from its backing store.
+type Store interface {
+	RoomStore
+	BoardStore
+	GameStateStore
+	AttackStore
+	RoomUpdater
+	QueueStore
+	PresenceStore
+	LobbyStore
+	RematchStore
+	InboxStore
+	PubSub
+}
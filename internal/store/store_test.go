@@ -0,0 +1,119 @@
+package store_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/krishanu7/battleship-backend/internal/store"
+	"github.com/krishanu7/battleship-backend/internal/store/storetest"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestConcurrentPush joins the same player to a queue from many goroutines
+// at once: exactly one join may succeed.
+func TestConcurrentPush(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			queue := fmt.Sprintf("test:queue:%d", time.Now().UnixNano())
+			t.Cleanup(func() { st.Remove(ctx, queue, "p1") })
+
+			const joins = 32
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			accepted := 0
+			for i := 0; i < joins; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if err := st.Push(ctx, queue, "p1"); err == nil {
+						mu.Lock()
+						accepted++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if accepted != 1 {
+				t.Errorf("%d joins accepted, want 1", accepted)
+			}
+			members, err := st.Members(ctx, queue)
+			if err != nil {
+				t.Fatalf("Members: %v", err)
+			}
+			if len(members) != 1 {
+				t.Errorf("queue holds %v, want p1 once", members)
+			}
+			entries, err := st.Entries(ctx, queue)
+			if err != nil || len(entries) != 1 || entries[0].JoinedAt.IsZero() {
+				t.Errorf("entries = %+v, %v, want p1 with its join time", entries, err)
+			}
+		})
+	}
+}
+
+// TestRemoveOnce removes the same entry from many goroutines at once: only
+// one removal may report it was queued.
+func TestRemoveOnce(t *testing.T) {
+	for name, st := range storetest.Stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			queue := fmt.Sprintf("test:queue:%d", time.Now().UnixNano())
+			if err := st.Push(ctx, queue, "p1"); err != nil {
+				t.Fatalf("Push: %v", err)
+			}
+
+			const removals = 32
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			removed := 0
+			for i := 0; i < removals; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ok, err := st.Remove(ctx, queue, "p1")
+					if err != nil {
+						t.Errorf("Remove: %v", err)
+					}
+					if ok {
+						mu.Lock()
+						removed++
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if removed != 1 {
+				t.Errorf("%d removals reported p1, want 1", removed)
+			}
+		})
+	}
+}
+
+// TestAddAttackExpires checks that the shots written in a room transaction
+// expire with the rest of the room.
+func TestAddAttackExpires(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	st := store.NewRedis(rdb)
+
+	err := st.UpdateRoom(context.Background(), "r1", func(tx store.RoomTx) error {
+		tx.AddAttack("p1", "A1", time.Hour)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateRoom: %v", err)
+	}
+	for _, key := range []string{"room:r1:attacks:p1", "room:r1:shots:p1"} {
+		if ttl := mr.TTL(key); ttl != time.Hour {
+			t.Errorf("%s expires in %v, want 1h", key, ttl)
+		}
+	}
+}
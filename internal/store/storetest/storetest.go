@@ -0,0 +1,40 @@
+// Package storetest provides the stores that tests of store users run
+// against.
+package storetest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/krishanu7/battleship-backend/internal/store"
+	"github.com/redis/go-redis/v9"
+)
+
+// Stores returns the stores to run a test against, by name: the in-memory
+// store, the Redis store on an embedded miniredis server, so that its
+// WATCH/MULTI and script paths always run, and the Redis store on a real
+// server as well when TEST_REDIS_ADDR is set.
+func Stores(t testing.TB) map[string]store.Store {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	stores := map[string]store.Store{
+		"memory":    store.NewMemory(),
+		"miniredis": store.NewRedis(newClient(t, mr.Addr())),
+	}
+	if addr := os.Getenv("TEST_REDIS_ADDR"); addr != "" {
+		stores["redis"] = store.NewRedis(newClient(t, addr))
+	}
+	return stores
+}
+
+func newClient(t testing.TB, addr string) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		t.Fatalf("redis at %s: %v", addr, err)
+	}
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
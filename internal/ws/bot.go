@@ -0,0 +1,177 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/bot"
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	"github.com/krishanu7/battleship-backend/internal/store"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// Bots wait before every move so that their shots can be followed. Besides
+// reacting to room messages they look at the game on a timer, which catches
+// the start of the game: that is announced outside the room.
+const (
+	botThinkTime    = 800 * time.Millisecond
+	botPollInterval = time.Second
+)
+
+// BotWorker takes new bot games from bot.Queue and plays them on this
+// server. Popping the queue hands each game to exactly one server.
+type BotWorker struct {
+	store   store.Store
+	handler *Handler
+	ctx     context.Context
+}
+
+func NewBotWorker(st store.Store, handler *Handler) *BotWorker {
+	return &BotWorker{
+		store:   st,
+		handler: handler,
+		ctx:     context.Background(),
+	}
+}
+
+func (w *BotWorker) Run() {
+	log.Println("Bot worker starting...")
+	sub := w.store.Subscribe(w.ctx, bot.Channel)
+	defer sub.Close()
+
+	for range sub.Messages() {
+		// Another server may already have taken the game
+		for {
+			roomID, err := w.store.Pop(w.ctx, bot.Queue)
+			if err != nil {
+				break
+			}
+			go w.play(roomID)
+		}
+	}
+	log.Println("Bot subscription closed")
+}
+
+func (w *BotWorker) play(roomID string) {
+	players, err := w.store.RoomPlayers(w.ctx, roomID)
+	if err != nil {
+		log.Printf("Failed to get players of bot room %s: %v", roomID, err)
+		return
+	}
+	for _, player := range players {
+		if difficulty, ok := bot.Difficulty(player); ok {
+			w.handler.playBot(roomID, player, difficulty)
+			return
+		}
+	}
+	log.Printf("No bot in room %s", roomID)
+}
+
+// playBot joins the room as botID, places a random fleet and plays until
+// the game is over. The bot is a client of the room like any player, without
+// a connection: it reads the room's messages from its Send channel.
+func (h *Handler) playBot(roomID, botID, difficulty string) {
+	room, ok := h.Hub.GetRoom(roomID)
+	if !ok {
+		log.Printf("Room %s does not exist for bot %s", roomID, botID)
+		return
+	}
+	c := &wsPkg.Client{
+		ID:   botID,
+		Send: make(chan []byte, 64),
+	}
+	room.AddClient(c)
+	defer room.RemoveClient(c)
+
+	seed := time.Now().UnixNano()
+	rng := rand.New(rand.NewSource(seed))
+	ships, err := h.gameService.RandomFleet(roomID, botID, seed)
+	if err != nil {
+		log.Printf("Bot %s failed to generate a fleet: %v", botID, err)
+		return
+	}
+	if _, err := h.gameService.PlaceShips(roomID, botID, ships); err != nil {
+		log.Printf("Bot %s failed to place ships: %v", botID, err)
+		return
+	}
+	log.Printf("Bot %s joined room %s", botID, roomID)
+
+	poll := time.NewTicker(botPollInterval)
+	defer poll.Stop()
+	for {
+		select {
+		case msg := <-c.Send:
+			h.botMessage(c, msg)
+		case <-poll.C:
+		}
+		if !h.botTurn(c, difficulty, rng) {
+			log.Printf("Bot %s left room %s", botID, roomID)
+			return
+		}
+	}
+}
+
+// botTurn moves for the bot if it is its turn. It reports false once the
+// game is over and its state is gone.
+func (h *Handler) botTurn(c *wsPkg.Client, difficulty string, rng *rand.Rand) bool {
+	state, err := h.gameService.State(c.Room.ID)
+	if err != nil {
+		log.Printf("Bot %s failed to get game state: %v", c.ID, err)
+		return true
+	}
+	if state == nil {
+		return false
+	}
+	if state.Turn != c.ID {
+		return true
+	}
+
+	h.botWait(c, botThinkTime)
+	snap, err := h.gameService.Snapshot(c.Room.ID, c.ID)
+	if err != nil {
+		log.Printf("Bot %s failed to read the game: %v", c.ID, err)
+		return true
+	}
+	if snap.Turn != c.ID {
+		// The turn ran out while thinking
+		return true
+	}
+	view := bot.View{Rules: snap.Rules, Shots: snap.Shots, Sinks: snap.Sinks}
+	if snap.Rules.Mode == game.ModeSalvo {
+		h.salvo(c, protocol.Salvo{Coordinates: bot.Choose(difficulty, view, snap.SalvoShots, rng)})
+	} else if shots := bot.Choose(difficulty, view, 1, rng); len(shots) == 1 {
+		h.attack(c, protocol.Attack{Coordinate: shots[0]})
+	}
+	return true
+}
+
+// botWait lets d pass while still handling the room's messages, so that
+// broadcasts never block on a full Send channel.
+func (h *Handler) botWait(c *wsPkg.Client, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-c.Send:
+			h.botMessage(c, msg)
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// botMessage reacts to a room message. Bots play every game to the end, so
+// draw offers are declined; everything else is read from the game state.
+func (h *Handler) botMessage(c *wsPkg.Client, msg []byte) {
+	var message protocol.PlayerEvent
+	if err := json.Unmarshal(msg, &message); err != nil {
+		return
+	}
+	if message.Type == protocol.TypeDrawOffered {
+		h.declineDraw(c, protocol.DeclineDraw{})
+	}
+}
@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// sendJSON queues v for a single client.
+func sendJSON(c *wsPkg.Client, v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to marshal message for %s: %v", c.ID, err)
+		return
+	}
+	c.Queue(msg)
+}
+
+// sendError reports a message that could not be handled to the client that
+// sent it, in reply to the message with ID correlationID.
+func sendError(c *wsPkg.Client, err error, correlationID string) {
+	sendJSON(c, protocol.ErrorReply(err, correlationID))
+}
+
+// broadcastJSON sends v to every client of the room except senderID.
+func broadcastJSON(room *wsPkg.Room, senderID string, v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to marshal broadcast for room %s: %v", room.ID, err)
+		return
+	}
+	room.Broadcast(senderID, msg)
+}
+
+// broadcastPublic sends v to the players and, after the room's delay, to
+// its spectators. Only messages without ship positions of a running game
+// may go through here.
+func broadcastPublic(room *wsPkg.Room, v interface{}) {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to marshal broadcast for room %s: %v", room.ID, err)
+		return
+	}
+	room.Broadcast("", msg)
+	room.BroadcastSpectators(msg)
+}
+
+// broadcastAttack sends the result of a shot and the ships it sank.
+func broadcastAttack(room *wsPkg.Room, shooter string, attack *game.Attack, sunkShips []string, nextTurn string) {
+	log.Printf("Broadcasting attack_result in room %s: %s %s", room.ID, attack.Coordinate, attack.Result)
+	broadcastPublic(room, protocol.AttackResult{
+		Type:       protocol.TypeAttackResult,
+		Coordinate: attack.Coordinate,
+		Result:     attack.Result,
+		NextTurn:   nextTurn,
+	})
+
+	for _, ship := range sunkShips {
+		log.Printf("Broadcasting ship_sunk in room %s: %s", room.ID, ship)
+		broadcastPublic(room, protocol.ShipSunk{
+			Type:     protocol.TypeShipSunk,
+			Ship:     ship,
+			PlayerID: shooter,
+		})
+	}
+}
+
+// broadcastSalvo sends the results of a whole salvo in one message.
+func broadcastSalvo(room *wsPkg.Room, shooter string, attacks []game.Attack, sunkShips []string, nextTurn string) {
+	log.Printf("Broadcasting salvo_result in room %s: %d shots by %s", room.ID, len(attacks), shooter)
+	broadcastPublic(room, protocol.SalvoResult{
+		Type:      protocol.TypeSalvoResult,
+		PlayerID:  shooter,
+		Shots:     attacks,
+		SunkShips: sunkShips,
+		NextTurn:  nextTurn,
+	})
+}
+
+func broadcastGameOver(room *wsPkg.Room, gameOver *game.GameOver) {
+	log.Printf("Broadcasting game_over in room %s: winner %s", room.ID, gameOver.Winner)
+	broadcastPublic(room, protocol.GameOver{
+		Type:   protocol.TypeGameOver,
+		Winner: gameOver.Winner,
+		Loser:  gameOver.Loser,
+		Reason: gameOver.Reason,
+		Draw:   gameOver.Draw,
+		Series: gameOver.Series,
+		Fleets: gameOver.Fleets,
+	})
+}
+
+// broadcastTurn announces the player to move.
+func broadcastTurn(room *wsPkg.Room, state *game.GameState) {
+	if state == nil {
+		return
+	}
+	log.Printf("Broadcasting turn in room %s: %s", room.ID, state.Turn)
+	broadcastPublic(room, protocol.Turn{
+		Type:       protocol.TypeTurn,
+		PlayerID:   state.Turn,
+		SalvoShots: state.SalvoShots,
+		Deadline:   state.Deadline,
+		Banks:      state.Banks,
+	})
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// gameEnded stops the clocks of a finished game and announces the result.
+func (h *Handler) gameEnded(room *wsPkg.Room, gameOver *game.GameOver) {
+	h.timers.Cancel(room.ID)
+	h.grace.stopRoom(room.ID)
+	broadcastGameOver(room, gameOver)
+}
+
+func (h *Handler) resign(c *wsPkg.Client, _ protocol.Resign) error {
+	gameOver, err := h.gameService.Resign(c.Room.ID, c.ID)
+	if err != nil {
+		log.Printf("Resign error for %s: %v", c.ID, err)
+		return err
+	}
+	h.gameEnded(c.Room, gameOver)
+	return nil
+}
+
+func (h *Handler) offerDraw(c *wsPkg.Client, _ protocol.OfferDraw) error {
+	gameOver, err := h.gameService.OfferDraw(c.Room.ID, c.ID)
+	if err != nil {
+		log.Printf("Draw offer error for %s: %v", c.ID, err)
+		return err
+	}
+	if gameOver != nil {
+		// The opponent had offered a draw too
+		h.gameEnded(c.Room, gameOver)
+		return nil
+	}
+	broadcastJSON(c.Room, c.ID, protocol.PlayerEvent{
+		Type:     protocol.TypeDrawOffered,
+		PlayerID: c.ID,
+	})
+	return nil
+}
+
+func (h *Handler) acceptDraw(c *wsPkg.Client, _ protocol.AcceptDraw) error {
+	gameOver, err := h.gameService.AcceptDraw(c.Room.ID, c.ID)
+	if err != nil {
+		log.Printf("Draw accept error for %s: %v", c.ID, err)
+		return err
+	}
+	h.gameEnded(c.Room, gameOver)
+	return nil
+}
+
+func (h *Handler) declineDraw(c *wsPkg.Client, _ protocol.DeclineDraw) error {
+	opponent, err := h.gameService.DeclineDraw(c.Room.ID, c.ID)
+	if err != nil {
+		log.Printf("Draw decline error for %s: %v", c.ID, err)
+		return err
+	}
+	broadcastJSON(c.Room, c.ID, protocol.PlayerEvent{
+		Type:     protocol.TypeDrawDeclined,
+		PlayerID: c.ID,
+	})
+	log.Printf("Player %s declined the draw offer of %s in room %s", c.ID, opponent, c.Room.ID)
+	return nil
+}
+
+// rematch asks for a rematch of the game that just ended in the room. The
+// offer and the new room reach both players as notifications.
+func (h *Handler) rematch(c *wsPkg.Client, msg protocol.Rematch) error {
+	if _, err := h.gameService.Rematch(c.Room.ID, c.ID, msg.BestOf); err != nil {
+		log.Printf("Rematch error for %s: %v", c.ID, err)
+		return err
+	}
+	return nil
+}
+
+func (h *Handler) declineRematch(c *wsPkg.Client, _ protocol.DeclineRematch) error {
+	if err := h.gameService.DeclineRematch(c.Room.ID, c.ID); err != nil {
+		log.Printf("Rematch decline error for %s: %v", c.ID, err)
+		return err
+	}
+	return nil
+}
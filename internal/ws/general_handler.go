@@ -1,19 +1,41 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/krishanu7/battleship-backend/internal/auth"
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/notify"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	"github.com/krishanu7/battleship-backend/internal/store"
 	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
 )
 
+// A player with a general connection is marked present in the store for
+// presenceTTL, refreshed every presenceRefresh. Matchmaking drops queued
+// players who are not present.
+const (
+	presenceTTL     = 30 * time.Second
+	presenceRefresh = 10 * time.Second
+)
+
 type GeneralHandler struct {
-	Hub *wsPkg.GeneralHub
+	Hub         *wsPkg.GeneralHub
+	presence    store.PresenceStore
+	gameService *game.Service
+	notifier    *notify.Service
+	dispatch    *protocol.Dispatcher[*wsPkg.GeneralClient]
+	limits      wsPkg.Limits
 }
 
-func NewGeneralHandler(hub *wsPkg.GeneralHub) *GeneralHandler {
-	return &GeneralHandler{Hub: hub}
+func NewGeneralHandler(hub *wsPkg.GeneralHub, presence store.PresenceStore, gameService *game.Service, notifier *notify.Service, limits wsPkg.Limits) *GeneralHandler {
+	h := &GeneralHandler{Hub: hub, presence: presence, gameService: gameService, notifier: notifier, limits: limits}
+	h.dispatch = h.routes()
+	return h
 }
 
 func (h *GeneralHandler) ServeGeneralWS(w http.ResponseWriter, r *http.Request) {
@@ -22,51 +44,130 @@ func (h *GeneralHandler) ServeGeneralWS(w http.ResponseWriter, r *http.Request)
 		log.Printf("General WS upgrade failed: %v", err)
 		return
 	}
+	h.limits.Prepare(conn)
 
-	playerID := r.URL.Query().Get("playerId")
-	if playerID == "" {
-		log.Println("Missing playerId for general WS")
+	playerID, ok := auth.PlayerIDFromContext(r.Context())
+	if !ok {
+		log.Println("Missing player identity for general WS")
 		conn.Close()
 		return
 	}
 
 	client := &wsPkg.GeneralClient{
-		ID:   playerID,
-		Conn: conn,
-		Send: make(chan []byte),
+		ID:      playerID,
+		Conn:    conn,
+		Send:    make(chan []byte, 32),
+		Version: protocol.VersionOf(conn.Subprotocol()),
 	}
 
 	h.Hub.AddClient(client)
 
-	go h.read(client)
+	done := make(chan struct{})
+	go h.read(client, done)
 	go h.write(client)
+	go h.keepPresent(client.ID, done)
+	h.replay(client)
+}
+
+// replay sends the notifications the player has not acknowledged, which
+// were sent while they were not connected or did not get through. Some may
+// reach the client twice; it tells them apart by ID. What does not fit in
+// the connection's buffer is left for the client to fetch over REST.
+func (h *GeneralHandler) replay(c *wsPkg.GeneralClient) {
+	notifications, err := h.notifier.Unread(c.ID)
+	if err != nil {
+		log.Printf("Failed to replay notifications of %s: %v", c.ID, err)
+		return
+	}
+	for _, n := range notifications {
+		if !h.Hub.SendToClient(c.ID, n) {
+			break
+		}
+	}
+	if len(notifications) > 0 {
+		log.Printf("Replayed %d notifications to %s", len(notifications), c.ID)
+	}
+}
+
+// keepPresent marks the player present until done is closed.
+func (h *GeneralHandler) keepPresent(playerID string, done chan struct{}) {
+	ctx := context.Background()
+	ticker := time.NewTicker(presenceRefresh)
+	defer ticker.Stop()
+	for {
+		if err := h.presence.SetPresent(ctx, playerID, presenceTTL); err != nil {
+			log.Printf("Failed to mark %s present: %v", playerID, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
 }
 
-func (h *GeneralHandler) read(c *wsPkg.GeneralClient) {
+func (h *GeneralHandler) read(c *wsPkg.GeneralClient, done chan struct{}) {
 	defer func() {
-		h.Hub.RemoveClient(c)
+		close(done)
+		if h.Hub.RemoveClient(c) {
+			if err := h.presence.ClearPresent(context.Background(), c.ID); err != nil {
+				log.Printf("Failed to clear presence of %s: %v", c.ID, err)
+			}
+		}
+		// The hub sends nothing to a client it no longer holds
+		c.Close()
 		c.Conn.Close()
 	}()
 
 	for {
-		_, _, err := c.Conn.ReadMessage()
+		_, msg, err := c.Conn.ReadMessage()
 		if err != nil {
 			log.Printf("General WS read error for %s: %v", c.ID, err)
 			break
 		}
-		// No need to handle incoming messages for now
+		env, err := protocol.Decode(msg, c.Version)
+		if err == nil {
+			err = h.dispatch.Dispatch(c, env)
+		}
+		if err != nil {
+			log.Printf("%s error for %s: %v", env.Type, c.ID, err)
+			h.sendError(c, err, env.ID)
+		}
 	}
 }
 
-func (h *GeneralHandler) write(c *wsPkg.GeneralClient) {
-	defer c.Conn.Close()
+// routes registers the handlers of the messages sent on general
+// connections. Rematches can be asked for here once the room connection is
+// gone.
+func (h *GeneralHandler) routes() *protocol.Dispatcher[*wsPkg.GeneralClient] {
+	d := protocol.NewDispatcher[*wsPkg.GeneralClient]()
+	protocol.On(d, protocol.TypeRematch, func(c *wsPkg.GeneralClient, msg protocol.Rematch) error {
+		_, err := h.gameService.Rematch(msg.RoomID, c.ID, msg.BestOf)
+		return err
+	})
+	protocol.On(d, protocol.TypeDeclineRematch, func(c *wsPkg.GeneralClient, msg protocol.DeclineRematch) error {
+		return h.gameService.DeclineRematch(msg.RoomID, c.ID)
+	})
+	protocol.On(d, protocol.TypeAck, func(c *wsPkg.GeneralClient, msg protocol.Ack) error {
+		return h.notifier.Ack(c.ID, msg.IDs...)
+	})
+	return d
+}
+
+func (h *GeneralHandler) sendError(c *wsPkg.GeneralClient, err error, correlationID string) {
+	msg, _ := json.Marshal(protocol.ErrorReply(err, correlationID))
+	h.Hub.SendToClient(c.ID, msg)
+}
 
-	for msg := range c.Send {
-		err := c.Conn.WriteMessage(websocket.TextMessage, msg)
+func (h *GeneralHandler) write(c *wsPkg.GeneralClient) {
+	err := h.limits.WriteLoop(c.Conn, c.Send, func(msg []byte) ([]byte, error) {
+		frame, err := protocol.Encode(msg, c.Version)
 		if err != nil {
-			log.Printf("General WS write error for %s: %v", c.ID, err)
-			break
+			log.Printf("Failed to encode message for %s: %v", c.ID, err)
 		}
-		log.Printf("Sent message to %s: %s", c.ID, string(msg))
+		return frame, err
+	})
+	if err != nil {
+		log.Printf("General WS write error for %s: %v", c.ID, err)
 	}
 }
\ No newline at end of file
@@ -1,26 +1,38 @@
 package ws
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/krishanu7/battleship-backend/internal/auth"
 	"github.com/krishanu7/battleship-backend/internal/game"
-	"github.com/krishanu7/battleship-backend/pkg/redis"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
 	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
 )
 
 type Handler struct {
-	Hub         *wsPkg.Hub
-	gameService *game.Service
+	Hub            *wsPkg.Hub
+	gameService    *game.Service
+	timers         *TurnTimers
+	reconnectGrace time.Duration
+	grace          *graceTimers
+	dispatch       *protocol.Dispatcher[*wsPkg.Client]
+	limits         wsPkg.Limits
 }
 
-func NewHandler(hub *wsPkg.Hub, gameService *game.Service) *Handler {
-	return &Handler{
-		Hub:         hub,
-		gameService: gameService,
+func NewHandler(hub *wsPkg.Hub, gameService *game.Service, timers *TurnTimers, reconnectGrace time.Duration, limits wsPkg.Limits) *Handler {
+	h := &Handler{
+		Hub:            hub,
+		gameService:    gameService,
+		timers:         timers,
+		reconnectGrace: reconnectGrace,
+		grace:          newGraceTimers(),
+		limits:         limits,
 	}
+	h.dispatch = h.routes()
+	hub.OnRemoteJoin = h.remoteJoined
+	return h
 }
 
 func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
@@ -29,12 +41,13 @@ func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Upgrade failed: %v", err)
 		return
 	}
+	h.limits.Prepare(conn)
 
-	playerID := r.URL.Query().Get("playerId")
+	playerID, _ := auth.PlayerIDFromContext(r.Context())
 	roomID := r.URL.Query().Get("roomId")
 
 	if playerID == "" || roomID == "" {
-		log.Println("Missing playerId or roomId")
+		log.Println("Missing player identity or roomId")
 		conn.Close()
 		return
 	}
@@ -45,26 +58,54 @@ func (h *Handler) ServeWS(w http.ResponseWriter, r *http.Request) {
 		conn.Close()
 		return
 	}
+	isPlayer, err := h.gameService.IsPlayer(roomID, playerID)
+	if err == nil && !isPlayer && r.URL.Query().Get("spectate") == "true" {
+		h.spectate(conn, playerID, room)
+		return
+	}
+	if err != nil || !isPlayer {
+		log.Printf("Player %s is not a member of room %s", playerID, roomID)
+		conn.Close()
+		return
+	}
 
 	client := &wsPkg.Client{
-		ID:   playerID,
-		Conn: conn,
-		Send: make(chan []byte, 10),
+		ID:      playerID,
+		Conn:    conn,
+		Send:    make(chan []byte, 10),
+		Version: protocol.VersionOf(conn.Subprotocol()),
+	}
+
+	if previous := room.AddClient(client); previous != nil {
+		// A newer connection replaces the old one
+		previous.Conn.Close()
 	}
 
-	room.AddClient(client)
+	// Arm the turn clock in case this server has no timer for the room yet
+	if state, err := h.gameService.State(roomID); err == nil {
+		h.timers.Schedule(roomID, state)
+	}
 
 	log.Printf("Player %s connected to room %s", playerID, roomID)
-	go h.read(client)
 	go h.write(client)
+	// Only read may close Send, so anything sent to the client alone goes
+	// out before it starts
+	h.playerJoined(client)
+	if n := room.SpectatorCount(); n > 0 {
+		sendJSON(client, spectatorCount(n))
+	}
+	go h.read(client)
 }
 
+// read handles what the player sends until the connection fails, then
+// unregisters the client and closes its Send, which ends write.
 func (h *Handler) read(c *wsPkg.Client) {
 	defer func() {
-		if c.Room != nil {
-			delete(c.Room.Clients, c.ID)
+		if c.Room != nil && c.Room.RemoveClient(c) {
 			log.Printf("Client %s left room %s", c.ID, c.Room.ID)
+			h.playerLeft(c)
 		}
+		c.Close()
 		c.Conn.Close()
 	}()
 	for {
@@ -74,174 +115,79 @@ func (h *Handler) read(c *wsPkg.Client) {
 			break
 		}
 
-		var message struct {
-			Type       string `json:"type"`
-			Coordinate string `json:"coordinate"`
-			Message    string `json:"message"`
+		env, err := protocol.Decode(msg, c.Version)
+		if err == nil {
+			log.Printf("Received message from %s: type=%s", c.ID, env.Type)
+			err = h.dispatch.Dispatch(c, env)
 		}
-		if err := json.Unmarshal(msg, &message); err == nil {
-			log.Printf("Received JSON message from %s: type=%s", c.ID, message.Type)
-			if message.Type == "attack" && c.Room != nil {
-				log.Printf("Processing attack from %s: %s", c.ID, message.Coordinate)
-				attack, sunkShips, gameOver, err := h.gameService.ProcessAttack(c.Room.ID, c.ID, message.Coordinate)
-				if err != nil {
-					log.Printf("Attack error for %s: %v", c.ID, err)
-					errorMsg := struct {
-						Type    string `json:"type"`
-						Message string `json:"message"`
-					}{
-						Type:    "error",
-						Message: err.Error(),
-					}
-					errorBytes, _ := json.Marshal(errorMsg)
-					c.Send <- errorBytes
-					continue
-				}
-				// Broadcast attack result
-				nextTurn := ""
-				if gameOver == nil {
-					nextTurn = h.getCurrentTurn(c.Room.ID)
-				}
-				resultMsg := struct {
-					Type       string `json:"type"`
-					Coordinate string `json:"coordinate"`
-					Result     string `json:"result"`
-					NextTurn   string `json:"nextTurn"`
-				}{
-					Type:       "attack_result",
-					Coordinate: attack.Coordinate,
-					Result:     attack.Result,
-					NextTurn:   nextTurn,
-				}
-				resultBytes, err := json.Marshal(resultMsg)
-				if err != nil {
-					log.Printf("Failed to marshal attack_result: %v", err)
-					continue
-				}
-				log.Printf("Broadcasting attack_result: %s", string(resultBytes))
-				c.Room.Broadcast("", resultBytes)
-
-				// Broadcast sunk ships
-				for _, ship := range sunkShips {
-					sunkMsg := struct {
-						Type     string `json:"type"`
-						Ship     string `json:"ship"`
-						PlayerID string `json:"playerId"`
-					}{
-						Type:     "ship_sunk",
-						Ship:     ship,
-						PlayerID: c.ID,
-					}
-					sunkBytes, err := json.Marshal(sunkMsg)
-					if err != nil {
-						log.Printf("Failed to marshal ship_sunk: %v", err)
-						continue
-					}
-					log.Printf("Broadcasting ship_sunk: %s", string(sunkBytes))
-					c.Room.Broadcast("", sunkBytes)
-				}
-
-				// Broadcast game over
-				if gameOver != nil {
-					gameOverMsg := struct {
-						Type   string `json:"type"`
-						Winner string `json:"winner"`
-						Loser  string `json:"loser"`
-					}{
-						Type:   "game_over",
-						Winner: gameOver.Winner,
-						Loser:  gameOver.Loser,
-					}
-					gameOverBytes, err := json.Marshal(gameOverMsg)
-					if err != nil {
-						log.Printf("Failed to marshal game_over: %v", err)
-						continue
-					}
-					log.Printf("Broadcasting game_over: %s", string(gameOverBytes))
-					c.Room.Broadcast("", gameOverBytes)
-				} else {
-					// Notify next turn
-					turnMsg := struct {
-						Type     string `json:"type"`
-						PlayerID string `json:"playerId"`
-					}{
-						Type:     "turn",
-						PlayerID: h.getCurrentTurn(c.Room.ID),
-					}
-					turnBytes, err := json.Marshal(turnMsg)
-					if err != nil {
-						log.Printf("Failed to marshal turn notification: %v", err)
-						continue
-					}
-					log.Printf("Broadcasting turn: %s", string(turnBytes))
-					c.Room.Broadcast("", turnBytes)
-				}
-			} else if message.Type == "chat" && c.Room != nil {
-				chatMsg := struct {
-					Type    string `json:"type"`
-					Sender  string `json:"sender"`
-					Message string `json:"message"`
-				}{
-					Type:    "chat",
-					Sender:  c.ID,
-					Message: message.Message,
-				}
-				chatBytes, err := json.Marshal(chatMsg)
-				if err != nil {
-					log.Printf("Failed to marshal chat message: %v", err)
-					continue
-				}
-				log.Printf("Broadcasting chat from %s: %s", c.ID, string(chatBytes))
-				c.Room.Broadcast(c.ID, chatBytes)
-			}
-		} else {
-			log.Printf("Received plain text from %s: %s", c.ID, string(msg))
-			if c.Room != nil {
-				chatMsg := struct {
-					Type    string `json:"type"`
-					Sender  string `json:"sender"`
-					Message string `json:"message"`
-				}{
-					Type:    "chat",
-					Sender:  c.ID,
-					Message: string(msg),
-				}
-				chatBytes, err := json.Marshal(chatMsg)
-				if err != nil {
-					log.Printf("Failed to marshal plain text chat message: %v", err)
-					continue
-				}
-				log.Printf("Broadcasting plain text chat from %s: %s", c.ID, string(chatBytes))
-				c.Room.Broadcast(c.ID, chatBytes)
-			}
+		if err != nil {
+			log.Printf("%s error for %s: %v", env.Type, c.ID, err)
+			sendError(c, err, env.ID)
 		}
 	}
 }
 
-func (h *Handler) write(c *wsPkg.Client) {
-	defer c.Conn.Close()
+// routes registers the handlers of the messages players send.
+func (h *Handler) routes() *protocol.Dispatcher[*wsPkg.Client] {
+	d := protocol.NewDispatcher[*wsPkg.Client]()
+	protocol.On(d, protocol.TypeAttack, h.attack)
+	protocol.On(d, protocol.TypeSalvo, h.salvo)
+	protocol.On(d, protocol.TypeChat, h.chat)
+	protocol.On(d, protocol.TypeResign, h.resign)
+	protocol.On(d, protocol.TypeOfferDraw, h.offerDraw)
+	protocol.On(d, protocol.TypeAcceptDraw, h.acceptDraw)
+	protocol.On(d, protocol.TypeDeclineDraw, h.declineDraw)
+	protocol.On(d, protocol.TypeRematch, h.rematch)
+	protocol.On(d, protocol.TypeDeclineRematch, h.declineRematch)
+	return d
+}
 
-	for msg := range c.Send {
-		err := c.Conn.WriteMessage(websocket.TextMessage, msg)
-		if err != nil {
-			log.Printf("Write error for client %s: %v", c.ID, err)
-			break
-		}
-		log.Printf("Sent message to client %s: %s", c.ID, string(msg))
-	}
+// chat relays a chat message to the opponent.
+func (h *Handler) chat(c *wsPkg.Client, msg protocol.Chat) error {
+	log.Printf("Broadcasting chat from %s: %s", c.ID, msg.Message)
+	broadcastJSON(c.Room, c.ID, protocol.ChatMessage{
+		Type:    protocol.TypeChat,
+		Sender:  c.ID,
+		Message: msg.Message,
+	})
+	return nil
 }
 
-// getCurrentTurn retrieves the current turn from game state.
-func (h *Handler) getCurrentTurn(roomID string) string {
-	gameJSON, err := h.gameService.Rdb.Get(redis.Ctx, "room:"+roomID+":game").Result()
+// attack fires a single shot and broadcasts its result, then the game over
+// or the next turn.
+func (h *Handler) attack(c *wsPkg.Client, msg protocol.Attack) error {
+	log.Printf("Processing attack from %s: %s", c.ID, msg.Coordinate)
+	attack, sunkShips, gameOver, state, err := h.gameService.ProcessAttack(c.Room.ID, c.ID, msg.Coordinate)
 	if err != nil {
-		log.Printf("Failed to get game state for turn: %v", err)
-		return ""
+		log.Printf("Attack error for %s: %v", c.ID, err)
+		return err
+	}
+
+	nextTurn := ""
+	if state != nil {
+		nextTurn = state.Turn
+	}
+	broadcastAttack(c.Room, c.ID, attack, sunkShips, nextTurn)
+	if gameOver != nil {
+		h.gameEnded(c.Room, gameOver)
+	} else {
+		h.timers.Schedule(c.Room.ID, state)
+		broadcastTurn(c.Room, state)
 	}
-	var gameState game.GameState
-	if err := json.Unmarshal([]byte(gameJSON), &gameState); err != nil {
-		log.Printf("Failed to unmarshal game state for turn: %v", err)
-		return ""
+	return nil
+}
+
+// write sends the client what is queued for it and keeps the connection
+// alive with pings until Send is closed or a write fails.
+func (h *Handler) write(c *wsPkg.Client) {
+	err := h.limits.WriteLoop(c.Conn, c.Send, func(msg []byte) ([]byte, error) {
+		frame, err := protocol.Encode(msg, c.Version)
+		if err != nil {
+			log.Printf("Failed to encode message for client %s: %v", c.ID, err)
+		}
+		return frame, err
+	})
+	if err != nil {
+		log.Printf("Write error for client %s: %v", c.ID, err)
 	}
-	return gameState.Turn
-}
\ No newline at end of file
+}
@@ -1,63 +1,62 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 
 	"github.com/krishanu7/battleship-backend/internal/game"
-	rdbPkg "github.com/krishanu7/battleship-backend/pkg/redis"
+	"github.com/krishanu7/battleship-backend/internal/notify"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	"github.com/krishanu7/battleship-backend/internal/store"
 	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
-	"github.com/redis/go-redis/v9"
 )
 
 type NotificationWorker struct {
-	RedisClient *redis.Client
+	store       store.Store
 	GeneralHub  *wsPkg.GeneralHub
 	gameService *game.Service
+	notifier    *notify.Service
+	timers      *TurnTimers
+	ctx         context.Context
 }
 
-func NewNotificationWorker(rdb *redis.Client, hub *wsPkg.GeneralHub, gameService *game.Service) *NotificationWorker {
+func NewNotificationWorker(st store.Store, hub *wsPkg.GeneralHub, gameService *game.Service, notifier *notify.Service, timers *TurnTimers) *NotificationWorker {
 	return &NotificationWorker{
-		RedisClient: rdb,
+		store:       st,
 		GeneralHub:  hub,
 		gameService: gameService,
+		notifier:    notifier,
+		timers:      timers,
+		ctx:         context.Background(),
 	}
 }
 
 func (w *NotificationWorker) Run() {
 	log.Println("Notification worker starting...")
-	pubsub := w.RedisClient.Subscribe(rdbPkg.Ctx, "notifications")
-	defer pubsub.Close()
+	sub := w.store.Subscribe(w.ctx, notify.Channel)
+	defer sub.Close()
 
-	for {
-		log.Println("Waiting for notification messages...")
-		msg, err := pubsub.ReceiveMessage(rdbPkg.Ctx)
-		if err != nil {
-			log.Printf("Notification pub/sub error: %v", err)
-			continue
-		}
-		log.Printf("Received notification: %s", msg.Payload)
+	for payload := range sub.Messages() {
+		log.Printf("Received notification: %s", payload)
 
-		var notification struct {
-			Type   string `json:"type"`
-			RoomID string `json:"roomId"`
-			Player string `json:"player"`
-		}
-		if err := json.Unmarshal([]byte(msg.Payload), &notification); err != nil {
+		var notification protocol.Notification
+		if err := json.Unmarshal(payload, &notification); err != nil {
 			log.Printf("Failed to unmarshal notification: %v", err)
 			continue
 		}
 		log.Printf("Parsed notification for player %s: type=%s, roomId=%s", notification.Player, notification.Type, notification.RoomID)
-		// Forward to the specific player via GeneralHub
-		if !w.GeneralHub.SendToClient(notification.Player, []byte(msg.Payload)) {
+		// Forward to the specific player via GeneralHub. Notifications that do
+		// not get through stay in the inbox for the next connection.
+		if !w.GeneralHub.SendToClient(notification.Player, payload) {
 			log.Printf("Failed to send notification to player %s", notification.Player)
 		} else {
 			log.Printf("Successfully sent notification to player %s", notification.Player)
 		}
 		// Check if both players placed ships
-		if notification.Type == "ships_placed" {
+		if notification.Type == protocol.TypeShipsPlaced {
 			log.Printf("Processing ships_placed for room %s, player %s", notification.RoomID, notification.Player)
-			players, err := w.RedisClient.SMembers(rdbPkg.Ctx, "room:"+notification.RoomID).Result()
+			players, err := w.store.RoomPlayers(w.ctx, notification.RoomID)
 			if err != nil {
 				log.Printf("Failed to get room members for %s: %v", notification.RoomID, err)
 				continue
@@ -65,14 +64,13 @@ func (w *NotificationWorker) Run() {
 			log.Printf("Room %s has players: %v", notification.RoomID, players)
 			bothReady := true
 			for _, player := range players {
-				key := "room:" + notification.RoomID + ":board:" + player
-				exists, err := w.RedisClient.Exists(rdbPkg.Ctx, key).Result()
+				exists, err := w.store.HasBoard(w.ctx, notification.RoomID, player)
 				if err != nil {
 					log.Printf("Failed to check board for %s: %v", player, err)
 					bothReady = false
 					break
 				}
-				if exists == 0 {
+				if !exists {
 					log.Printf("Board not found for player %s in room %s", player, notification.RoomID)
 					bothReady = false
 					break
@@ -85,22 +83,26 @@ func (w *NotificationWorker) Run() {
 					log.Printf("Failed to initialize game for room %s: %v", notification.RoomID, err)
 					continue
 				}
-				// Notify both players that the game can start
-				gameStartMsg := struct {
-					Type   string `json:"type"`
-					RoomID string `json:"roomId"`
-				}{
-					Type:   "game_start",
-					RoomID: notification.RoomID,
-				}
-				msgBytes, err := json.Marshal(gameStartMsg)
-				if err != nil {
-					log.Printf("Failed to marshal game_start notification: %v", err)
+				state, err := w.gameService.State(notification.RoomID)
+				if err != nil || state == nil {
+					log.Printf("Failed to read game state of room %s: %v", notification.RoomID, err)
 					continue
 				}
+				w.timers.Schedule(notification.RoomID, state)
+				// Notify both players that the game can start
+				gameStartMsg := protocol.GameStart{
+					Type:       protocol.TypeGameStart,
+					RoomID:     notification.RoomID,
+					Mode:       state.Rules.Mode,
+					Rules:      state.Rules,
+					Turn:       state.Turn,
+					SalvoShots: state.SalvoShots,
+					Deadline:   state.Deadline,
+				}
 				for _, player := range players {
-					if !w.GeneralHub.SendToClient(player, msgBytes) {
-						log.Printf("Failed to send game_start to player %s", player)
+					gameStartMsg.Player = player
+					if err := w.notifier.Send(player, gameStartMsg); err != nil {
+						log.Printf("Failed to send game_start to player %s: %v", player, err)
 					} else {
 						log.Printf("Sent game_start to player %s", player)
 					}
@@ -108,4 +110,5 @@ func (w *NotificationWorker) Run() {
 			}
 		}
 	}
+	log.Println("Notification subscription closed")
 }
@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// graceTimers tracks players who dropped out of a running game and are
+// within their reconnect grace period.
+type graceTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer // keyed by roomID + ":" + playerID
+}
+
+func newGraceTimers() *graceTimers {
+	return &graceTimers{timers: make(map[string]*time.Timer)}
+}
+
+func (g *graceTimers) start(roomID, playerID string, d time.Duration, onExpire func()) {
+	key := roomID + ":" + playerID
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.timers[key]; ok {
+		t.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		g.mu.Lock()
+		current := g.timers[key] == timer
+		if current {
+			delete(g.timers, key)
+		}
+		g.mu.Unlock()
+		if current {
+			onExpire()
+		}
+	})
+	g.timers[key] = timer
+}
+
+// stop cancels a pending grace period and reports whether one was running.
+func (g *graceTimers) stop(roomID, playerID string) bool {
+	key := roomID + ":" + playerID
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t, ok := g.timers[key]
+	if ok {
+		t.Stop()
+		delete(g.timers, key)
+	}
+	return ok
+}
+
+// stopRoom cancels the grace periods of every player of a room.
+func (g *graceTimers) stopRoom(roomID string) {
+	prefix := roomID + ":"
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for key, t := range g.timers {
+		if strings.HasPrefix(key, prefix) {
+			t.Stop()
+			delete(g.timers, key)
+		}
+	}
+}
+
+// sendStateSync sends the player their view of the game so a reconnecting
+// client can rebuild its boards, shot history and turn indicator.
+func (h *Handler) sendStateSync(c *wsPkg.Client) {
+	snap, err := h.gameService.Snapshot(c.Room.ID, c.ID)
+	if err != nil {
+		log.Printf("Failed to build state_sync for %s in room %s: %v", c.ID, c.Room.ID, err)
+		return
+	}
+	sendJSON(c, protocol.StateSync{
+		Type:              protocol.TypeStateSync,
+		OpponentConnected: c.Room.HasClient(snap.OpponentID),
+		Snapshot:          snap,
+	})
+}
+
+// playerJoined resumes the game for a (re)connecting player and tells the
+// opponent they are back if they had dropped out.
+func (h *Handler) playerJoined(c *wsPkg.Client) {
+	if h.grace.stop(c.Room.ID, c.ID) {
+		log.Printf("Player %s reconnected to room %s within grace period", c.ID, c.Room.ID)
+		broadcastJSON(c.Room, c.ID, protocol.PlayerEvent{
+			Type:     protocol.TypeOpponentReconnected,
+			PlayerID: c.ID,
+		})
+	}
+	h.sendStateSync(c)
+}
+
+// remoteJoined ends the grace period of a player who dropped out of the room
+// here and came back on another server.
+func (h *Handler) remoteJoined(room *wsPkg.Room, playerID string) {
+	if !h.grace.stop(room.ID, playerID) {
+		return
+	}
+	log.Printf("Player %s reconnected to room %s on another server within grace period", playerID, room.ID)
+	broadcastJSON(room, playerID, protocol.PlayerEvent{
+		Type:     protocol.TypeOpponentReconnected,
+		PlayerID: playerID,
+	})
+}
+
+// playerLeft starts the grace period for a player who dropped out of a
+// game that is being placed or played. The opponent is told to wait rather
+// than the game silently stalling; if the player does not return in time
+// they forfeit.
+func (h *Handler) playerLeft(c *wsPkg.Client) {
+	room := c.Room
+	state, err := h.gameService.State(room.ID)
+	if err != nil {
+		log.Printf("Failed to get game state of room %s: %v", room.ID, err)
+		return
+	}
+	if state == nil || state.Over() {
+		// Room already cleared or game over
+		return
+	}
+	deadline := time.Now().Add(h.reconnectGrace)
+	broadcastJSON(room, c.ID, protocol.OpponentDisconnected{
+		Type:         protocol.TypeOpponentDisconnected,
+		PlayerID:     c.ID,
+		Message:      "Opponent disconnected, waiting for them to reconnect",
+		GraceSeconds: int(h.reconnectGrace / time.Second),
+		Deadline:     deadline.UnixMilli(),
+	})
+
+	playerID := c.ID
+	h.grace.start(room.ID, playerID, h.reconnectGrace, func() {
+		if room.HasClient(playerID) {
+			return
+		}
+		log.Printf("Player %s did not return to room %s", playerID, room.ID)
+		gameOver, err := h.gameService.Abandon(room.ID, playerID)
+		if err != nil {
+			log.Printf("Failed to forfeit abandoned game of %s in room %s: %v", playerID, room.ID, err)
+			return
+		}
+		h.gameEnded(room, gameOver)
+	})
+}
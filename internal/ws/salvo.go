@@ -0,0 +1,32 @@
+package ws
+
+import (
+	"log"
+
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// salvo fires every shot of a salvo game turn at once and reports the
+// results in a single salvo_result message.
+func (h *Handler) salvo(c *wsPkg.Client, msg protocol.Salvo) error {
+	log.Printf("Processing salvo from %s: %v", c.ID, msg.Coordinates)
+	attacks, sunkShips, gameOver, state, err := h.gameService.ProcessSalvo(c.Room.ID, c.ID, msg.Coordinates)
+	if err != nil {
+		log.Printf("Salvo error for %s: %v", c.ID, err)
+		return err
+	}
+
+	nextTurn := ""
+	if state != nil {
+		nextTurn = state.Turn
+	}
+	broadcastSalvo(c.Room, c.ID, attacks, sunkShips, nextTurn)
+	if gameOver != nil {
+		h.gameEnded(c.Room, gameOver)
+	} else {
+		h.timers.Schedule(c.Room.ID, state)
+		broadcastTurn(c.Room, state)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/gorilla/websocket"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// spectate lets a player who is not in the room watch its game. Spectators
+// get the public state of the game, then what players are told of every
+// move, the room's delay late. They never see ship positions before
+// game_over, and anything they send is ignored.
+func (h *Handler) spectate(conn *websocket.Conn, playerID string, room *wsPkg.Room) {
+	view, err := h.gameService.SpectatorView(room.ID)
+	if err != nil {
+		log.Printf("Player %s cannot watch room %s: %v", playerID, room.ID, err)
+		conn.Close()
+		return
+	}
+
+	client := &wsPkg.Client{
+		ID:      playerID,
+		Conn:    conn,
+		Send:    make(chan []byte, 32),
+		Version: protocol.VersionOf(conn.Subprotocol()),
+	}
+	go h.write(client)
+	sendJSON(client, protocol.Spectating{
+		Type:    protocol.TypeSpectating,
+		RoomID:  room.ID,
+		DelayMs: room.Delay.Milliseconds(),
+	})
+	// The state as of now is shown the delay late, like the moves after it
+	snapshot, err := json.Marshal(protocol.SpectatorState{
+		Type:  protocol.TypeSpectatorState,
+		State: view,
+	})
+	count := room.AddSpectator(client)
+	if err == nil {
+		room.SendSpectator(client, snapshot)
+	}
+	go h.readSpectator(client)
+	log.Printf("Player %s is watching room %s", playerID, room.ID)
+	broadcastSpectators(room, count)
+}
+
+// readSpectator discards what a spectator sends until they leave.
+func (h *Handler) readSpectator(c *wsPkg.Client) {
+	defer func() {
+		count := c.Room.RemoveSpectator(c)
+		log.Printf("Spectator %s left room %s", c.ID, c.Room.ID)
+		broadcastSpectators(c.Room, count)
+		// Nothing is sent to a spectator once removed, so write can finish
+		c.Close()
+		c.Conn.Close()
+	}()
+	for {
+		if _, _, err := c.Conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func spectatorCount(n int) protocol.Spectators {
+	return protocol.Spectators{
+		Type:  protocol.TypeSpectators,
+		Count: n,
+	}
+}
+
+// broadcastSpectators tells the players, and the spectators themselves, how
+// many spectators watch the room.
+func broadcastSpectators(room *wsPkg.Room, count int) {
+	msg, err := json.Marshal(spectatorCount(count))
+	if err != nil {
+		return
+	}
+	room.Broadcast("", msg)
+	room.BroadcastSpectators(msg)
+}
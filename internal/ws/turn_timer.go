@@ -0,0 +1,102 @@
+package ws
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
+	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
+)
+
+// TurnTimers fires the time control of timed games. Each room has at most one
+// timer, armed for the deadline of the current turn. Timers are only a
+// trigger: the game service checks that the turn really expired, so a stale
+// timer, or one armed by another server for the same room, does nothing.
+type TurnTimers struct {
+	hub         *wsPkg.Hub
+	gameService *game.Service
+	mu          sync.Mutex
+	timers      map[string]*time.Timer
+}
+
+func NewTurnTimers(hub *wsPkg.Hub, gameService *game.Service) *TurnTimers {
+	return &TurnTimers{
+		hub:         hub,
+		gameService: gameService,
+		timers:      make(map[string]*time.Timer),
+	}
+}
+
+// Schedule arms the timer of a room for the turn in state, replacing any
+// earlier timer. A nil state or an untimed game cancels it.
+func (t *TurnTimers) Schedule(roomID string, state *game.GameState) {
+	if state == nil || state.Deadline == 0 {
+		t.Cancel(roomID)
+		return
+	}
+	player, deadline := state.Turn, state.Deadline
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timer, ok := t.timers[roomID]; ok {
+		timer.Stop()
+	}
+	delay := time.Until(time.UnixMilli(deadline))
+	t.timers[roomID] = time.AfterFunc(delay, func() {
+		t.expire(roomID, player, deadline)
+	})
+}
+
+// Cancel stops the timer of a room.
+func (t *TurnTimers) Cancel(roomID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if timer, ok := t.timers[roomID]; ok {
+		timer.Stop()
+		delete(t.timers, roomID)
+	}
+}
+
+func (t *TurnTimers) expire(roomID, player string, deadline int64) {
+	result, err := t.gameService.HandleTimeout(roomID, player, deadline)
+	if err != nil {
+		log.Printf("Failed to handle turn timeout in room %s: %v", roomID, err)
+		return
+	}
+	if result == nil {
+		// The player moved in time; the timer for the new turn is armed
+		return
+	}
+
+	if result.GameOver != nil {
+		t.Cancel(roomID)
+	} else {
+		t.Schedule(roomID, result.State)
+	}
+
+	room, ok := t.hub.GetRoom(roomID)
+	if !ok {
+		return
+	}
+	broadcastPublic(room, protocol.TurnTimeout{
+		Type:     protocol.TypeTurnTimeout,
+		PlayerID: player,
+		Action:   result.Action,
+	})
+	nextTurn := ""
+	if result.State != nil {
+		nextTurn = result.State.Turn
+	}
+	if result.Attack != nil {
+		broadcastAttack(room, player, result.Attack, result.SunkShips, nextTurn)
+	} else if result.Salvo != nil {
+		broadcastSalvo(room, player, result.Salvo, result.SunkShips, nextTurn)
+	}
+	if result.GameOver != nil {
+		broadcastGameOver(room, result.GameOver)
+	} else {
+		broadcastTurn(room, result.State)
+	}
+}
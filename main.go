@@ -4,12 +4,18 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/krishanu7/battleship-backend/config"
+	schema "github.com/krishanu7/battleship-backend/db"
 	"github.com/krishanu7/battleship-backend/internal/auth"
 	"github.com/krishanu7/battleship-backend/internal/game"
+	"github.com/krishanu7/battleship-backend/internal/history"
 	"github.com/krishanu7/battleship-backend/internal/match"
+	"github.com/krishanu7/battleship-backend/internal/notify"
+	"github.com/krishanu7/battleship-backend/internal/replay"
+	"github.com/krishanu7/battleship-backend/internal/store"
 	"github.com/krishanu7/battleship-backend/internal/ws"
 	"github.com/krishanu7/battleship-backend/pkg/redis"
 	wsPkg "github.com/krishanu7/battleship-backend/pkg/websocket"
@@ -25,46 +31,114 @@ func main() {
 		log.Fatal("Failed to connect database:", err)
 	}
 	defer db.Close()
+	if err := schema.Migrate(db); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
 
-	// Connect to Redis
-	rdb := redis.NewRedisClient()
+	// Connect to the game state store. The in-memory store runs the whole
+	// server, matchmaker included, as a single process without Redis.
+	var st store.Store
+	if cfg.StoreBackend == "memory" {
+		log.Println("Using in-memory store")
+		st = store.NewMemory()
+	} else {
+		st = store.NewRedis(redis.NewRedisClient())
+	}
 
 	// Initialize Services & Handlers
 	authService := auth.NewService(db, cfg)
 	authHandler := auth.NewAuthHandler(authService)
+	go authService.RunGuestReaper(time.Hour)
 
-	matchService := match.NewService(rdb)
+	matchService := match.NewService(st, db)
 	matchChan := make(chan match.MatchResult)
 	matchHandler := match.NewHandler(matchService, matchChan)
+	if cfg.StoreBackend == "memory" {
+		go matchService.RunMatchmaker(matchChan)
+		go matchService.PublishMatches(matchChan)
+		go matchService.RunReaper(15*time.Second, cfg.QueueMaxWait)
+	}
 
-	gameService := game.NewService(rdb,db)
+	historyService := history.NewService(db)
+	historyHandler := history.NewHandler(historyService)
+	replayHandler := replay.NewHandler(historyService)
+
+	gameService := game.NewService(st, db, historyService, game.TimeControl{
+		TurnSeconds: cfg.TurnSeconds,
+		BankSeconds: cfg.BankSeconds,
+		OnTimeout:   cfg.TimeoutAction,
+	})
 	gameHandler := game.NewHandler(gameService)
 
-	hub := wsPkg.NewHub()
-	wsHandler := ws.NewHandler(hub, gameService)
+	wsLimits := wsPkg.Limits{
+		PongWait:       cfg.WSPongWait,
+		WriteWait:      cfg.WSWriteWait,
+		MaxMessageSize: cfg.WSMaxMessageBytes,
+	}
+	hub := wsPkg.NewHub(st, st, cfg.SpectatorDelay)
+	turnTimers := ws.NewTurnTimers(hub, gameService)
+	wsHandler := ws.NewHandler(hub, gameService, turnTimers, cfg.ReconnectGrace, wsLimits)
 
 	generalHub := wsPkg.NewGeneralHub()
-	generalWsHandler := ws.NewGeneralHandler(generalHub)
+	notifier := notify.NewService(st)
+	notifyHandler := notify.NewHandler(notifier)
+	generalWsHandler := ws.NewGeneralHandler(generalHub, st, gameService, notifier, wsLimits)
 	
 	// Start notification worker
-	notificationWorker := ws.NewNotificationWorker(rdb, generalHub, gameService)
+	notificationWorker := ws.NewNotificationWorker(st, generalHub, gameService, notifier, turnTimers)
 	go notificationWorker.Run()
+
+	// Start bot worker
+	botWorker := ws.NewBotWorker(st, wsHandler)
+	go botWorker.Run()
 	
 	// Route Handlers
 	r := mux.NewRouter()
 	r.HandleFunc("/api/v1/auth/register", authHandler.Register).Methods("POST")
 	r.HandleFunc("/api/v1/auth/login", authHandler.Login).Methods("POST")
+	r.HandleFunc("/api/v1/auth/guest", authHandler.Guest).Methods("POST")
+	r.HandleFunc("/api/v1/auth/refresh", authHandler.Refresh).Methods("POST")
+	r.HandleFunc("/api/v1/auth/logout", authHandler.Logout).Methods("POST")
+
+	// Routes below require a valid access token
+	protected := r.NewRoute().Subrouter()
+	protected.Use(authService.Middleware)
+
+	protected.HandleFunc("/api/v1/auth/logout-all", authHandler.LogoutAll).Methods("POST")
+	protected.HandleFunc("/api/v1/auth/upgrade", authHandler.Upgrade).Methods("POST")
+
+	protected.HandleFunc("/api/v1/match/join", matchHandler.JoinQueue).Methods("POST")
+	protected.HandleFunc("/api/v1/match/leave", matchHandler.LeaveQueue).Methods("POST")
+	protected.HandleFunc("/api/v1/match/start", matchHandler.StartMatch).Methods("POST")
+	protected.HandleFunc("/api/v1/match/cancel", matchHandler.CancelMatch).Methods("POST")
+	protected.HandleFunc("/api/v1/match/status", matchHandler.GetMatchStatus).Methods("GET")
+	protected.HandleFunc("/api/v1/match/position", matchHandler.GetQueuePosition).Methods("GET")
+	protected.HandleFunc("/api/v1/match/metrics", matchHandler.GetMetrics).Methods("GET")
+	protected.HandleFunc("/api/v1/match/bot", matchHandler.PlayBot).Methods("POST")
+
+	protected.HandleFunc("/api/v1/lobbies", matchHandler.CreateLobby).Methods("POST")
+	protected.HandleFunc("/api/v1/lobbies/{code}", matchHandler.GetLobby).Methods("GET")
+	protected.HandleFunc("/api/v1/lobbies/{code}/join", matchHandler.JoinLobby).Methods("POST")
+	protected.HandleFunc("/api/v1/lobbies/{code}/leave", matchHandler.LeaveLobby).Methods("POST")
+	protected.HandleFunc("/api/v1/lobbies/{code}/rules", matchHandler.SetLobbyRules).Methods("PUT")
+	protected.HandleFunc("/api/v1/lobbies/{code}/start", matchHandler.StartLobby).Methods("POST")
+
+	protected.HandleFunc("/api/v1/game/place-ships", gameHandler.PlaceShips).Methods("POST")
+	protected.HandleFunc("/api/v1/game/validate-placement", gameHandler.ValidatePlacement).Methods("POST")
+	protected.HandleFunc("/api/v1/game/random-fleet", gameHandler.RandomFleet).Methods("POST")
+	protected.HandleFunc("/api/v1/games/live", gameHandler.LiveGames).Methods("GET")
 
-	r.HandleFunc("/api/v1/match/join", matchHandler.JoinQueue).Methods("POST")
-	r.HandleFunc("/api/v1/match/leave", matchHandler.LeaveQueue).Methods("POST")
-	r.HandleFunc("/api/v1/match/start", matchHandler.StartMatch).Methods("POST")
-	r.HandleFunc("/api/v1/match/cancel", matchHandler.CancelMatch).Methods("POST")
-	r.HandleFunc("/api/v1/match/status", matchHandler.GetMatchStatus).Methods("GET")
+	protected.HandleFunc("/api/v1/notifications", notifyHandler.Unread).Methods("GET")
+	protected.HandleFunc("/api/v1/notifications/ack", notifyHandler.Ack).Methods("POST")
 
-	r.HandleFunc("/api/v1/game/place-ships", gameHandler.PlaceShips).Methods("POST")
+	protected.HandleFunc("/api/v1/matches/{id}", historyHandler.GetMatch).Methods("GET")
+	protected.HandleFunc("/api/v1/matches/{id}/events", replayHandler.Events).Methods("GET")
+	protected.HandleFunc("/api/v1/matches/{id}/replay", replayHandler.Download).Methods("GET")
+	protected.HandleFunc("/api/v1/players/{id}/matches", historyHandler.ListPlayerMatches).Methods("GET")
+	protected.HandleFunc("/api/v1/replays/import", replayHandler.Import).Methods("POST")
 
-	r.HandleFunc("/ws", wsHandler.ServeWS).Methods("GET")
-	r.HandleFunc("/ws/general", generalWsHandler.ServeGeneralWS).Methods("GET")
+	protected.HandleFunc("/ws", wsHandler.ServeWS).Methods("GET")
+	protected.HandleFunc("/ws/general", generalWsHandler.ServeGeneralWS).Methods("GET")
 
 	// Start Server
 	log.Println("Server starting on :8080")
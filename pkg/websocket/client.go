@@ -1,6 +1,9 @@
 package websocket
 
 import (
+	"log"
+	"sync"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -9,4 +12,39 @@ type Client struct {
 	Conn *websocket.Conn
 	Send chan []byte
 	Room *Room
+	// Spectator clients watch the room read-only; they are not among the
+	// room's Clients.
+	Spectator bool
+	// announced is set once the room has seen its own join of the client
+	// come back from the other servers.
+	announced bool
+	// Version is the protocol version the client speaks.
+	Version int
+
+	closeOnce sync.Once
+}
+
+// Queue queues message for the client without blocking. A client that does
+// not keep up is disconnected rather than allowed to hold up everyone
+// sending to it. It reports whether the message was queued.
+func (c *Client) Queue(message []byte) bool {
+	select {
+	case c.Send <- message:
+		return true
+	default:
+		log.Printf("Client %s is not keeping up, disconnecting", c.ID)
+		if c.Conn != nil {
+			c.Conn.Close()
+		}
+		return false
+	}
+}
+
+// Close closes Send, which ends the client's writer. It must only be called
+// once the client is no longer registered anywhere messages are sent from,
+// and may be called more than once.
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.Send)
+	})
 }
@@ -0,0 +1,184 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+// roomEventsChannel carries what happens in a room to the servers holding
+// it, so the two players of a room and its spectators can be connected to
+// different servers. Each room has its own channel, so a server only hears
+// about the rooms it holds.
+func roomEventsChannel(roomID string) string {
+	return "room_events:" + roomID
+}
+
+const (
+	// eventBroadcast is a message for the players, eventSpectators one for
+	// the spectators.
+	eventBroadcast  = "broadcast"
+	eventSpectators = "spectators"
+	// eventJoin and eventLeave follow the players connected to a server,
+	// eventSpectatorJoin and eventSpectatorLeave its spectators.
+	eventJoin           = "join"
+	eventLeave          = "leave"
+	eventSpectatorJoin  = "spectator_join"
+	eventSpectatorLeave = "spectator_leave"
+	// eventHello asks the other servers of a room to describe their clients
+	// of it, which they do with eventState.
+	eventHello = "hello"
+	eventState = "state"
+)
+
+type roomEvent struct {
+	Room       string          `json:"room"`
+	Origin     string          `json:"origin"` // server that published it
+	Kind       string          `json:"kind"`
+	Sender     string          `json:"sender,omitempty"`
+	Player     string          `json:"player,omitempty"`
+	Players    []string        `json:"players,omitempty"`
+	Spectators int             `json:"spectators,omitempty"`
+	Message    json.RawMessage `json:"message,omitempty"`
+}
+
+// remoteRoom is what another server holds of a room.
+type remoteRoom struct {
+	players    map[string]bool
+	spectators int
+}
+
+// listen applies the events published for the room until the
+// subscription closes.
+func (r *Room) listen(sub store.Subscription) {
+	defer sub.Close()
+
+	for payload := range sub.Messages() {
+		var event roomEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			log.Printf("Failed to unmarshal event of room %s: %v", r.ID, err)
+			continue
+		}
+		r.receive(event)
+	}
+	log.Printf("Event subscription of room %s closed", r.ID)
+}
+
+// publish sends an event of the room to the other servers.
+func (r *Room) publish(event roomEvent) {
+	if r.hub == nil {
+		return
+	}
+	event.Room = r.ID
+	event.Origin = r.hub.instance
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to marshal %s event of room %s: %v", event.Kind, r.ID, err)
+		return
+	}
+	if err := r.hub.events.Publish(context.Background(), roomEventsChannel(r.ID), payload); err != nil {
+		log.Printf("Failed to publish %s event of room %s: %v", event.Kind, r.ID, err)
+	}
+}
+
+// receive applies an event published for the room by any server, this one
+// included.
+func (r *Room) receive(event roomEvent) {
+	if event.Origin == r.hub.instance {
+		if event.Kind == eventJoin {
+			r.mu.Lock()
+			if c, ok := r.Clients[event.Player]; ok {
+				c.announced = true
+			}
+			r.mu.Unlock()
+		}
+		return
+	}
+
+	switch event.Kind {
+	case eventBroadcast:
+		r.mu.Lock()
+		for id, c := range r.Clients {
+			if id != event.Sender {
+				c.Queue(event.Message)
+			}
+		}
+		r.mu.Unlock()
+	case eventSpectators:
+		r.sendSpectators(nil, event.Message)
+	case eventJoin:
+		r.remoteJoined(event.Origin, event.Player)
+		if r.hub.OnRemoteJoin != nil {
+			r.hub.OnRemoteJoin(r, event.Player)
+		}
+	case eventLeave:
+		r.mu.Lock()
+		delete(r.remoteOf(event.Origin).players, event.Player)
+		r.mu.Unlock()
+	case eventSpectatorJoin, eventSpectatorLeave:
+		r.mu.Lock()
+		remote := r.remoteOf(event.Origin)
+		if event.Kind == eventSpectatorJoin {
+			remote.spectators++
+		} else if remote.spectators > 0 {
+			remote.spectators--
+		}
+		r.mu.Unlock()
+	case eventHello:
+		r.mu.Lock()
+		players := make([]string, 0, len(r.Clients))
+		for id := range r.Clients {
+			players = append(players, id)
+		}
+		spectators := len(r.Spectators)
+		r.mu.Unlock()
+		if len(players) > 0 || spectators > 0 {
+			r.publish(roomEvent{Kind: eventState, Players: players, Spectators: spectators})
+		}
+	case eventState:
+		r.mu.Lock()
+		remote := &remoteRoom{players: make(map[string]bool), spectators: event.Spectators}
+		for _, id := range event.Players {
+			remote.players[id] = true
+		}
+		r.remote[event.Origin] = remote
+		r.mu.Unlock()
+	}
+}
+
+// remoteJoined records a player connecting to the room on another server.
+// A connection of the same player here is replaced, like on a reconnect to
+// this server, unless it was made after the remote one: events reach every
+// server in the same order, so a client whose own join has not come back
+// yet is the newer one.
+func (r *Room) remoteJoined(origin, playerID string) {
+	r.mu.Lock()
+	r.remoteOf(origin).players[playerID] = true
+	c, ok := r.Clients[playerID]
+	replaced := ok && c.announced
+	if replaced {
+		delete(r.Clients, playerID)
+	}
+	r.mu.Unlock()
+	if !replaced {
+		return
+	}
+	log.Printf("Client %s of room %s moved to another server", playerID, r.ID)
+	r.publish(roomEvent{Kind: eventLeave, Player: playerID})
+	if c.Conn != nil {
+		c.Conn.Close()
+	}
+}
+
+// remoteOf returns what the given server holds of the room. Callers must
+// hold mu.
+func (r *Room) remoteOf(origin string) *remoteRoom {
+	remote, ok := r.remote[origin]
+	if !ok {
+		remote = &remoteRoom{players: make(map[string]bool)}
+		r.remote[origin] = remote
+	}
+	return remote
+}
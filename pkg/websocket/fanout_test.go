@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/krishanu7/battleship-backend/internal/store"
+)
+
+// newTestClient is a client without a connection whose messages can be read
+// from Send.
+func newTestClient(id string) *Client {
+	return &Client{ID: id, Send: make(chan []byte, 16)}
+}
+
+// eventually waits up to a second for cond to hold.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, c *Client) string {
+	t.Helper()
+	select {
+	case message := <-c.Send:
+		return string(message)
+	case <-time.After(time.Second):
+		t.Fatalf("%s got no message", c.ID)
+		return ""
+	}
+}
+
+func noMessage(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case message := <-c.Send:
+		t.Errorf("%s got unexpected message %s", c.ID, message)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestTwoHubs connects the two players of a room to different servers
+// sharing one store.
+func TestTwoHubs(t *testing.T) {
+	st := store.NewMemory()
+	ctx := context.Background()
+	for roomID, players := range map[string][]string{"room1": {"p1", "p2"}, "room2": {"p3", "p4"}} {
+		if err := st.CreateRoom(ctx, roomID, players, time.Hour); err != nil {
+			t.Fatalf("CreateRoom: %v", err)
+		}
+	}
+	hubA := NewHub(st, st, 0)
+	hubB := NewHub(st, st, 0)
+
+	roomA, ok := hubA.GetRoom("room1")
+	if !ok {
+		t.Fatal("room1 not found on hub A")
+	}
+	roomB, ok := hubB.GetRoom("room1")
+	if !ok {
+		t.Fatal("room1 not found on hub B")
+	}
+	other, ok := hubB.GetRoom("room2")
+	if !ok {
+		t.Fatal("room2 not found on hub B")
+	}
+
+	p1, p2, p3 := newTestClient("p1"), newTestClient("p2"), newTestClient("p3")
+	roomA.AddClient(p1)
+	roomB.AddClient(p2)
+	other.AddClient(p3)
+	eventually(t, "hub A to see p2", func() bool { return roomA.HasClient("p2") })
+	eventually(t, "hub B to see p1", func() bool { return roomB.HasClient("p1") })
+	if roomA.HasClient("p3") {
+		t.Error("hub A sees p3 of another room")
+	}
+
+	roomA.Broadcast("p1", []byte(`{"type":"from_p1"}`))
+	if got := receive(t, p2); got != `{"type":"from_p1"}` {
+		t.Errorf("p2 got %q, want %q", got, `{"type":"from_p1"}`)
+	}
+	noMessage(t, p1)
+	noMessage(t, p3)
+
+	roomB.Broadcast("p2", []byte(`{"type":"from_p2"}`))
+	if got := receive(t, p1); got != `{"type":"from_p2"}` {
+		t.Errorf("p1 got %q, want %q", got, `{"type":"from_p2"}`)
+	}
+
+	// Spectators on either server see the spectator broadcasts of the other
+	watcher := newTestClient("watcher")
+	roomB.AddSpectator(watcher)
+	eventually(t, "hub A to count the spectator", func() bool { return roomA.SpectatorCount() == 1 })
+	roomA.BroadcastSpectators([]byte(`{"type":"to_spectators"}`))
+	if got := receive(t, watcher); got != `{"type":"to_spectators"}` {
+		t.Errorf("spectator got %q, want %q", got, `{"type":"to_spectators"}`)
+	}
+
+	// Leaving is seen by the other server too
+	roomB.RemoveClient(p2)
+	eventually(t, "hub A to see p2 leave", func() bool { return !roomA.HasClient("p2") })
+}
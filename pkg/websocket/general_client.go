@@ -1,6 +1,8 @@
 package websocket
 
 import (
+	"sync"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -8,4 +10,16 @@ type GeneralClient struct {
 	ID   string
 	Conn *websocket.Conn
 	Send chan []byte
+	// Version is the protocol version the client speaks.
+	Version int
+
+	closeOnce sync.Once
+}
+
+// Close closes Send once the client is no longer in the GeneralHub, which
+// ends its writer. It may be called more than once.
+func (c *GeneralClient) Close() {
+	c.closeOnce.Do(func() {
+		close(c.Send)
+	})
 }
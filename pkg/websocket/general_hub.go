@@ -16,20 +16,33 @@ func NewGeneralHub() *GeneralHub {
 	}
 }
 
+// AddClient registers c. An earlier client of the same player is replaced
+// and closed, which ends its connection.
 func (h *GeneralHub) AddClient(c *GeneralClient) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if previous, ok := h.Clients[c.ID]; ok && previous != c {
+		// Under mu, so SendToClient cannot be sending to it
+		previous.Close()
+		log.Printf("General client %s replaced by a newer connection", c.ID)
+	}
 	h.Clients[c.ID] = c
 	log.Printf("General client %s connected, total clients: %d", c.ID, len(h.Clients))
 }
 
-func (h *GeneralHub) RemoveClient(c *GeneralClient) {
+// RemoveClient unregisters c unless the player has already reconnected with
+// a newer client. It reports whether c was removed.
+func (h *GeneralHub) RemoveClient(c *GeneralClient) bool {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	if h.Clients[c.ID] != c {
+		return false
+	}
 	delete(h.Clients, c.ID)
 	log.Printf("General client %s disconnected, total clients: %d", c.ID, len(h.Clients))
+	return true
 }
 
 func (h *GeneralHub) SendToClient(playerID string, message []byte) bool {
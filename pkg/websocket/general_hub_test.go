@@ -0,0 +1,23 @@
+package websocket
+
+import "testing"
+
+func TestGeneralHubReplacesClient(t *testing.T) {
+	h := NewGeneralHub()
+	old := &GeneralClient{ID: "p1", Send: make(chan []byte, 1)}
+	h.AddClient(old)
+	newer := &GeneralClient{ID: "p1", Send: make(chan []byte, 1)}
+	h.AddClient(newer)
+
+	if _, open := <-old.Send; open {
+		t.Error("replaced client was not closed")
+	}
+	if !h.SendToClient("p1", []byte("hi")) || string(<-newer.Send) != "hi" {
+		t.Error("message did not reach the newer client")
+	}
+	// The old connection going away leaves the newer one registered
+	if h.RemoveClient(old) {
+		t.Error("RemoveClient removed the newer client")
+	}
+	old.Close()
+}
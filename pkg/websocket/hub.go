@@ -1,27 +1,49 @@
 package websocket
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"sync"
+	"time"
 
-	redisM "github.com/redis/go-redis/v9"
-	"github.com/krishanu7/battleship-backend/pkg/redis"
+	"github.com/krishanu7/battleship-backend/internal/store"
 )
 
 
+// Hub holds the rooms with clients connected to this server. Each room
+// shares its events with the other servers holding it through events.
 type Hub struct {
 	Rooms map[string]*Room
 	mu    sync.Mutex
-	rdb   *redisM.Client
+	rooms store.RoomStore
+	// spectatorDelay holds back what spectators of every room see
+	spectatorDelay time.Duration
+	events         store.PubSub
+	instance       string
+	// OnRemoteJoin, if set, is called when a player connects to a room of
+	// this server on another server.
+	OnRemoteJoin func(room *Room, playerID string)
 }
 
-func NewHub() *Hub {
+func NewHub(rooms store.RoomStore, events store.PubSub, spectatorDelay time.Duration) *Hub {
 	return &Hub{
-		Rooms: make(map[string]*Room),
-		rdb:   redis.NewRedisClient(),
+		Rooms:          make(map[string]*Room),
+		rooms:          rooms,
+		spectatorDelay: spectatorDelay,
+		events:         events,
+		instance:       newInstanceID(),
 	}
 }
 
+// newInstanceID names this server in the events it publishes.
+func newInstanceID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 func (h *Hub) GetRoom(roomID string) (*Room, bool) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -31,18 +53,24 @@ func (h *Hub) GetRoom(roomID string) (*Room, bool) {
 		return room, true
 	}
 
-	players, err := h.rdb.SMembers(redis.Ctx, "room:"+roomID).Result()
+	players, err := h.rooms.RoomPlayers(context.Background(), roomID)
 	if err != nil {
-		log.Printf("Failed to check room %s in Redis: %v", roomID, err)
+		log.Printf("Failed to check room %s in store: %v", roomID, err)
 		return nil, false
 	}
 	if len(players) == 0 {
-		log.Printf("Room %s not found in Redis", roomID)
+		log.Printf("Room %s not found in store", roomID)
 		return nil, false
 	}
 
-	room := NewRoom(roomID)
+	room := NewRoom(roomID, h.spectatorDelay)
+	room.hub = h
 	h.Rooms[roomID] = room
 	log.Printf("Initialized room %s in Hub with players: %v", roomID, players)
+	// Subscribe before saying hello so the answers are not missed, then
+	// learn who is connected to the room elsewhere
+	sub := h.events.Subscribe(context.Background(), roomEventsChannel(roomID))
+	go room.listen(sub)
+	go room.publish(roomEvent{Kind: eventHello})
 	return room, true
-}
\ No newline at end of file
+}
@@ -0,0 +1,84 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Limits keep dead and misbehaving connections from lingering. The server
+// pings every connection and drops it when no pong comes back within
+// PongWait, gives up on writes that take longer than WriteWait, and closes
+// connections that send a message over MaxMessageSize bytes. Zero values
+// disable each limit.
+type Limits struct {
+	PongWait       time.Duration
+	WriteWait      time.Duration
+	MaxMessageSize int64
+}
+
+// PingPeriod is how often to ping, leaving time for the pong to arrive
+// before PongWait runs out. It is 0 when pings are disabled.
+func (l Limits) PingPeriod() time.Duration {
+	return l.PongWait * 9 / 10
+}
+
+// Prepare applies the read limits to a new connection.
+func (l Limits) Prepare(conn *websocket.Conn) {
+	if l.MaxMessageSize > 0 {
+		conn.SetReadLimit(l.MaxMessageSize)
+	}
+	if l.PongWait > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.PongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(l.PongWait))
+		})
+	}
+}
+
+// WriteLoop writes what is sent on send to conn, and pings it every
+// PingPeriod, until send is closed or a write fails. It then closes conn.
+// encode turns a queued message into the frame to write; returning an error
+// skips the message.
+func (l Limits) WriteLoop(conn *websocket.Conn, send <-chan []byte, encode func([]byte) ([]byte, error)) error {
+	defer conn.Close()
+
+	var ping <-chan time.Time
+	if period := l.PingPeriod(); period > 0 {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+	for {
+		select {
+		case msg, ok := <-send:
+			if !ok {
+				// The client was unregistered; say goodbye
+				conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), l.deadline())
+				return nil
+			}
+			frame, err := encode(msg)
+			if err != nil {
+				continue
+			}
+			conn.SetWriteDeadline(l.deadline())
+			if err := conn.WriteMessage(websocket.TextMessage, frame); err != nil {
+				return err
+			}
+		case <-ping:
+			conn.SetWriteDeadline(l.deadline())
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// deadline is when a write started now must be done by; the zero time is
+// no deadline.
+func (l Limits) deadline() time.Time {
+	if l.WriteWait <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(l.WriteWait)
+}
@@ -2,29 +2,204 @@ package websocket
 
 import (
 	"log"
+	"sync"
+	"time"
 )
 
 type Room struct {
 	ID      string
 	Clients map[string]*Client
+	mu      sync.Mutex
+
+	// Spectators are sent the public part of the game Delay late, so they
+	// cannot relay what they see to a player in time to matter.
+	Spectators map[*Client]struct{}
+	Delay      time.Duration
+	pending    []spectatorMessage
+	feeding    bool
+
+	// hub relays the room's events to the other servers, and remote is
+	// what they report of their own clients of the room, by server.
+	hub    *Hub
+	remote map[string]*remoteRoom
+}
+
+// spectatorMessage is a message held back for spectators until at. A nil
+// to is for every spectator.
+type spectatorMessage struct {
+	at      time.Time
+	to      *Client
+	message []byte
 }
 
-func NewRoom(id string) *Room {
+func NewRoom(id string, delay time.Duration) *Room {
 	return &Room{
-		ID:      id,
-		Clients: make(map[string]*Client),
+		ID:         id,
+		Clients:    make(map[string]*Client),
+		Spectators: make(map[*Client]struct{}),
+		Delay:      delay,
+		remote:     make(map[string]*remoteRoom),
 	}
 }
 
+// Broadcast sends message to every player of the room except senderID,
+// wherever they are connected.
 func (r *Room) Broadcast(senderID string, message []byte) {
+	r.mu.Lock()
 	for id, client := range r.Clients {
 		if id != senderID {
-			client.Send <- message
+			client.Queue(message)
 		}
 	}
+	r.mu.Unlock()
+	r.publish(roomEvent{Kind: eventBroadcast, Sender: senderID, Message: message})
 }
-func (r *Room) AddClient(c *Client) {
+
+// AddClient registers c, replacing any earlier connection of the same player.
+// It returns the replaced client, if any.
+func (r *Room) AddClient(c *Client) *Client {
+	r.mu.Lock()
+	previous := r.Clients[c.ID]
 	r.Clients[c.ID] = c
 	c.Room = r
+	r.mu.Unlock()
 	log.Printf("Client %s joined room %s", c.ID, r.ID)
-}
\ No newline at end of file
+	r.publish(roomEvent{Kind: eventJoin, Player: c.ID})
+	return previous
+}
+
+// RemoveClient unregisters c unless the player has already reconnected with
+// a newer client. It reports whether c was removed.
+func (r *Room) RemoveClient(c *Client) bool {
+	r.mu.Lock()
+	if r.Clients[c.ID] != c {
+		r.mu.Unlock()
+		return false
+	}
+	delete(r.Clients, c.ID)
+	r.mu.Unlock()
+	r.publish(roomEvent{Kind: eventLeave, Player: c.ID})
+	return true
+}
+
+// HasClient reports whether the player is currently connected to the room,
+// on this server or another.
+func (r *Room) HasClient(playerID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.Clients[playerID]; ok {
+		return true
+	}
+	for _, remote := range r.remote {
+		if remote.players[playerID] {
+			return true
+		}
+	}
+	return false
+}
+
+// AddSpectator registers c as a spectator and returns the spectator count.
+func (r *Room) AddSpectator(c *Client) int {
+	r.mu.Lock()
+	c.Room = r
+	c.Spectator = true
+	r.Spectators[c] = struct{}{}
+	count := r.spectatorCount()
+	r.mu.Unlock()
+	log.Printf("Spectator %s joined room %s", c.ID, r.ID)
+	r.publish(roomEvent{Kind: eventSpectatorJoin})
+	return count
+}
+
+// RemoveSpectator unregisters c and returns the spectator count.
+func (r *Room) RemoveSpectator(c *Client) int {
+	r.mu.Lock()
+	_, ok := r.Spectators[c]
+	delete(r.Spectators, c)
+	count := r.spectatorCount()
+	r.mu.Unlock()
+	if ok {
+		r.publish(roomEvent{Kind: eventSpectatorLeave})
+	}
+	return count
+}
+
+// SpectatorCount returns how many spectators watch the room, on every
+// server.
+func (r *Room) SpectatorCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.spectatorCount()
+}
+
+func (r *Room) spectatorCount() int {
+	n := len(r.Spectators)
+	for _, remote := range r.remote {
+		n += remote.spectators
+	}
+	return n
+}
+
+// BroadcastSpectators sends message to every spectator, wherever they are
+// connected, after the room's delay.
+func (r *Room) BroadcastSpectators(message []byte) {
+	r.sendSpectators(nil, message)
+	r.publish(roomEvent{Kind: eventSpectators, Message: message})
+}
+
+// SendSpectator sends message to one spectator after the room's delay, in
+// order with the broadcasts.
+func (r *Room) SendSpectator(c *Client, message []byte) {
+	r.sendSpectators(c, message)
+}
+
+func (r *Room) sendSpectators(to *Client, message []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.Delay <= 0 && !r.feeding {
+		r.deliver(to, message)
+		return
+	}
+	r.pending = append(r.pending, spectatorMessage{at: time.Now().Add(r.Delay), to: to, message: message})
+	if !r.feeding {
+		r.feeding = true
+		go r.feed()
+	}
+}
+
+// feed delivers the pending spectator messages in order, each at its time,
+// and stops once none are left.
+func (r *Room) feed() {
+	for {
+		r.mu.Lock()
+		if len(r.pending) == 0 {
+			r.feeding = false
+			r.mu.Unlock()
+			return
+		}
+		next := r.pending[0]
+		r.pending = r.pending[1:]
+		r.mu.Unlock()
+
+		time.Sleep(time.Until(next.at))
+		r.mu.Lock()
+		r.deliver(next.to, next.message)
+		r.mu.Unlock()
+	}
+}
+
+// deliver sends message to a spectator, or all of them for a nil to.
+// Spectators that cannot keep up miss messages rather than hold up the
+// room. Callers must hold mu.
+func (r *Room) deliver(to *Client, message []byte) {
+	for c := range r.Spectators {
+		if to != nil && c != to {
+			continue
+		}
+		select {
+		case c.Send <- message:
+		default:
+			log.Printf("Dropping message to spectator %s of room %s", c.ID, r.ID)
+		}
+	}
+}
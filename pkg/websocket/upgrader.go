@@ -3,11 +3,15 @@ package websocket
 import (
 	"net/http"
 	"github.com/gorilla/websocket"
+	"github.com/krishanu7/battleship-backend/internal/protocol"
 )
 
 var Upgrader = websocket.Upgrader{
 	ReadBufferSize: 1024,
 	WriteBufferSize: 1024,
+	// Agree on the versioned protocol when offered, else echo the "bearer"
+	// subprotocol used to carry the access token
+	Subprotocols: []string{protocol.Subprotocol, "bearer"},
 	CheckOrigin: func(r *http.Request) bool {
 		return true // TODO: Add origin validation
 	},